@@ -0,0 +1,526 @@
+package wechatpay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BillTypeAll     = "ALL"
+	BillTypeSuccess = "SUCCESS"
+	BillTypeRefund  = "REFUND"
+
+	AccountTypeBasic     = "BASIC"
+	AccountTypeOperation = "OPERATION"
+	AccountTypeFees      = "FEES"
+
+	TarTypeGzip = "GZIP"
+
+	billDateLayout = "2006-01-02"
+	billTimeLayout = "2006-01-02 15:04:05"
+)
+
+// ErrBillHashMismatch is returned when a downloaded bill or receipt does not match the
+// hash_value WeChat Pay announced for it.
+var ErrBillHashMismatch = errors.New("bill hash mismatch")
+
+type TradeBillRequest struct {
+	// BillDate is the day of the bill, formatted as 2006-01-02.
+	BillDate string
+	// BillType defaults to BillTypeAll.
+	BillType string
+	// TarType set to TarTypeGzip asks for a compressed file.
+	TarType string
+}
+
+type FundFlowBillRequest struct {
+	BillDate string
+	// AccountType defaults to AccountTypeBasic.
+	AccountType string
+	TarType     string
+}
+
+// BillDownload is the answer to a bill request: where to fetch the file and
+// the digest it must match.
+type BillDownload struct {
+	HashType    string `json:"hash_type"`
+	HashValue   string `json:"hash_value"`
+	DownloadURL string `json:"download_url"`
+}
+
+type TradeBill struct {
+	Rows    []TradeBillRow
+	Summary TradeBillSummary
+}
+
+// TradeBillRow is one line of a trade bill. Amounts are in fen; columns
+// missing from the requested bill type are left empty.
+type TradeBillRow struct {
+	TradeTime        time.Time
+	Appid            string
+	Mchid            string
+	SubMchid         string
+	DeviceInfo       string
+	TransactionID    string
+	OutTradeNo       string
+	Openid           string
+	TradeType        string
+	TradeState       string
+	BankType         string
+	Currency         string
+	SettlementTotal  int64
+	CouponAmount     int64
+	RefundID         string
+	OutRefundNo      string
+	SettlementRefund int64
+	CouponRefund     int64
+	RefundType       string
+	RefundStatus     string
+	Body             string
+	Attach           string
+	// Fee and Rate keep the bill's own precision, e.g. "0.00600" and "0.60%".
+	Fee           string
+	Rate          string
+	Total         int64
+	RefundApplied int64
+	RateRemark    string
+}
+
+type TradeBillSummary struct {
+	TradeCount         int
+	SettlementTotal    int64
+	RefundTotal        int64
+	CouponRefundTotal  int64
+	FeeTotal           string
+	OrderTotal         int64
+	RefundAppliedTotal int64
+}
+
+type FundFlowBill struct {
+	Rows    []FundFlowBillRow
+	Summary FundFlowBillSummary
+}
+
+// FundFlowBillRow is one line of a fund-flow bill. Amounts are in fen.
+type FundFlowBillRow struct {
+	AccountTime      time.Time
+	BizTransactionID string
+	FundFlowID       string
+	BizName          string
+	BizType          string
+	// FlowType is 收入 for income and 支出 for expense.
+	FlowType     string
+	Amount       int64
+	Balance      int64
+	Applicant    string
+	Remark       string
+	BizVoucherID string
+}
+
+type FundFlowBillSummary struct {
+	Count         int
+	IncomeCount   int
+	IncomeAmount  int64
+	ExpenseCount  int
+	ExpenseAmount int64
+}
+
+// TradeBill requests the trade bill of one day. Use DownloadBill to fetch it.
+func (c *Client) TradeBill(ctx context.Context, params *TradeBillRequest) (*BillDownload, error) {
+	if _, err := time.Parse(billDateLayout, params.BillDate); err != nil {
+		return nil, fmt.Errorf("invalid bill_date %q: %w", params.BillDate, err)
+	}
+
+	query := url.Values{}
+	query.Set("bill_date", params.BillDate)
+	query.Set("bill_type", params.BillType)
+	if params.BillType == "" {
+		query.Set("bill_type", BillTypeAll)
+	}
+	if params.TarType != "" {
+		query.Set("tar_type", params.TarType)
+	}
+	return c.requestBill(ctx, "/v3/bill/tradebill?"+query.Encode())
+}
+
+// FundFlowBill requests the fund-flow bill of one day and account.
+func (c *Client) FundFlowBill(ctx context.Context, params *FundFlowBillRequest) (*BillDownload, error) {
+	if _, err := time.Parse(billDateLayout, params.BillDate); err != nil {
+		return nil, fmt.Errorf("invalid bill_date %q: %w", params.BillDate, err)
+	}
+
+	query := url.Values{}
+	query.Set("bill_date", params.BillDate)
+	query.Set("account_type", params.AccountType)
+	if params.AccountType == "" {
+		query.Set("account_type", AccountTypeBasic)
+	}
+	if params.TarType != "" {
+		query.Set("tar_type", params.TarType)
+	}
+	return c.requestBill(ctx, "/v3/bill/fundflowbill?"+query.Encode())
+}
+
+func (c *Client) requestBill(ctx context.Context, path string) (*BillDownload, error) {
+	req, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var bill BillDownload
+	if err := json.NewDecoder(resp.Body).Decode(&bill); err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+// DownloadBill fetches the bill file, gunzips it when it was requested
+// compressed and checks it against the announced SHA1 hash_value. The
+// download endpoint does not sign its response, so the hash is the only
+// integrity check.
+func (c *Client) DownloadBill(ctx context.Context, bill *BillDownload) ([]byte, error) {
+	return c.download(ctx, bill.DownloadURL, bill.HashType, bill.HashValue)
+}
+
+// download fetches a signed download_url, gunzips it when needed and checks
+// the content against the SHA1 or SHA256 hash returned alongside the URL.
+func (c *Client) download(ctx context.Context, downloadURL, hashType, hashValue string) ([]byte, error) {
+	if downloadURL == "" {
+		return nil, errors.New("download_url is required")
+	}
+	var newHash func() hash.Hash
+	switch strings.ToUpper(hashType) {
+	case "", "SHA1":
+		newHash = sha1.New
+	case "SHA256":
+		newHash = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported hash_type: %s", hashType)
+	}
+
+	req, err := http.NewRequest("GET", downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.sendRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseAPIError(resp, data)
+	}
+
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h := newHash()
+	h.Write(data)
+	if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), hashValue) {
+		return nil, ErrBillHashMismatch
+	}
+	return data, nil
+}
+
+// DownloadTradeBill requests, downloads and parses the trade bill of one day.
+func (c *Client) DownloadTradeBill(ctx context.Context, params *TradeBillRequest) (*TradeBill, error) {
+	bill, err := c.TradeBill(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.DownloadBill(ctx, bill)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTradeBill(data)
+}
+
+// DownloadFundFlowBill requests, downloads and parses the fund-flow bill of one day.
+func (c *Client) DownloadFundFlowBill(ctx context.Context, params *FundFlowBillRequest) (*FundFlowBill, error) {
+	bill, err := c.FundFlowBill(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.DownloadBill(ctx, bill)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFundFlowBill(data)
+}
+
+// Columns every bill of the kind must have. Optional columns that differ
+// between bill types are read as empty when absent, but a missing required
+// column would turn every amount into zero, so it fails the parse instead.
+var (
+	tradeBillColumns    = []string{"交易时间", "微信订单号", "商户订单号", "交易状态", "应结订单金额", "订单金额"}
+	fundFlowBillColumns = []string{"记账时间", "微信支付业务单号", "收支类型", "收支金额（元）"}
+)
+
+// ParseTradeBill parses a trade bill of any bill type. Columns are matched
+// by their header, so ALL, SUCCESS and REFUND bills share one row type.
+func ParseTradeBill(data []byte) (*TradeBill, error) {
+	rows, summary, err := parseBill(data, "总交易单数", tradeBillColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	bill := &TradeBill{Rows: make([]TradeBillRow, 0, len(rows))}
+	for i, r := range rows {
+		row := TradeBillRow{
+			TradeTime:        r.time("交易时间"),
+			Appid:            r.str("公众账号ID"),
+			Mchid:            r.str("商户号"),
+			SubMchid:         r.str("特约商户号"),
+			DeviceInfo:       r.str("设备号"),
+			TransactionID:    r.str("微信订单号"),
+			OutTradeNo:       r.str("商户订单号"),
+			Openid:           r.str("用户标识"),
+			TradeType:        r.str("交易类型"),
+			TradeState:       r.str("交易状态"),
+			BankType:         r.str("付款银行"),
+			Currency:         r.str("货币种类"),
+			SettlementTotal:  r.fen("应结订单金额"),
+			CouponAmount:     r.fen("代金券金额"),
+			RefundID:         r.str("微信退款单号"),
+			OutRefundNo:      r.str("商户退款单号"),
+			SettlementRefund: r.fen("退款金额"),
+			CouponRefund:     r.fen("充值券退款金额"),
+			RefundType:       r.str("退款类型"),
+			RefundStatus:     r.str("退款状态"),
+			Body:             r.str("商品名称"),
+			Attach:           r.str("商户数据包"),
+			Fee:              r.str("手续费"),
+			Rate:             r.str("费率"),
+			Total:            r.fen("订单金额"),
+			RefundApplied:    r.fen("申请退款金额"),
+			RateRemark:       r.str("费率备注"),
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("trade bill line %d: %w", i+2, r.err)
+		}
+		bill.Rows = append(bill.Rows, row)
+	}
+
+	bill.Summary = TradeBillSummary{
+		TradeCount:         summary.count("总交易单数"),
+		SettlementTotal:    summary.fen("应结订单总金额"),
+		RefundTotal:        summary.fen("退款总金额"),
+		CouponRefundTotal:  summary.fen("充值券退款总金额"),
+		FeeTotal:           summary.str("手续费总金额"),
+		OrderTotal:         summary.fen("订单总金额"),
+		RefundAppliedTotal: summary.fen("申请退款总金额"),
+	}
+	if summary.err != nil {
+		return nil, fmt.Errorf("trade bill summary: %w", summary.err)
+	}
+	return bill, nil
+}
+
+func ParseFundFlowBill(data []byte) (*FundFlowBill, error) {
+	rows, summary, err := parseBill(data, "资金流水总笔数", fundFlowBillColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	bill := &FundFlowBill{Rows: make([]FundFlowBillRow, 0, len(rows))}
+	for i, r := range rows {
+		row := FundFlowBillRow{
+			AccountTime:      r.time("记账时间"),
+			BizTransactionID: r.str("微信支付业务单号"),
+			FundFlowID:       r.str("资金流水单号"),
+			BizName:          r.str("业务名称"),
+			BizType:          r.str("业务类型"),
+			FlowType:         r.str("收支类型"),
+			Amount:           r.fen("收支金额（元）"),
+			Balance:          r.fen("账户结余（元）"),
+			Applicant:        r.str("资金变更提交申请人"),
+			Remark:           r.str("备注"),
+			BizVoucherID:     r.str("业务凭证号"),
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("fund flow bill line %d: %w", i+2, r.err)
+		}
+		bill.Rows = append(bill.Rows, row)
+	}
+
+	bill.Summary = FundFlowBillSummary{
+		Count:         summary.count("资金流水总笔数"),
+		IncomeCount:   summary.count("收入笔数"),
+		IncomeAmount:  summary.fen("收入金额"),
+		ExpenseCount:  summary.count("支出笔数"),
+		ExpenseAmount: summary.fen("支出金额"),
+	}
+	if summary.err != nil {
+		return nil, fmt.Errorf("fund flow bill summary: %w", summary.err)
+	}
+	return bill, nil
+}
+
+// billRecord is one CSV line addressed by column header. The first
+// conversion error is kept in err so a row can be filled in one pass.
+type billRecord struct {
+	columns map[string]int
+	values  []string
+	err     error
+}
+
+// parseBill splits a bill into its detail lines and its summary line. Bills
+// are a header, the detail lines, then a second header starting with
+// summaryTitle followed by a single line of totals. The header must contain
+// every column in required.
+func parseBill(data []byte, summaryTitle string, required []string) ([]*billRecord, *billRecord, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil, errors.New("empty bill")
+	}
+
+	columns := billColumns(lines[0])
+	for _, column := range required {
+		if _, ok := columns[column]; !ok {
+			return nil, nil, fmt.Errorf("bill column %s is missing", column)
+		}
+	}
+	var rows []*billRecord
+	for i := 1; i < len(lines); i++ {
+		if billValue(lines[i][0]) == summaryTitle {
+			if i+1 >= len(lines) {
+				return nil, nil, errors.New("bill summary line is missing")
+			}
+			return rows, &billRecord{columns: billColumns(lines[i]), values: lines[i+1]}, nil
+		}
+		rows = append(rows, &billRecord{columns: columns, values: lines[i]})
+	}
+	return nil, nil, errors.New("bill summary is missing")
+}
+
+func billColumns(header []string) map[string]int {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[billValue(name)] = i
+	}
+	return columns
+}
+
+// billValue strips the backtick WeChat Pay prefixes to every value so that
+// spreadsheets keep long numbers as text.
+func billValue(s string) string {
+	return strings.TrimPrefix(strings.TrimSpace(s), "`")
+}
+
+func (r *billRecord) str(column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(r.values) {
+		return ""
+	}
+	return billValue(r.values[i])
+}
+
+func (r *billRecord) fen(column string) int64 {
+	s := r.str(column)
+	if s == "" || r.err != nil {
+		return 0
+	}
+	v, err := parseYuan(s)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", column, err)
+	}
+	return v
+}
+
+func (r *billRecord) count(column string) int {
+	s := r.str(column)
+	if s == "" || r.err != nil {
+		return 0
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", column, err)
+	}
+	return v
+}
+
+func (r *billRecord) time(column string) time.Time {
+	s := r.str(column)
+	if s == "" || r.err != nil {
+		return time.Time{}
+	}
+	v, err := time.ParseInLocation(billTimeLayout, s, cstLocation)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", column, err)
+	}
+	return v
+}
+
+// parseYuan converts a yuan amount such as "12.30" to fen without going
+// through floating point.
+func parseYuan(s string) (int64, error) {
+	negative := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	frac = strings.TrimRight(frac, "0")
+	if whole == "" || len(frac) > 2 {
+		return 0, fmt.Errorf("invalid yuan amount %q", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	yuan, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid yuan amount %q", s)
+	}
+	cents, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid yuan amount %q", s)
+	}
+
+	fen := yuan*100 + cents
+	if negative {
+		fen = -fen
+	}
+	return fen, nil
+}
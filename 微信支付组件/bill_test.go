@@ -0,0 +1,181 @@
+package wechatpay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testTradeBill = "交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,应结订单金额,代金券金额,微信退款单号,商户退款单号,退款金额,充值券退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率,订单金额,申请退款金额,费率备注\r\n" +
+	"`2019-06-11 10:23:45,`wx8888888888888888,`1230000109,`0,`,`4200000985201906115400000001,`order001,`oUpF8uMuAJO_M2pxb1Q9zNjWeS6o,`JSAPI,`SUCCESS,`CMC,`CNY,`12.30,`0.00,`0,`0,`0.00,`0.00,`,`,`Image形象店-深圳腾大-QQ公仔,`,`0.07380,`0.60%,`12.30,`0.00,`\r\n" +
+	"`2019-06-11 11:00:00,`wx8888888888888888,`1230000109,`0,`,`4200000985201906115400000002,`order002,`oUpF8uMuAJO_M2pxb1Q9zNjWeS6o,`NATIVE,`REFUND,`CMC,`CNY,`0.00,`0.00,`50000000382019061100001,`refund002,`5.01,`0.00,`ORIGINAL,`SUCCESS,`QQ公仔,`,`-0.03000,`0.60%,`0.00,`5.01,`\r\n" +
+	"总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额\r\n" +
+	"`2,`12.30,`5.01,`0.00,`0.04380,`12.30,`5.01\r\n"
+
+const testFundFlowBill = "记账时间,微信支付业务单号,资金流水单号,业务名称,业务类型,收支类型,收支金额（元）,账户结余（元）,资金变更提交申请人,备注,业务凭证号\r\n" +
+	"`2019-06-11 10:23:45,`4200000985201906115400000001,`4200000985201906115400000001,`交易,`交易,`收入,`12.30,`112.30,`system,`,`order001\r\n" +
+	"`2019-06-11 11:00:00,`50000000382019061100001,`50000000382019061100001,`退款,`退款,`支出,`5.01,`107.29,`system,`,`refund002\r\n" +
+	"资金流水总笔数,收入笔数,收入金额,支出笔数,支出金额\r\n" +
+	"`2,`1,`12.30,`1,`5.01\r\n"
+
+func testBillHash(data string) string {
+	sum := sha1.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseTradeBill(t *testing.T) {
+	bill, err := ParseTradeBill([]byte(testTradeBill))
+	if err != nil {
+		t.Fatalf("ParseTradeBill failed: %v", err)
+	}
+	if len(bill.Rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(bill.Rows))
+	}
+
+	row := bill.Rows[0]
+	wantTime := time.Date(2019, 6, 11, 2, 23, 45, 0, time.UTC)
+	if !row.TradeTime.Equal(wantTime) {
+		t.Errorf("Unexpected trade time: %v", row.TradeTime)
+	}
+	if row.OutTradeNo != "order001" || row.TransactionID != "4200000985201906115400000001" || row.TradeState != "SUCCESS" {
+		t.Errorf("Unexpected row: %+v", row)
+	}
+	if row.SettlementTotal != 1230 || row.Total != 1230 || row.Fee != "0.07380" || row.Rate != "0.60%" {
+		t.Errorf("Unexpected amounts: %+v", row)
+	}
+
+	refund := bill.Rows[1]
+	if refund.OutRefundNo != "refund002" || refund.SettlementRefund != 501 || refund.RefundStatus != "SUCCESS" {
+		t.Errorf("Unexpected refund row: %+v", refund)
+	}
+
+	want := TradeBillSummary{TradeCount: 2, SettlementTotal: 1230, RefundTotal: 501, FeeTotal: "0.04380", OrderTotal: 1230, RefundAppliedTotal: 501}
+	if bill.Summary != want {
+		t.Errorf("Unexpected summary: %+v", bill.Summary)
+	}
+}
+
+func TestParseFundFlowBill(t *testing.T) {
+	bill, err := ParseFundFlowBill([]byte(testFundFlowBill))
+	if err != nil {
+		t.Fatalf("ParseFundFlowBill failed: %v", err)
+	}
+	if len(bill.Rows) != 2 || bill.Rows[1].FlowType != "支出" || bill.Rows[1].Amount != 501 || bill.Rows[1].Balance != 10729 {
+		t.Errorf("Unexpected rows: %+v", bill.Rows)
+	}
+	want := FundFlowBillSummary{Count: 2, IncomeCount: 1, IncomeAmount: 1230, ExpenseCount: 1, ExpenseAmount: 501}
+	if bill.Summary != want {
+		t.Errorf("Unexpected summary: %+v", bill.Summary)
+	}
+}
+
+func TestParseTradeBill_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"no summary", "交易时间,微信订单号,商户订单号,交易状态,应结订单金额,订单金额\r\n`2019-06-11 10:23:45,`42000001,`order001,`SUCCESS,`1.00,`1.00\r\n"},
+		{"bad amount", "交易时间,微信订单号,商户订单号,交易状态,应结订单金额,订单金额\r\n`2019-06-11 10:23:45,`42000001,`order001,`SUCCESS,`1.234,`1.00\r\n总交易单数\r\n`1\r\n"},
+		{"bad time", "交易时间,微信订单号,商户订单号,交易状态,应结订单金额,订单金额\r\n`yesterday,`42000001,`order001,`SUCCESS,`1.00,`1.00\r\n总交易单数\r\n`1\r\n"},
+		{"missing column", "交易时间,微信订单号,商户订单号,交易状态,结算金额,订单金额\r\n`2019-06-11 10:23:45,`42000001,`order001,`SUCCESS,`1.00,`1.00\r\n总交易单数\r\n`1\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTradeBill([]byte(tt.data)); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestParseYuan(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"12.30", 1230, true},
+		{"0.01", 1, true},
+		{"7", 700, true},
+		{"-5.1", -510, true},
+		{"0.07380", 0, false},
+		{"abc", 0, false},
+		{".5", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseYuan(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseYuan(%q) = %d, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestDownloadTradeBill(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(testTradeBill))
+	zw.Close()
+
+	var client *Client
+	client, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/bill/tradebill":
+			q := r.URL.Query()
+			if q.Get("bill_date") != "2019-06-11" || q.Get("bill_type") != BillTypeAll || q.Get("tar_type") != TarTypeGzip {
+				t.Errorf("Unexpected query: %s", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(BillDownload{
+				HashType:    "SHA1",
+				HashValue:   testBillHash(testTradeBill),
+				DownloadURL: client.baseURL + "/v3/billdownload/file?token=6XIv5TUPto7pByrTQKhd6kwvyKLG2uY2wMMR8cNXqaA_Cv_isgaUtBzp4QtiozLO",
+			})
+		case "/v3/billdownload/file":
+			if _, err := verifyTestAuthorization(r, nil, &client.privateKey.PublicKey); err != nil {
+				t.Errorf("Download request not signed: %v", err)
+			}
+			w.Write(gz.Bytes())
+		default:
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+	})
+
+	bill, err := client.DownloadTradeBill(context.Background(), &TradeBillRequest{BillDate: "2019-06-11", TarType: TarTypeGzip})
+	if err != nil {
+		t.Fatalf("DownloadTradeBill failed: %v", err)
+	}
+	if len(bill.Rows) != 2 || bill.Summary.TradeCount != 2 {
+		t.Errorf("Unexpected bill: %+v", bill)
+	}
+}
+
+func TestDownloadBill_HashMismatch(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testFundFlowBill))
+	})
+
+	_, err := client.DownloadBill(context.Background(), &BillDownload{
+		HashType:    "SHA1",
+		HashValue:   testBillHash("something else"),
+		DownloadURL: client.baseURL + "/v3/billdownload/file?token=abc",
+	})
+	if !errors.Is(err, ErrBillHashMismatch) {
+		t.Errorf("Expected ErrBillHashMismatch, got %v", err)
+	}
+}
+
+func TestTradeBill_InvalidDate(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("No request expected")
+	})
+	if _, err := client.TradeBill(context.Background(), &TradeBillRequest{BillDate: "20190611"}); err == nil {
+		t.Error("Expected error for invalid bill_date")
+	}
+}
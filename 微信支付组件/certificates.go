@@ -0,0 +1,249 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certificatesPath            = "/v3/certificates"
+	defaultCertRefreshInterval  = 12 * time.Hour
+	defaultCertRefreshBeforeEnd = 24 * time.Hour
+	certRetryInterval           = time.Minute
+)
+
+var ErrNoPlatformCertificate = errors.New("no platform certificate available")
+
+type certificatesResponse struct {
+	Data []struct {
+		SerialNo           string `json:"serial_no"`
+		EffectiveTime      string `json:"effective_time"`
+		ExpireTime         string `json:"expire_time"`
+		EncryptCertificate struct {
+			Algorithm      string `json:"algorithm"`
+			Nonce          string `json:"nonce"`
+			AssociatedData string `json:"associated_data"`
+			Ciphertext     string `json:"ciphertext"`
+		} `json:"encrypt_certificate"`
+	} `json:"data"`
+}
+
+// CertificateManager downloads WeChat Pay platform certificates and keeps
+// them cached by serial number so responses and callbacks can be verified
+// while certificates rotate.
+type CertificateManager struct {
+	client          *Client
+	refreshInterval time.Duration
+	refreshBefore   time.Duration
+
+	mu          sync.RWMutex
+	certs       map[string]*x509.Certificate
+	lastRefresh time.Time
+}
+
+func NewCertificateManager(client *Client) *CertificateManager {
+	return &CertificateManager{
+		client:          client,
+		refreshInterval: defaultCertRefreshInterval,
+		refreshBefore:   defaultCertRefreshBeforeEnd,
+		certs:           make(map[string]*x509.Certificate),
+	}
+}
+
+// Refresh downloads the current platform certificates and replaces the cache.
+func (m *CertificateManager) Refresh(ctx context.Context) error {
+	req, err := m.client.newRequest("GET", certificatesPath, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.client.sendRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download certificates error: %s, %s", resp.Status, body)
+	}
+
+	certs, err := m.decryptCertificates(body)
+	if err != nil {
+		return err
+	}
+
+	// The response is signed with one of the certificates it carries, so it
+	// can only be checked after decryption.
+	list := make([]*x509.Certificate, 0, len(certs))
+	for _, cert := range certs {
+		list = append(list, cert)
+	}
+	verifier, err := NewCertificateVerifier(list...)
+	if err != nil {
+		return err
+	}
+	h := resp.Header
+	message := buildVerifyMessage(h.Get("Wechatpay-Timestamp"), h.Get("Wechatpay-Nonce"), body)
+	if err := verifier.Verify(h.Get("Wechatpay-Serial"), message, h.Get("Wechatpay-Signature")); err != nil {
+		return fmt.Errorf("verify certificates response: %w", err)
+	}
+
+	m.mu.Lock()
+	m.certs = certs
+	m.lastRefresh = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+// ensure loads the certificates on first use and refreshes them when a
+// serial number that is not cached yet shows up, which happens right after
+// WeChat Pay rotates its certificate.
+func (m *CertificateManager) ensure(ctx context.Context, serial string) error {
+	if _, ok := m.Certificate(serial); ok {
+		return nil
+	}
+
+	m.mu.RLock()
+	recent := time.Since(m.lastRefresh) < certRetryInterval
+	m.mu.RUnlock()
+	if recent {
+		return nil
+	}
+	return m.Refresh(ctx)
+}
+
+func (m *CertificateManager) decryptCertificates(body []byte) (map[string]*x509.Certificate, error) {
+	var result certificatesResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, ErrNoPlatformCertificate
+	}
+
+	certs := make(map[string]*x509.Certificate, len(result.Data))
+	for _, item := range result.Data {
+		enc := item.EncryptCertificate
+		plaintext, err := decryptAES256GCM(m.client.apiV3Key, enc.AssociatedData, enc.Nonce, enc.Ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt certificate %s: %w", item.SerialNo, err)
+		}
+
+		block, _ := pem.Decode(plaintext)
+		if block == nil {
+			return nil, fmt.Errorf("certificate %s is not PEM encoded", item.SerialNo)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate %s: %w", item.SerialNo, err)
+		}
+		if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("certificate %s is not RSA", item.SerialNo)
+		}
+		serial := CertificateSerial(cert)
+		if !strings.EqualFold(item.SerialNo, serial) {
+			return nil, fmt.Errorf("certificate serial_no %s does not match certificate serial %s", item.SerialNo, serial)
+		}
+		certs[serial] = cert
+	}
+	return certs, nil
+}
+
+// Start refreshes the certificates now and then keeps refreshing them in the
+// background until ctx is done. A certificate close to expiry triggers an
+// earlier refresh than the regular interval.
+func (m *CertificateManager) Start(ctx context.Context) error {
+	if err := m.Refresh(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		timer := time.NewTimer(m.nextRefresh(time.Now()))
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				wait := certRetryInterval
+				if err := m.Refresh(ctx); err == nil {
+					wait = m.nextRefresh(time.Now())
+				}
+				timer.Reset(wait)
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *CertificateManager) nextRefresh(now time.Time) time.Duration {
+	wait := m.refreshInterval
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, cert := range m.certs {
+		if d := cert.NotAfter.Add(-m.refreshBefore).Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < certRetryInterval {
+		wait = certRetryInterval
+	}
+	return wait
+}
+
+// Certificate returns the cached platform certificate with the given serial number.
+func (m *CertificateManager) Certificate(serial string) (*x509.Certificate, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cert, ok := m.certs[strings.ToUpper(serial)]
+	return cert, ok
+}
+
+// Latest returns the valid platform certificate that expires last, which is
+// the one WeChat Pay expects sensitive fields to be encrypted with.
+func (m *CertificateManager) Latest() (string, *x509.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var serial string
+	var latest *x509.Certificate
+	for s, cert := range m.certs {
+		if now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+			continue
+		}
+		if latest == nil || cert.NotAfter.After(latest.NotAfter) {
+			serial, latest = s, cert
+		}
+	}
+	if latest == nil {
+		return "", nil, ErrNoPlatformCertificate
+	}
+	return serial, latest, nil
+}
+
+func (m *CertificateManager) Verify(serial string, message []byte, signature string) error {
+	cert, ok := m.Certificate(serial)
+	if !ok {
+		return fmt.Errorf("unknown platform certificate serial: %s", serial)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return fmt.Errorf("platform certificate %s expired", serial)
+	}
+	return verifySignature(cert.PublicKey.(*rsa.PublicKey), message, signature)
+}
@@ -0,0 +1,174 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestCertificateServer(t *testing.T, platformKey *rsa.PrivateKey, certs ...*x509.Certificate) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			t.Error("Authorization header missing")
+		}
+
+		var data []map[string]interface{}
+		for _, cert := range certs {
+			certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			data = append(data, map[string]interface{}{
+				"serial_no":      CertificateSerial(cert),
+				"effective_time": cert.NotBefore.Format(time.RFC3339),
+				"expire_time":    cert.NotAfter.Format(time.RFC3339),
+				"encrypt_certificate": map[string]string{
+					"algorithm":       notifyAlgorithm,
+					"nonce":           "61f9c719728a",
+					"associated_data": "certificate",
+					"ciphertext":      encryptTestResource(t, testAPIv3Key, "certificate", "61f9c719728a", certPEM),
+				},
+			})
+		}
+		body, _ := json.Marshal(map[string]interface{}{"data": data})
+
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		w.Header().Set("Wechatpay-Timestamp", timestamp)
+		w.Header().Set("Wechatpay-Nonce", "nonce")
+		w.Header().Set("Wechatpay-Serial", CertificateSerial(certs[0]))
+		w.Header().Set("Wechatpay-Signature", signTestMessage(t, platformKey, buildVerifyMessage(timestamp, "nonce", body)))
+		w.Write(body)
+	}))
+}
+
+func newTestCertificateManager(t *testing.T, url string) *CertificateManager {
+	t.Helper()
+	merchantKey, _ := generateTestKeyPair()
+	client, err := NewClient(&Config{
+		MchID:       testMchID,
+		SerialNo:    testSerialNo,
+		PrivateKey:  merchantKey,
+		MchAPIv3Key: testAPIv3Key,
+		BaseURL:     url,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return client.Certificates()
+}
+
+func TestCertificateManager_Refresh(t *testing.T) {
+	platformKey, _ := generateTestKeyPair()
+	oldCert := generateTestCertificate(t, platformKey, 0x100, time.Now().Add(24*time.Hour))
+	newCert := generateTestCertificate(t, platformKey, 0x200, time.Now().Add(365*24*time.Hour))
+
+	ts := newTestCertificateServer(t, platformKey, oldCert, newCert)
+	defer ts.Close()
+
+	m := newTestCertificateManager(t, ts.URL)
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	if _, ok := m.Certificate("0100"); !ok {
+		t.Error("Expected certificate 0100 to be cached")
+	}
+	serial, _, err := m.Latest()
+	if err != nil || serial != "0200" {
+		t.Errorf("Expected latest serial 0200, got %s (%v)", serial, err)
+	}
+
+	message := buildVerifyMessage("1650000000", "nonce", []byte("{}"))
+	if err := m.Verify("0200", message, signTestMessage(t, platformKey, message)); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	if err := m.Verify("300", message, signTestMessage(t, platformKey, message)); err == nil {
+		t.Error("Unknown serial should fail")
+	}
+}
+
+func TestCertificateManager_RefreshRejectsForgedResponse(t *testing.T) {
+	platformKey, _ := generateTestKeyPair()
+	forgerKey, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, platformKey, 0x100, time.Now().Add(24*time.Hour))
+
+	ts := newTestCertificateServer(t, forgerKey, cert)
+	defer ts.Close()
+
+	m := newTestCertificateManager(t, ts.URL)
+	if err := m.Refresh(context.Background()); err == nil {
+		t.Fatal("Expected forged certificate response to be rejected")
+	}
+	if _, _, err := m.Latest(); err != ErrNoPlatformCertificate {
+		t.Errorf("Expected empty cache, got %v", err)
+	}
+}
+
+func TestCertificateManager_RejectsMismatchedSerial(t *testing.T) {
+	platformKey, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, platformKey, 0x100, time.Now().Add(24*time.Hour))
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	body, _ := json.Marshal(map[string]interface{}{"data": []map[string]interface{}{{
+		"serial_no": "100",
+		"encrypt_certificate": map[string]string{
+			"algorithm":       notifyAlgorithm,
+			"nonce":           "61f9c719728a",
+			"associated_data": "certificate",
+			"ciphertext":      encryptTestResource(t, testAPIv3Key, "certificate", "61f9c719728a", certPEM),
+		},
+	}}})
+
+	m := newTestCertificateManager(t, "")
+	if _, err := m.decryptCertificates(body); err == nil {
+		t.Error("Expected serial_no that differs from the certificate to be rejected")
+	}
+}
+
+func TestCertificateManager_NextRefresh(t *testing.T) {
+	platformKey, _ := generateTestKeyPair()
+	m := newTestCertificateManager(t, "")
+	now := time.Now()
+
+	m.certs["100"] = generateTestCertificate(t, platformKey, 0x100, now.Add(365*24*time.Hour))
+	if got := m.nextRefresh(now); got != defaultCertRefreshInterval {
+		t.Errorf("Expected regular interval, got %v", got)
+	}
+
+	m.certs["200"] = generateTestCertificate(t, platformKey, 0x200, now.Add(30*time.Hour))
+	if got := m.nextRefresh(now); got > 6*time.Hour+time.Second || got < 6*time.Hour-time.Second {
+		t.Errorf("Expected refresh 6h before expiry window, got %v", got)
+	}
+
+	m.certs["300"] = generateTestCertificate(t, platformKey, 0x300, now.Add(time.Hour))
+	if got := m.nextRefresh(now); got != certRetryInterval {
+		t.Errorf("Expected retry interval for expiring certificate, got %v", got)
+	}
+}
+
+func TestClient_LoadsCertificatesOnDemand(t *testing.T) {
+	platformKey, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, platformKey, 0x100, time.Now().Add(24*time.Hour))
+
+	ts := newTestCertificateServer(t, platformKey, cert)
+	defer ts.Close()
+
+	m := newTestCertificateManager(t, ts.URL)
+	message := buildVerifyMessage("1650000000", "nonce", []byte("{}"))
+	header := http.Header{}
+	header.Set("Wechatpay-Signature", signTestMessage(t, platformKey, message))
+	header.Set("Wechatpay-Timestamp", "1650000000")
+	header.Set("Wechatpay-Nonce", "nonce")
+	header.Set("Wechatpay-Serial", "0100")
+
+	if err := m.client.verify(context.Background(), header, []byte("{}")); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if _, ok := m.Certificate("0100"); !ok {
+		t.Error("Expected certificate to be downloaded on first verification")
+	}
+}
@@ -1,29 +1,24 @@
 package wechatpay
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 )
 
-type Credential struct {
-	ApiKey string
-}
-
-func CloseOrder(ctx context.Context, mchid string, outTradeNo string, credential *Credential) error {
-	req, err := buildCloseOrderRequest(mchid, outTradeNo)
+func (c *Client) CloseOrder(ctx context.Context, outTradeNo string) error {
+	if outTradeNo == "" {
+		return errors.New("out_trade_no is required")
+	}
+	req, err := c.buildCloseOrderRequest(outTradeNo)
 	if err != nil {
 		return err
 	}
 
-	req = req.WithContext(ctx)
-	req.Header.Set("Authorization", "Bearer "+credential.ApiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.doRequest(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -32,31 +27,25 @@ func CloseOrder(ctx context.Context, mchid string, outTradeNo string, credential
 	return validateCloseOrderResponse(resp)
 }
 
-func buildCloseOrderRequest(mchid string, outTradeNo string) (*http.Request, error) {
-	url := fmt.Sprintf("https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/%s/close", outTradeNo)
-	
-	payload := struct {
-		MchID string `json:"mchid"`
-	}{MchID: mchid}
-	
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
+func (c *Client) buildCloseOrderRequest(outTradeNo string) (*http.Request, error) {
+	path := fmt.Sprintf("%s/out-trade-no/%s/close", c.transactionsPath(), url.PathEscape(outTradeNo))
+
+	payload := map[string]string{"mchid": c.mchID}
+	if c.isPartner() {
+		payload = map[string]string{"sp_mchid": c.mchID, "sub_mchid": c.subMchID}
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	return req, nil
+	return c.newRequest("POST", path, jsonData)
 }
 
 func validateCloseOrderResponse(resp *http.Response) error {
 	if resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return newAPIError(resp)
 	}
 	return nil
 }
@@ -0,0 +1,335 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	combineTransactionsPath = "/v3/combine-transactions"
+	maxCombineSubOrders     = 50
+)
+
+// CombineOrderParams creates one payment covering orders of several
+// merchants, such as a marketplace cart. Each sub-order keeps its own
+// out_trade_no, amount and settlement.
+type CombineOrderParams struct {
+	// TradeType defaults to TradeTypeNative when empty.
+	TradeType         TradeType
+	CombineAppid      string
+	CombineOutTradeNo string
+	NotifyURL         string
+	// SceneInfo with PayerClientIP is required for every trade type.
+	SceneInfo *SceneInfo
+	// Payer is required for JSAPI orders. Only Openid is used.
+	Payer      *Payer
+	SubOrders  []CombineSubOrder
+	TimeStart  time.Time
+	TimeExpire time.Time
+}
+
+type CombineSubOrder struct {
+	// MchID defaults to the client's merchant ID.
+	MchID string
+	// SubMchID and SubAppid are set when a service provider pays on
+	// behalf of a sub-merchant.
+	SubMchID    string
+	SubAppid    string
+	OutTradeNo  string
+	Description string
+	// Attach is required for sub-orders and returned in queries and
+	// notifications.
+	Attach     string
+	Amount     Amount
+	GoodsTag   string
+	Detail     *OrderDetail
+	SettleInfo *CombineSettleInfo
+}
+
+type CombineSettleInfo struct {
+	ProfitSharing bool
+	// SubsidyAmount is the part of the amount subsidised by the service
+	// provider, in fen.
+	SubsidyAmount int
+}
+
+// CombineTransaction is a combined order as returned by QueryCombineOrder
+// and combined payment notifications.
+type CombineTransaction struct {
+	CombineAppid      string                  `json:"combine_appid"`
+	CombineMchid      string                  `json:"combine_mchid"`
+	CombineOutTradeNo string                  `json:"combine_out_trade_no"`
+	SceneInfo         *TransactionSceneInfo   `json:"scene_info,omitempty"`
+	SubOrders         []CombineSubTransaction `json:"sub_orders"`
+	CombinePayerInfo  TransactionPayer        `json:"combine_payer_info"`
+}
+
+type CombineSubTransaction struct {
+	Mchid           string                 `json:"mchid"`
+	SubMchid        string                 `json:"sub_mchid,omitempty"`
+	SubAppid        string                 `json:"sub_appid,omitempty"`
+	SubOpenid       string                 `json:"sub_openid,omitempty"`
+	TradeType       string                 `json:"trade_type"`
+	TradeState      string                 `json:"trade_state"`
+	BankType        string                 `json:"bank_type"`
+	Attach          string                 `json:"attach"`
+	SuccessTime     string                 `json:"success_time"`
+	TransactionID   string                 `json:"transaction_id"`
+	OutTradeNo      string                 `json:"out_trade_no"`
+	Amount          CombineAmount          `json:"amount"`
+	PromotionDetail []TransactionPromotion `json:"promotion_detail,omitempty"`
+}
+
+type CombineAmount struct {
+	TotalAmount    int    `json:"total_amount"`
+	Currency       string `json:"currency"`
+	PayerAmount    int    `json:"payer_amount"`
+	PayerCurrency  string `json:"payer_currency"`
+	SettlementRate int    `json:"settlement_rate,omitempty"`
+}
+
+// Paid reports whether every sub-order was paid.
+func (t *CombineTransaction) Paid() bool {
+	if len(t.SubOrders) == 0 {
+		return false
+	}
+	for _, sub := range t.SubOrders {
+		if sub.TradeState != TradeStateSuccess {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateCombineOrder returns the prepay_id, h5_url or code_url of the
+// combined order, like CreateOrder. The prepay_id is passed to
+// BuildJSAPIInvokeParams or BuildAppInvokeParams with CombineAppid.
+func (c *Client) CreateCombineOrder(ctx context.Context, params *CombineOrderParams) (*CreateOrderResponse, error) {
+	if err := validateCombineOrderParams(params); err != nil {
+		return nil, err
+	}
+	body := map[string]interface{}{
+		"combine_appid":        params.CombineAppid,
+		"combine_mchid":        c.mchID,
+		"combine_out_trade_no": params.CombineOutTradeNo,
+		"notify_url":           params.NotifyURL,
+		"scene_info":           buildSceneInfo(params.SceneInfo),
+		"sub_orders":           c.buildCombineSubOrders(params.SubOrders),
+	}
+	if params.Payer != nil {
+		body["combine_payer_info"] = map[string]interface{}{"openid": params.Payer.Openid}
+	}
+	if !params.TimeStart.IsZero() {
+		body["time_start"] = params.TimeStart.In(cstLocation).Format(time.RFC3339)
+	}
+	if !params.TimeExpire.IsZero() {
+		body["time_expire"] = params.TimeExpire.In(cstLocation).Format(time.RFC3339)
+	}
+
+	req, err := c.newJSONRequest("POST", combineTransactionsPath+tradeTypePaths[params.tradeType()], body)
+	if err != nil {
+		return nil, err
+	}
+	var result CreateOrderResponse
+	if err := c.doJSON(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (p *CombineOrderParams) tradeType() TradeType {
+	if p.TradeType == "" {
+		return TradeTypeNative
+	}
+	return p.TradeType
+}
+
+func (c *Client) buildCombineSubOrders(subOrders []CombineSubOrder) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(subOrders))
+	for _, sub := range subOrders {
+		mchID := sub.MchID
+		if mchID == "" {
+			mchID = c.mchID
+		}
+		order := map[string]interface{}{
+			"mchid":        mchID,
+			"out_trade_no": sub.OutTradeNo,
+			"description":  sub.Description,
+			"attach":       sub.Attach,
+			"amount": map[string]interface{}{
+				"total_amount": sub.Amount.Total,
+				"currency":     sub.Amount.Currency,
+			},
+		}
+		if sub.SubMchID != "" {
+			order["sub_mchid"] = sub.SubMchID
+		}
+		if sub.SubAppid != "" {
+			order["sub_appid"] = sub.SubAppid
+		}
+		if sub.GoodsTag != "" {
+			order["goods_tag"] = sub.GoodsTag
+		}
+		if sub.Detail != nil {
+			order["detail"] = buildOrderDetail(sub.Detail)
+		}
+		if sub.SettleInfo != nil {
+			settleInfo := map[string]interface{}{"profit_sharing": sub.SettleInfo.ProfitSharing}
+			if sub.SettleInfo.SubsidyAmount > 0 {
+				settleInfo["subsidy_amount"] = sub.SettleInfo.SubsidyAmount
+			}
+			order["settle_info"] = settleInfo
+		}
+		result = append(result, order)
+	}
+	return result
+}
+
+func validateCombineOrderParams(params *CombineOrderParams) error {
+	if params.CombineAppid == "" {
+		return errors.New("combine_appid is required")
+	}
+	if params.CombineOutTradeNo == "" {
+		return errors.New("combine_out_trade_no is required")
+	}
+	if params.NotifyURL == "" {
+		return errors.New("notify_url is required")
+	}
+	tradeType := params.tradeType()
+	if _, ok := tradeTypePaths[tradeType]; !ok {
+		return fmt.Errorf("unsupported trade type: %s", params.TradeType)
+	}
+	if params.SceneInfo == nil || params.SceneInfo.PayerClientIP == "" {
+		return errors.New("scene_info.payer_client_ip is required")
+	}
+	if tradeType == TradeTypeJSAPI && (params.Payer == nil || params.Payer.Openid == "") {
+		return errors.New("payer.openid is required for JSAPI orders")
+	}
+	if params.Payer != nil && params.Payer.SubOpenid != "" {
+		return errors.New("payer.sub_openid is not supported for combined orders")
+	}
+	if tradeType == TradeTypeH5 && (params.SceneInfo.H5Info == nil || params.SceneInfo.H5Info.Type == "") {
+		return errors.New("scene_info.h5_info.type is required for H5 orders")
+	}
+	if !params.TimeStart.IsZero() && !params.TimeExpire.IsZero() && !params.TimeExpire.After(params.TimeStart) {
+		return errors.New("time_expire must be after time_start")
+	}
+	if len(params.SubOrders) == 0 || len(params.SubOrders) > maxCombineSubOrders {
+		return fmt.Errorf("sub_orders must have 1 to %d entries", maxCombineSubOrders)
+	}
+
+	seen := make(map[string]bool, len(params.SubOrders))
+	for i, sub := range params.SubOrders {
+		if sub.OutTradeNo == "" {
+			return fmt.Errorf("sub_orders[%d].out_trade_no is required", i)
+		}
+		if seen[sub.OutTradeNo] {
+			return fmt.Errorf("duplicate sub_orders out_trade_no: %s", sub.OutTradeNo)
+		}
+		seen[sub.OutTradeNo] = true
+		if sub.Description == "" {
+			return fmt.Errorf("sub_orders[%d].description is required", i)
+		}
+		if sub.Attach == "" {
+			return fmt.Errorf("sub_orders[%d].attach is required", i)
+		}
+		if sub.Amount.Total <= 0 {
+			return fmt.Errorf("sub_orders[%d].amount.total must be positive", i)
+		}
+		if sub.Amount.Currency == "" {
+			return fmt.Errorf("sub_orders[%d].currency is required", i)
+		}
+		if sub.SubAppid != "" && sub.SubMchID == "" {
+			return fmt.Errorf("sub_orders[%d].sub_appid requires sub_mchid", i)
+		}
+		if sub.SettleInfo != nil && (sub.SettleInfo.SubsidyAmount < 0 || sub.SettleInfo.SubsidyAmount > sub.Amount.Total) {
+			return fmt.Errorf("sub_orders[%d].settle_info.subsidy_amount must be 0 to amount.total", i)
+		}
+		// The per-order limits are the same as for CreateOrder.
+		if err := validateOrderOptions(&CreateOrderParams{Description: sub.Description, Attach: sub.Attach, GoodsTag: sub.GoodsTag, Detail: sub.Detail}); err != nil {
+			return fmt.Errorf("sub_orders[%d]: %w", i, err)
+		}
+	}
+	return validateOrderOptions(&CreateOrderParams{SceneInfo: params.SceneInfo, TimeExpire: params.TimeExpire})
+}
+
+func (c *Client) QueryCombineOrder(ctx context.Context, combineOutTradeNo string) (*CombineTransaction, error) {
+	if combineOutTradeNo == "" {
+		return nil, errors.New("combine_out_trade_no is required")
+	}
+	req, err := c.newRequest("GET", combineTransactionsPath+"/out-trade-no/"+url.PathEscape(combineOutTradeNo), nil)
+	if err != nil {
+		return nil, err
+	}
+	var transaction CombineTransaction
+	if err := c.doJSON(ctx, req, &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// CloseCombineSubOrder names a sub-order of the combined order to close.
+type CloseCombineSubOrder struct {
+	// MchID defaults to the client's merchant ID.
+	MchID      string
+	SubMchID   string
+	SubAppid   string
+	OutTradeNo string
+}
+
+// CloseCombineOrder closes an unpaid combined order. subOrders must list
+// every sub-order it was created with.
+func (c *Client) CloseCombineOrder(ctx context.Context, combineAppid, combineOutTradeNo string, subOrders []CloseCombineSubOrder) error {
+	if combineAppid == "" || combineOutTradeNo == "" {
+		return errors.New("combine_appid and combine_out_trade_no are required")
+	}
+	if len(subOrders) == 0 {
+		return errors.New("sub_orders is required")
+	}
+
+	orders := make([]map[string]string, 0, len(subOrders))
+	for i, sub := range subOrders {
+		if sub.OutTradeNo == "" {
+			return fmt.Errorf("sub_orders[%d].out_trade_no is required", i)
+		}
+		mchID := sub.MchID
+		if mchID == "" {
+			mchID = c.mchID
+		}
+		order := map[string]string{"mchid": mchID, "out_trade_no": sub.OutTradeNo}
+		if sub.SubMchID != "" {
+			order["sub_mchid"] = sub.SubMchID
+		}
+		if sub.SubAppid != "" {
+			order["sub_appid"] = sub.SubAppid
+		}
+		orders = append(orders, order)
+	}
+
+	path := combineTransactionsPath + "/out-trade-no/" + url.PathEscape(combineOutTradeNo) + "/close"
+	req, err := c.newJSONRequest("POST", path, map[string]interface{}{
+		"combine_appid": combineAppid,
+		"sub_orders":    orders,
+	})
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, req, nil)
+}
+
+type CombineTransactionHandlerFunc func(ctx context.Context, notify *NotifyRequest, transaction *CombineTransaction) error
+
+// NewCombineTransactionNotifyHandler returns the http.Handler to mount at
+// the NotifyURL passed to CreateCombineOrder.
+func NewCombineTransactionNotifyHandler(parser *NotifyParser, fn CombineTransactionHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var transaction CombineTransaction
+		serveNotify(w, r, parser, &transaction, func(notify *NotifyRequest) error {
+			return fn(r.Context(), notify, &transaction)
+		})
+	})
+}
@@ -0,0 +1,194 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCombineOrderParams() *CombineOrderParams {
+	return &CombineOrderParams{
+		TradeType:         TradeTypeJSAPI,
+		CombineAppid:      "wxd678efh567hg6787",
+		CombineOutTradeNo: "P20150806125346",
+		NotifyURL:         "https://yourapp.com/notify",
+		SceneInfo:         &SceneInfo{PayerClientIP: "14.23.150.211", DeviceID: "POS1:1"},
+		Payer:             &Payer{Openid: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+		SubOrders: []CombineSubOrder{
+			{
+				OutTradeNo:  "20150806125346",
+				Description: "腾讯充值中心-QQ会员充值",
+				Attach:      "深圳分店",
+				Amount:      Amount{Total: 10, Currency: "CNY"},
+				SettleInfo:  &CombineSettleInfo{ProfitSharing: true, SubsidyAmount: 1},
+			},
+			{
+				MchID:       "1900000109",
+				SubMchID:    "1900000110",
+				OutTradeNo:  "20150806125347",
+				Description: "腾讯充值中心-QQ会员充值",
+				Attach:      "广州分店",
+				Amount:      Amount{Total: 20, Currency: "CNY"},
+				GoodsTag:    "WXG",
+			},
+		},
+	}
+}
+
+func TestCreateCombineOrder(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v3/combine-transactions/jsapi" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var body struct {
+			CombineAppid     string `json:"combine_appid"`
+			CombineMchid     string `json:"combine_mchid"`
+			CombinePayerInfo struct {
+				Openid string `json:"openid"`
+			} `json:"combine_payer_info"`
+			SceneInfo map[string]interface{}   `json:"scene_info"`
+			SubOrders []map[string]interface{} `json:"sub_orders"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.CombineAppid != "wxd678efh567hg6787" || body.CombineMchid != testMchID || body.CombinePayerInfo.Openid != "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o" {
+			t.Errorf("Unexpected body: %+v", body)
+		}
+		if body.SceneInfo["payer_client_ip"] != "14.23.150.211" || len(body.SubOrders) != 2 {
+			t.Fatalf("Unexpected body: %+v", body)
+		}
+		first, second := body.SubOrders[0], body.SubOrders[1]
+		if first["mchid"] != testMchID || first["attach"] != "深圳分店" || first["sub_mchid"] != nil {
+			t.Errorf("Unexpected first sub-order: %v", first)
+		}
+		if first["amount"].(map[string]interface{})["total_amount"] != float64(10) {
+			t.Errorf("Unexpected first amount: %v", first["amount"])
+		}
+		if settle := first["settle_info"].(map[string]interface{}); settle["profit_sharing"] != true || settle["subsidy_amount"] != float64(1) {
+			t.Errorf("Unexpected settle_info: %v", settle)
+		}
+		if second["mchid"] != "1900000109" || second["sub_mchid"] != "1900000110" || second["goods_tag"] != "WXG" {
+			t.Errorf("Unexpected second sub-order: %v", second)
+		}
+		w.Write([]byte(`{"prepay_id":"wx201410272009395522657a690389285100"}`))
+	})
+
+	resp, err := client.CreateCombineOrder(context.Background(), newTestCombineOrderParams())
+	if err != nil {
+		t.Fatalf("CreateCombineOrder failed: %v", err)
+	}
+	if resp.PrepayID != "wx201410272009395522657a690389285100" {
+		t.Errorf("Unexpected prepay_id: %s", resp.PrepayID)
+	}
+}
+
+func TestValidateCombineOrderParams(t *testing.T) {
+	if err := validateCombineOrderParams(newTestCombineOrderParams()); err != nil {
+		t.Fatalf("Expected valid params, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*CombineOrderParams)
+		errMsg string
+	}{
+		{"missing appid", func(p *CombineOrderParams) { p.CombineAppid = "" }, "combine_appid"},
+		{"missing scene", func(p *CombineOrderParams) { p.SceneInfo = nil }, "payer_client_ip"},
+		{"jsapi without payer", func(p *CombineOrderParams) { p.Payer = nil }, "payer.openid"},
+		{"h5 without h5_info", func(p *CombineOrderParams) { p.TradeType = TradeTypeH5 }, "h5_info"},
+		{"no sub-orders", func(p *CombineOrderParams) { p.SubOrders = nil }, "sub_orders must have"},
+		{"duplicate out_trade_no", func(p *CombineOrderParams) { p.SubOrders[1].OutTradeNo = p.SubOrders[0].OutTradeNo }, "duplicate"},
+		{"missing attach", func(p *CombineOrderParams) { p.SubOrders[1].Attach = "" }, "sub_orders[1].attach"},
+		{"zero amount", func(p *CombineOrderParams) { p.SubOrders[0].Amount.Total = 0 }, "sub_orders[0].amount.total"},
+		{"large subsidy", func(p *CombineOrderParams) { p.SubOrders[0].SettleInfo.SubsidyAmount = 11 }, "subsidy_amount"},
+		{"long goods tag", func(p *CombineOrderParams) { p.SubOrders[1].GoodsTag = strings.Repeat("a", 33) }, "sub_orders[1]: goods_tag"},
+		{"expire before start", func(p *CombineOrderParams) {
+			p.TimeStart = time.Now().Add(time.Hour)
+			p.TimeExpire = time.Now().Add(time.Minute)
+		}, "time_expire"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := newTestCombineOrderParams()
+			tt.modify(params)
+			err := validateCombineOrderParams(params)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+const testCombineTransaction = `{"combine_appid":"wxd678efh567hg6787","combine_mchid":"1230000109","combine_out_trade_no":"P20150806125346","scene_info":{"device_id":"POS1:1"},"sub_orders":[{"mchid":"1230000109","trade_type":"JSAPI","trade_state":"SUCCESS","bank_type":"CMC","attach":"深圳分店","success_time":"2015-05-20T13:29:35.120+08:00","transaction_id":"1009660380201506130728806387","out_trade_no":"20150806125346","amount":{"total_amount":10,"currency":"CNY","payer_amount":10,"payer_currency":"CNY"}},{"mchid":"1900000109","sub_mchid":"1900000110","trade_type":"JSAPI","trade_state":"SUCCESS","attach":"广州分店","transaction_id":"1009660380201506130728806388","out_trade_no":"20150806125347","amount":{"total_amount":20,"currency":"CNY","payer_amount":18,"payer_currency":"CNY"},"promotion_detail":[{"coupon_id":"109519","amount":2,"type":"CASH"}]}],"combine_payer_info":{"openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}}`
+
+func TestQueryAndCloseCombineOrder(t *testing.T) {
+	var closeBody map[string]interface{}
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "GET /v3/combine-transactions/out-trade-no/P20150806125346":
+			w.Write([]byte(testCombineTransaction))
+		case "POST /v3/combine-transactions/out-trade-no/P20150806125346/close":
+			json.NewDecoder(r.Body).Decode(&closeBody)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	tx, err := client.QueryCombineOrder(context.Background(), "P20150806125346")
+	if err != nil {
+		t.Fatalf("QueryCombineOrder failed: %v", err)
+	}
+	if !tx.Paid() || len(tx.SubOrders) != 2 || tx.SubOrders[1].Amount.PayerAmount != 18 || tx.SubOrders[1].PromotionDetail[0].CouponID != "109519" {
+		t.Errorf("Unexpected transaction: %+v", tx)
+	}
+	if tx.CombinePayerInfo.Openid != "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o" {
+		t.Errorf("Unexpected payer: %+v", tx.CombinePayerInfo)
+	}
+
+	err = client.CloseCombineOrder(context.Background(), "wxd678efh567hg6787", "P20150806125346", []CloseCombineSubOrder{
+		{OutTradeNo: "20150806125346"},
+		{MchID: "1900000109", SubMchID: "1900000110", OutTradeNo: "20150806125347"},
+	})
+	if err != nil {
+		t.Fatalf("CloseCombineOrder failed: %v", err)
+	}
+	subOrders := closeBody["sub_orders"].([]interface{})
+	if closeBody["combine_appid"] != "wxd678efh567hg6787" || len(subOrders) != 2 {
+		t.Fatalf("Unexpected close body: %v", closeBody)
+	}
+	if first := subOrders[0].(map[string]interface{}); first["mchid"] != testMchID || first["out_trade_no"] != "20150806125346" {
+		t.Errorf("Unexpected close sub-order: %v", first)
+	}
+
+	if err := client.CloseCombineOrder(context.Background(), "wxd678efh567hg6787", "P20150806125346", nil); err == nil {
+		t.Error("Expected error closing without sub-orders")
+	}
+}
+
+func TestCombineTransactionNotifyHandler(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+
+	var got *CombineTransaction
+	handler := NewCombineTransactionNotifyHandler(parser, func(ctx context.Context, notify *NotifyRequest, transaction *CombineTransaction) error {
+		got = transaction
+		return nil
+	})
+
+	var resource map[string]interface{}
+	json.Unmarshal([]byte(testCombineTransaction), &resource)
+	req := newTestNotifyRequest(t, key, serial, time.Now(), resource)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got == nil || got.CombineOutTradeNo != "P20150806125346" || !got.Paid() || got.SubOrders[0].TransactionID != "1009660380201506130728806387" {
+		t.Errorf("Unexpected notification: %+v", got)
+	}
+}
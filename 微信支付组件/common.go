@@ -1,135 +1,503 @@
 package wechatpay
 
 import (
+	"bytes"
 	"context"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/sha256"
-	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	defaultBaseURL = "https://api.mch.weixin.qq.com"
+	authType       = "WECHATPAY2-SHA256-RSA2048"
+	userAgent      = "WechatPay-Go/1.0"
+)
+
+// cstLocation is China Standard Time, the zone WeChat Pay writes bill
+// timestamps in and expects order expiry times in.
+var cstLocation = time.FixedZone("CST", 8*60*60)
+
 type ErrorResponse struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
 }
 
-type Credential struct {
+type Config struct {
+	MchID string
+	// SerialNo may be left empty when Certificate is set.
+	SerialNo   string
+	PrivateKey *rsa.PrivateKey
+	// Certificate is the merchant certificate (apiclient_cert.pem or the
+	// one inside apiclient_cert.p12). When set, SerialNo is derived from it
+	// and PrivateKey must match it.
+	Certificate *x509.Certificate
 	MchAPIv3Key string
-	CertSerialNo string
-	PrivateKey  *rsa.PrivateKey
+	// HTTPClient defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+	// BaseURL defaults to https://api.mch.weixin.qq.com.
+	BaseURL string
+	// Verifier checks WeChat Pay signatures. When nil the client downloads
+	// platform certificates itself through a CertificateManager.
+	Verifier Verifier
 }
 
-func doRequest(ctx context.Context, req *http.Request, credential *Credential) (*http.Response, error) {
-	timestamp := generateTimestamp()
-	nonce := generateNonce()
-	
-	if req.Body != nil {
-		bodyBytes, err := io.ReadAll(req.Body)
-		if err != nil {
-			return nil, err
+// Client calls the WeChat Pay v3 API as one merchant.
+type Client struct {
+	mchID      string
+	serialNo   string
+	privateKey *rsa.PrivateKey
+	apiV3Key   string
+	httpClient *http.Client
+	baseURL    string
+
+	verifier     Verifier
+	certificates *CertificateManager
+
+	// subMchID and subAppid are set on clients returned by WithSubMerchant.
+	subMchID string
+	subAppid string
+}
+
+// SubMerchant is a merchant a service provider (服务商) processes payments for.
+type SubMerchant struct {
+	MchID string
+	// Appid is the sub-merchant's own appid, needed when payers are
+	// identified by sub_openid.
+	Appid string
+}
+
+func NewClient(config *Config) (*Client, error) {
+	if config.MchID == "" {
+		return nil, errors.New("mchid is required")
+	}
+	if config.PrivateKey == nil {
+		return nil, errors.New("private key is required")
+	}
+	serialNo := config.SerialNo
+	if config.Certificate != nil {
+		pub, ok := config.Certificate.PublicKey.(*rsa.PublicKey)
+		if !ok || !pub.Equal(&config.PrivateKey.PublicKey) {
+			return nil, errors.New("private key does not match the merchant certificate")
 		}
-		req.Body.Close()
-		req.Body = io.NopCloser(strings.NewReader(string(bodyBytes)))
-		req.ContentLength = int64(len(bodyBytes))
+		if serialNo == "" {
+			serialNo = CertificateSerial(config.Certificate)
+		} else if !strings.EqualFold(serialNo, CertificateSerial(config.Certificate)) {
+			return nil, fmt.Errorf("serial number %s does not match the merchant certificate", serialNo)
+		}
+	}
+	if serialNo == "" {
+		return nil, errors.New("serial number or merchant certificate is required")
+	}
+	if len(config.MchAPIv3Key) != 32 {
+		return nil, errors.New("apiv3 key must be 32 bytes")
+	}
+
+	c := &Client{
+		mchID:      config.MchID,
+		serialNo:   serialNo,
+		privateKey: config.PrivateKey,
+		apiV3Key:   config.MchAPIv3Key,
+		httpClient: config.HTTPClient,
+		baseURL:    config.BaseURL,
+		verifier:   config.Verifier,
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.baseURL == "" {
+		c.baseURL = defaultBaseURL
+	}
+	if c.verifier == nil {
+		c.certificates = NewCertificateManager(c)
+		c.verifier = c.certificates
+	}
+	return c, nil
+}
+
+func (c *Client) MchID() string {
+	return c.mchID
+}
+
+// WithSubMerchant returns a client that calls the partner endpoints
+// (/v3/pay/partner/transactions/*) on behalf of sub. The receiver must be
+// configured with the service provider's mchid and key: requests are still
+// signed with them, and the returned client shares the HTTP client and
+// platform certificates of c.
+func (c *Client) WithSubMerchant(sub SubMerchant) *Client {
+	partner := *c
+	partner.subMchID = sub.MchID
+	partner.subAppid = sub.Appid
+	return &partner
+}
+
+func (c *Client) isPartner() bool {
+	return c.subMchID != ""
+}
+
+// transactionsPath is the prefix of the order endpoints, which differs
+// between direct merchants and service providers.
+func (c *Client) transactionsPath() string {
+	if c.isPartner() {
+		return "/v3/pay/partner/transactions"
+	}
+	return "/v3/pay/transactions"
+}
+
+// merchantQuery is the query string identifying the merchant on GET requests.
+func (c *Client) merchantQuery() string {
+	if c.isPartner() {
+		return "sp_mchid=" + url.QueryEscape(c.mchID) + "&sub_mchid=" + url.QueryEscape(c.subMchID)
+	}
+	return "mchid=" + url.QueryEscape(c.mchID)
+}
+
+// Certificates returns the platform certificate manager, or nil when the
+// client was configured with its own Verifier.
+func (c *Client) Certificates() *CertificateManager {
+	return c.certificates
+}
+
+func (c *Client) newRequest(method, path string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// RequestOption adjusts an outgoing request before it is signed.
+type RequestOption func(*http.Request)
+
+// WithWechatpaySerial sets the Wechatpay-Serial header naming the platform
+// certificate that encrypted the request's sensitive fields. An empty serial
+// leaves the request unchanged.
+func WithWechatpaySerial(serial string) RequestOption {
+	return func(req *http.Request) {
+		if serial != "" {
+			req.Header.Set("Wechatpay-Serial", serial)
+		}
+	}
+}
+
+// WithSensitiveEncrypter sets Wechatpay-Serial to the certificate enc
+// encrypted with, or nothing when enc was never used.
+func WithSensitiveEncrypter(enc *SensitiveEncrypter) RequestOption {
+	return func(req *http.Request) {
+		WithWechatpaySerial(enc.Serial())(req)
 	}
+}
 
-	signature, err := generateSignature(req, credential, timestamp, nonce)
+// newJSONRequest is newRequest with body marshalled as JSON; a nil body
+// sends no payload.
+func (c *Client) newJSONRequest(method, path string, body interface{}, opts ...RequestOption) (*http.Request, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := c.newRequest(method, path, data)
 	if err != nil {
 		return nil, err
 	}
+	for _, opt := range opts {
+		opt(req)
+	}
+	return req, nil
+}
 
-	authHeader := fmt.Sprintf(
-		`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",timestamp="%s",serial_no="%s",signature="%s"`,
-		credential.CertSerialNo[:10], nonce, timestamp, credential.CertSerialNo, signature,
-	)
-	req.Header.Set("Authorization", authHeader)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "WechatPay-Go/1.0")
+// Do calls a v3 API that has no dedicated method. body is sent as JSON
+// (nil sends nothing) and a 2xx answer is decoded into result, which may be
+// nil. Use WithSensitiveEncrypter when body carries encrypted fields.
+func (c *Client) Do(ctx context.Context, method, path string, body, result interface{}, opts ...RequestOption) error {
+	req, err := c.newJSONRequest(method, path, body, opts...)
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, req, result)
+}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: false},
-		},
+// doJSON sends req and decodes a 2xx response into result, which may be nil
+// for endpoints that answer 204. Other statuses become an *APIError.
+func (c *Client) doJSON(ctx context.Context, req *http.Request, result interface{}) error {
+	resp, err := c.doRequest(ctx, req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	resp, err := client.Do(req.WithContext(ctx))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// doRequest signs and sends req. Successful responses are verified against
+// the platform certificates before they are returned; error responses are
+// passed through unverified so callers can report them.
+func (c *Client) doRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := c.sendRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp, nil
+	}
 
-	if err := verifyResponse(resp, credential); err != nil {
+	if err := c.verifyResponse(ctx, resp); err != nil {
 		resp.Body.Close()
 		return nil, err
 	}
-
 	return resp, nil
 }
 
-func generateSignature(req *http.Request, cred *Credential, timestamp, nonce string) (string, error) {
-	var body string
+func (c *Client) sendRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
+	var body []byte
 	if req.Body != nil {
-		bodyBytes, _ := io.ReadAll(req.Body)
-		req.Body = io.NopCloser(strings.NewReader(string(bodyBytes)))
-		body = string(bodyBytes)
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return c.sendSigned(ctx, req, body)
+}
+
+// sendSigned sends req with an Authorization computed over signedBody. It
+// differs from the payload only for uploads, where just the meta part of
+// the multipart body is signed.
+func (c *Client) sendSigned(ctx context.Context, req *http.Request, signedBody []byte) (*http.Response, error) {
+	authorization, err := c.authorization(req.Method, req.URL.RequestURI(), signedBody)
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	return c.httpClient.Do(req.WithContext(ctx))
+}
+
+func (c *Client) authorization(method, canonicalURL string, body []byte) (string, error) {
+	timestamp := generateTimestamp()
+	nonce := generateNonce()
 
-	url := req.URL.Path
-	if req.URL.RawQuery != "" {
-		url += "?" + req.URL.RawQuery
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", method, canonicalURL, timestamp, nonce, body)
+	signature, err := c.sign([]byte(message))
+	if err != nil {
+		return "", err
 	}
 
-	signStr := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", 
-		req.Method, url, timestamp, nonce, body)
+	return fmt.Sprintf(`%s mchid="%s",nonce_str="%s",timestamp="%s",serial_no="%s",signature="%s"`,
+		authType, c.mchID, nonce, timestamp, c.serialNo, signature), nil
+}
+
+// sign returns the base64 SHA256-RSA signature of message with the merchant key.
+func (c *Client) sign(message []byte) (string, error) {
+	return signMessage(c.privateKey, message)
+}
 
-	hashed := sha256.Sum256([]byte(signStr))
-	signature, err := rsa.SignPKCS1v15(rand.Reader, cred.PrivateKey, crypto.SHA256, hashed[:])
+func signMessage(key *rsa.PrivateKey, message []byte) (string, error) {
+	hashed := sha256.Sum256(message)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
 	if err != nil {
 		return "", err
 	}
 	return base64.StdEncoding.EncodeToString(signature), nil
 }
 
-func verifyResponse(resp *http.Response, cred *Credential) error {
-	signature := resp.Header.Get("Wechatpay-Signature")
-	timestamp := resp.Header.Get("Wechatpay-Timestamp")
-	nonce := resp.Header.Get("Wechatpay-Nonce")
-	serial := resp.Header.Get("Wechatpay-Serial")
+func (c *Client) verifyResponse(ctx context.Context, resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return c.verify(ctx, resp.Header, body)
+}
+
+// verify checks the Wechatpay-* signature headers of a response or callback.
+func (c *Client) verify(ctx context.Context, header http.Header, body []byte) error {
+	signature := header.Get("Wechatpay-Signature")
+	timestamp := header.Get("Wechatpay-Timestamp")
+	nonce := header.Get("Wechatpay-Nonce")
+	serial := header.Get("Wechatpay-Serial")
 
 	if signature == "" || timestamp == "" || nonce == "" || serial == "" {
 		return fmt.Errorf("missing wechatpay headers")
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	if c.certificates != nil {
+		if err := c.certificates.ensure(ctx, serial); err != nil {
+			return err
+		}
+	}
+	return c.verifier.Verify(serial, buildVerifyMessage(timestamp, nonce, body), signature)
+}
+
+// EncryptOAEP encrypts a sensitive field, such as a name, ID number or phone
+// number, with RSA-OAEP (SHA1) and returns it base64-encoded as the v3 APIs
+// expect. pub is the public key of a platform certificate.
+func EncryptOAEP(pub *rsa.PublicKey, plaintext string) (string, error) {
+	encrypted, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, []byte(plaintext), nil)
 	if err != nil {
-		return err
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// DecryptOAEP reverses EncryptOAEP. WeChat Pay encrypts sensitive fields in
+// responses with the merchant certificate, so priv is the merchant key.
+func DecryptOAEP(priv *rsa.PrivateKey, ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, priv, data, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// DecryptSensitive decrypts a field of a response, such as the user_name of
+// a transfer detail, with the merchant private key.
+func (c *Client) DecryptSensitive(ciphertext string) (string, error) {
+	return DecryptOAEP(c.privateKey, ciphertext)
+}
+
+// SensitiveEncrypter encrypts the sensitive fields of one request. Every
+// field is encrypted with the same platform certificate, picked on first
+// use, so that a single Wechatpay-Serial header covers all of them.
+type SensitiveEncrypter struct {
+	client *Client
+	serial string
+	pub    *rsa.PublicKey
+}
+
+func (c *Client) NewSensitiveEncrypter() *SensitiveEncrypter {
+	return &SensitiveEncrypter{client: c}
+}
+
+// Encrypt encrypts plaintext with the newest platform certificate,
+// downloading the certificates first if none are cached.
+func (e *SensitiveEncrypter) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	if e.pub == nil {
+		if err := e.load(ctx); err != nil {
+			return "", err
+		}
 	}
-	resp.Body = io.NopCloser(strings.NewReader(string(body)))
+	return EncryptOAEP(e.pub, plaintext)
+}
 
-	message := fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, string(body))
-	hashed := sha256.Sum256([]byte(message))
+// Serial is the serial number of the certificate used, or empty when
+// nothing has been encrypted yet.
+func (e *SensitiveEncrypter) Serial() string {
+	return e.serial
+}
 
-	sigBytes, err := base64.StdEncoding.DecodeString(signature)
+func (e *SensitiveEncrypter) load(ctx context.Context) error {
+	certs := e.client.certificates
+	if certs == nil {
+		return errors.New("encrypting sensitive fields requires the platform certificate manager")
+	}
+	serial, cert, err := certs.Latest()
+	if errors.Is(err, ErrNoPlatformCertificate) {
+		if err := certs.Refresh(ctx); err != nil {
+			return err
+		}
+		serial, cert, err = certs.Latest()
+	}
 	if err != nil {
 		return err
 	}
 
-	return rsa.VerifyPKCS1v15(&cred.PrivateKey.PublicKey, crypto.SHA256, hashed[:], sigBytes)
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("platform certificate %s is not RSA", serial)
+	}
+	e.serial, e.pub = serial, pub
+	return nil
+}
+
+func decryptAES256GCM(apiV3Key, associatedData, nonce, ciphertext string) ([]byte, error) {
+	if len(apiV3Key) != 32 {
+		return nil, fmt.Errorf("invalid apiv3 key length: %d", len(apiV3Key))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher([]byte(apiV3Key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length: %d", len(nonce))
+	}
+
+	return gcm.Open(nil, []byte(nonce), decoded, []byte(associatedData))
+}
+
+// encryptAES256GCM is the inverse of decryptAES256GCM, used by Sandbox to
+// produce notification resources and certificates. nonce must be 12 bytes.
+func encryptAES256GCM(apiV3Key, associatedData, nonce string, plaintext []byte) (string, error) {
+	block, err := aes.NewCipher([]byte(apiV3Key))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, []byte(nonce), plaintext, []byte(associatedData))
+	return base64.StdEncoding.EncodeToString(sealed), nil
 }
 
 func generateNonce() string {
-	n, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
-	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, 32)
+	rand.Read(b)
+	for i := range b {
+		b[i] = charset[int(b[i])%len(charset)]
+	}
+	return string(b)
 }
 
 func generateTimestamp() string {
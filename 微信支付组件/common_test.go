@@ -1,32 +1,141 @@
 package wechatpay
 
 import (
-	"bytes"
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/tls"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"strings"
+	"regexp"
+	"strconv"
 	"testing"
 	"time"
 )
 
+const (
+	testAPIv3Key   = "0123456789abcdef0123456789abcdef"
+	testMchID      = "1230000109"
+	testSerialNo   = "3775B6A45ACD588826D15E583A95F5DD"
+	testPlatformSN = 0x5157F09EFDC096DE
+)
+
 func generateTestKeyPair() (*rsa.PrivateKey, string) {
 	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	publicKey := &privateKey.PublicKey
-	pubASN1, _ := x509.MarshalPKIXPublicKey(publicKey)
-	pubBytes := pem.EncodeToMemory(&pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pubASN1,
+	pubASN1, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	return privateKey, base64.StdEncoding.EncodeToString(pubASN1)
+}
+
+type testPlatform struct {
+	key    *rsa.PrivateKey
+	cert   *x509.Certificate
+	serial string
+}
+
+func newTestPlatform(t *testing.T) *testPlatform {
+	t.Helper()
+	key, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, key, testPlatformSN, time.Now().Add(24*time.Hour))
+	return &testPlatform{key: key, cert: cert, serial: CertificateSerial(cert)}
+}
+
+// handler wraps h so every response carries a valid platform signature.
+func (p *testPlatform) handler(t *testing.T, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		h(rec, r)
+
+		body := rec.Body.Bytes()
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := generateNonce()
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Wechatpay-Timestamp", timestamp)
+		w.Header().Set("Wechatpay-Nonce", nonce)
+		w.Header().Set("Wechatpay-Serial", p.serial)
+		w.Header().Set("Wechatpay-Signature", signTestMessage(t, p.key, buildVerifyMessage(timestamp, nonce, body)))
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	}
+}
+
+// newTestClient starts a server that signs the responses of h and returns a
+// client pointed at it.
+func newTestClient(t *testing.T, h http.HandlerFunc) (*Client, *testPlatform) {
+	t.Helper()
+	platform := newTestPlatform(t)
+	ts := httptest.NewServer(platform.handler(t, h))
+	t.Cleanup(ts.Close)
+
+	merchantKey, _ := generateTestKeyPair()
+	verifier, _ := NewCertificateVerifier(platform.cert)
+	client, err := NewClient(&Config{
+		MchID:       testMchID,
+		SerialNo:    testSerialNo,
+		PrivateKey:  merchantKey,
+		MchAPIv3Key: testAPIv3Key,
+		BaseURL:     ts.URL,
+		Verifier:    verifier,
 	})
-	return privateKey, base64.StdEncoding.EncodeToString(pubBytes)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return client, platform
+}
+
+var authorizationPattern = regexp.MustCompile(`^WECHATPAY2-SHA256-RSA2048 mchid="([^"]+)",nonce_str="([^"]+)",timestamp="([^"]+)",serial_no="([^"]+)",signature="([^"]+)"$`)
+
+// verifyTestAuthorization checks the Authorization header of r the way
+// WeChat Pay does and returns the mchid it was signed for.
+func verifyTestAuthorization(r *http.Request, body []byte, pub *rsa.PublicKey) (string, error) {
+	m := authorizationPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return "", fmt.Errorf("malformed authorization: %s", r.Header.Get("Authorization"))
+	}
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", r.Method, r.URL.RequestURI(), m[3], m[2], body)
+	if err := verifySignature(pub, []byte(message), m[5]); err != nil {
+		return "", err
+	}
+	return m[1], nil
+}
+
+func TestNewClient(t *testing.T) {
+	key, _ := generateTestKeyPair()
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"missing mchid", Config{SerialNo: testSerialNo, PrivateKey: key, MchAPIv3Key: testAPIv3Key}},
+		{"missing serial", Config{MchID: testMchID, PrivateKey: key, MchAPIv3Key: testAPIv3Key}},
+		{"missing key", Config{MchID: testMchID, SerialNo: testSerialNo, MchAPIv3Key: testAPIv3Key}},
+		{"short apiv3 key", Config{MchID: testMchID, SerialNo: testSerialNo, PrivateKey: key, MchAPIv3Key: "short"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient(&tt.config); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+
+	client, err := NewClient(&Config{MchID: testMchID, SerialNo: testSerialNo, PrivateKey: key, MchAPIv3Key: testAPIv3Key})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if client.baseURL != defaultBaseURL || client.httpClient == nil {
+		t.Errorf("Expected defaults, got %s %v", client.baseURL, client.httpClient)
+	}
+	if client.Certificates() == nil {
+		t.Error("Expected a certificate manager when no verifier is configured")
+	}
 }
 
 func TestGenerateNonce(t *testing.T) {
@@ -35,10 +144,8 @@ func TestGenerateNonce(t *testing.T) {
 	if nonce1 == nonce2 {
 		t.Errorf("Expected unique nonces, got %s and %s", nonce1, nonce2)
 	}
-
-	decoded, err := base64.RawURLEncoding.DecodeString(nonce1)
-	if err != nil || len(decoded) != 32 {
-		t.Errorf("Invalid nonce format: %s", nonce1)
+	if len(nonce1) != 32 {
+		t.Errorf("Invalid nonce length: %s", nonce1)
 	}
 }
 
@@ -55,110 +162,79 @@ func TestGenerateTimestamp(t *testing.T) {
 	}
 }
 
-func TestGenerateSignature(t *testing.T) {
-	privateKey, _ := generateTestKeyPair()
-	cred := &Credential{
-		PrivateKey: privateKey,
-	}
-
-	req := httptest.NewRequest("POST", "/test?q=1", strings.NewReader(`{"data":"value"}`))
-	req.Header.Set("Content-Type", "application/json")
+func TestClientSign(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
 
-	sig, err := generateSignature(req, cred, "1650000000", "testnonce")
+	sig, err := client.sign([]byte("message"))
 	if err != nil {
 		t.Fatalf("Signature generation failed: %v", err)
 	}
 
-	sigBytes, err := base64.StdEncoding.DecodeString(sig)
-	if err != nil || len(sigBytes) != 256 {
-		t.Errorf("Invalid signature format: %s", sig)
+	hashed := sha256.Sum256([]byte("message"))
+	sigBytes, _ := base64.StdEncoding.DecodeString(sig)
+	if err := rsa.VerifyPKCS1v15(&client.privateKey.PublicKey, crypto.SHA256, hashed[:], sigBytes); err != nil {
+		t.Errorf("Invalid signature: %v", err)
 	}
 }
 
-func TestVerifyResponse(t *testing.T) {
-	privateKey, pubKey := generateTestKeyPair()
-	cred := &Credential{
-		PrivateKey: privateKey,
-	}
-
-	body := `{"code":"SUCCESS"}`
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	nonce := "testnonce"
-
-	msg := fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body)
-	hashed := sha256.Sum256([]byte(msg))
-	signature, _ := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
-	sigStr := base64.StdEncoding.EncodeToString(signature)
+func TestDoRequest(t *testing.T) {
+	var client *Client
+	var gotMchID string
+	client, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mchid, err := verifyTestAuthorization(r, body, &client.privateKey.PublicKey)
+		if err != nil {
+			t.Errorf("Request signature invalid: %v", err)
+		}
+		gotMchID = mchid
+		w.Write([]byte(`{"code":"SUCCESS"}`))
+	})
 
-	resp := &http.Response{
-		Header: http.Header{
-			"Wechatpay-Signature": []string{sigStr},
-			"Wechatpay-Timestamp": []string{timestamp},
-			"Wechatpay-Nonce":     []string{nonce},
-			"Wechatpay-Serial":    []string{"1234567890"},
-		},
-		Body: io.NopCloser(strings.NewReader(body)),
+	req, _ := client.newRequest("POST", "/v3/test?q=1", []byte(`{"data":"value"}`))
+	resp, err := client.doRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
 	}
+	defer resp.Body.Close()
 
-	if err := verifyResponse(resp, cred); err != nil {
-		t.Errorf("Valid signature verification failed: %v", err)
+	if gotMchID != testMchID {
+		t.Errorf("Expected mchid %s, got %s", testMchID, gotMchID)
 	}
-
-	resp.Header.Set("Wechatpay-Signature", "invalid")
-	if err := verifyResponse(resp, cred); err == nil {
-		t.Error("Invalid signature verification should fail")
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"code":"SUCCESS"}` {
+		t.Errorf("Unexpected response body: %s", body)
 	}
 }
 
-func TestDoRequest(t *testing.T) {
-	privateKey, pubKey := generateTestKeyPair()
-	cred := &Credential{
-		MchAPIv3Key:  "testkey",
-		CertSerialNo: "1234567890",
-		PrivateKey:   privateKey,
-	}
+func TestDoRequest_RejectsUnsignedResponse(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
 
-	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Wechatpay-Signature", "test")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Wechatpay-Signature", "forged")
 		w.Header().Set("Wechatpay-Timestamp", "1234567890")
 		w.Header().Set("Wechatpay-Nonce", "nonce")
-		w.Header().Set("Wechatpay-Serial", "1234567890")
+		w.Header().Set("Wechatpay-Serial", "5157F09EFDC096DE")
 		w.Write([]byte(`{"code":"SUCCESS"}`))
 	}))
 	defer ts.Close()
+	client.baseURL = ts.URL
 
-	ctx := context.Background()
-	req, _ := http.NewRequest("GET", ts.URL, nil)
-	resp, err := doRequest(ctx, req, cred)
-
-	if err != nil {
-		t.Fatalf("Request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", resp.StatusCode)
-	}
-
-	body, _ := io.ReadAll(resp.Body)
-	if string(body) != `{"code":"SUCCESS"}` {
-		t.Errorf("Unexpected response body: %s", body)
+	req, _ := client.newRequest("GET", "/v3/test", nil)
+	if _, err := client.doRequest(context.Background(), req); err == nil {
+		t.Error("Expected forged response to be rejected")
 	}
 }
 
 func TestDoRequestError(t *testing.T) {
-	privateKey, _ := generateTestKeyPair()
-	cred := &Credential{
-		PrivateKey: privateKey,
-	}
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
 
 	tests := []struct {
 		name string
 		req  *http.Request
 	}{
-		{"Invalid URL", httptest.NewRequest("GET", "http://invalid.url", nil)},
+		{"Invalid URL", httptest.NewRequest("GET", "http://invalid.invalid", nil)},
 		{"Read Body Error", func() *http.Request {
-			req := httptest.NewRequest("POST", "/", errorReader{})
+			req := httptest.NewRequest("POST", "/", nil)
 			req.Body = errorReader{}
 			return req
 		}()},
@@ -166,8 +242,8 @@ func TestDoRequestError(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ctx := context.Background()
-			_, err := doRequest(ctx, tt.req, cred)
+			tt.req.RequestURI = ""
+			_, err := client.doRequest(context.Background(), tt.req)
 			if err == nil {
 				t.Error("Expected error but got none")
 			}
@@ -175,9 +251,154 @@ func TestDoRequestError(t *testing.T) {
 	}
 }
 
+func TestVerify(t *testing.T) {
+	client, platform := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	body := []byte(`{"code":"SUCCESS"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{}
+	header.Set("Wechatpay-Signature", signTestMessage(t, platform.key, buildVerifyMessage(timestamp, "testnonce", body)))
+	header.Set("Wechatpay-Timestamp", timestamp)
+	header.Set("Wechatpay-Nonce", "testnonce")
+	header.Set("Wechatpay-Serial", platform.serial)
+
+	if err := client.verify(context.Background(), header, body); err != nil {
+		t.Errorf("Valid signature verification failed: %v", err)
+	}
+
+	header.Set("Wechatpay-Signature", "invalid")
+	if err := client.verify(context.Background(), header, body); err == nil {
+		t.Error("Invalid signature verification should fail")
+	}
+
+	header.Del("Wechatpay-Nonce")
+	if err := client.verify(context.Background(), header, body); err == nil {
+		t.Error("Missing headers should fail")
+	}
+}
+
+func TestDecryptAES256GCM(t *testing.T) {
+	ciphertext := encryptTestResource(t, testAPIv3Key, "certificate", "0123456789ab", []byte(`{"ok":true}`))
+
+	plaintext, err := decryptAES256GCM(testAPIv3Key, "certificate", "0123456789ab", ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if string(plaintext) != `{"ok":true}` {
+		t.Errorf("Unexpected plaintext: %s", plaintext)
+	}
+
+	if _, err := decryptAES256GCM(testAPIv3Key, "transaction", "0123456789ab", ciphertext); err == nil {
+		t.Error("Mismatched associated data should fail")
+	}
+	if _, err := decryptAES256GCM("short", "certificate", "0123456789ab", ciphertext); err == nil {
+		t.Error("Invalid key length should fail")
+	}
+	if _, err := decryptAES256GCM(testAPIv3Key, "certificate", "short", ciphertext); err == nil {
+		t.Error("Invalid nonce length should fail")
+	}
+}
+
 type errorReader struct{}
 
 func (errorReader) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("simulated read error")
 }
 func (errorReader) Close() error { return nil }
+
+// useTestCertificates gives client a certificate manager that already holds
+// the platform certificate, as needed for encrypting sensitive fields.
+func useTestCertificates(client *Client, platform *testPlatform) {
+	client.certificates = NewCertificateManager(client)
+	client.certificates.certs[platform.serial] = platform.cert
+}
+
+func decryptTestSensitive(t *testing.T, key *rsa.PrivateKey, ciphertext string) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		t.Fatalf("decode ciphertext: %v", err)
+	}
+	plaintext, err := rsa.DecryptOAEP(sha1.New(), nil, key, data, nil)
+	if err != nil {
+		t.Fatalf("decrypt ciphertext: %v", err)
+	}
+	return string(plaintext)
+}
+
+func TestSensitiveEncrypter(t *testing.T) {
+	client, platform := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	if _, err := client.NewSensitiveEncrypter().Encrypt(context.Background(), "张三"); err == nil {
+		t.Error("Expected error without certificate manager")
+	}
+
+	useTestCertificates(client, platform)
+	enc := client.NewSensitiveEncrypter()
+	req, _ := http.NewRequest("POST", "/", nil)
+	WithSensitiveEncrypter(enc)(req)
+	if req.Header.Get("Wechatpay-Serial") != "" {
+		t.Error("Unused encrypter should not set Wechatpay-Serial")
+	}
+
+	ciphertext, err := enc.Encrypt(context.Background(), "张三")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if enc.Serial() != platform.serial {
+		t.Errorf("Expected serial %s, got %s", platform.serial, enc.Serial())
+	}
+	if got := decryptTestSensitive(t, platform.key, ciphertext); got != "张三" {
+		t.Errorf("Expected 张三, got %s", got)
+	}
+	WithSensitiveEncrypter(enc)(req)
+	if req.Header.Get("Wechatpay-Serial") != platform.serial {
+		t.Errorf("Expected Wechatpay-Serial %s, got %q", platform.serial, req.Header.Get("Wechatpay-Serial"))
+	}
+}
+
+func TestDecryptSensitive(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	ciphertext, err := EncryptOAEP(&client.privateKey.PublicKey, "13800138000")
+	if err != nil {
+		t.Fatalf("EncryptOAEP failed: %v", err)
+	}
+	plaintext, err := client.DecryptSensitive(ciphertext)
+	if err != nil || plaintext != "13800138000" {
+		t.Errorf("DecryptSensitive: %q, %v", plaintext, err)
+	}
+	if _, err := client.DecryptSensitive("not base64"); err == nil {
+		t.Error("Expected error for invalid ciphertext")
+	}
+}
+
+func TestDo(t *testing.T) {
+	var client *Client
+	var platform *testPlatform
+	client, platform = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if _, err := verifyTestAuthorization(r, body, &client.privateKey.PublicKey); err != nil {
+			t.Errorf("Invalid authorization: %v", err)
+		}
+		if r.Method != "POST" || r.URL.Path != "/v3/custom/api" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Wechatpay-Serial") != platform.serial {
+			t.Errorf("Expected Wechatpay-Serial %s, got %q", platform.serial, r.Header.Get("Wechatpay-Serial"))
+		}
+		w.Write([]byte(`{"result":"ok"}`))
+	})
+	useTestCertificates(client, platform)
+
+	enc := client.NewSensitiveEncrypter()
+	phone, err := enc.Encrypt(context.Background(), "13800138000")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	var result struct {
+		Result string `json:"result"`
+	}
+	err = client.Do(context.Background(), "POST", "/v3/custom/api", map[string]string{"phone": phone}, &result, WithSensitiveEncrypter(enc))
+	if err != nil || result.Result != "ok" {
+		t.Errorf("Do: %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,443 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	complaintsPath             = "/v3/merchant-service/complaints-v2"
+	complaintNotificationsPath = "/v3/merchant-service/complaint-notifications"
+	complaintImagesPath        = "/v3/merchant-service/images/upload"
+	maxComplaintDays           = 30
+	maxComplaintPageSize       = 50
+	maxComplaintImageSize      = 2 << 20
+)
+
+// Complaint states and the action types of COMPLAINT.* notifications.
+const (
+	ComplaintStatePending    = "PENDING"
+	ComplaintStateProcessing = "PROCESSING"
+	ComplaintStateProcessed  = "PROCESSED"
+
+	ComplaintActionCreate                    = "CREATE_COMPLAINT"
+	ComplaintActionContinue                  = "CONTINUE_COMPLAINT"
+	ComplaintActionUserResponse              = "USER_RESPONSE"
+	ComplaintActionResponseByPlatform        = "RESPONSE_BY_PLATFORM"
+	ComplaintActionSellerRefund              = "SELLER_REFUND"
+	ComplaintActionMerchantResponse          = "MERCHANT_RESPONSE"
+	ComplaintActionMerchantConfirm           = "MERCHANT_CONFIRM_COMPLETE"
+	ComplaintActionUserApplyPlatformService  = "USER_APPLY_PLATFORM_SERVICE"
+	ComplaintActionUserCancelPlatformService = "USER_CANCEL_PLATFORM_SERVICE"
+	ComplaintActionPlatformServiceFinished   = "PLATFORM_SERVICE_FINISHED"
+)
+
+type ListComplaintsRequest struct {
+	// BeginDate and EndDate are yyyy-MM-dd and at most 30 days apart.
+	BeginDate string
+	EndDate   string
+	Offset    int
+	// Limit defaults to 10 and is at most 50.
+	Limit int
+}
+
+type ComplaintList struct {
+	Data       []Complaint `json:"data"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+	TotalCount int         `json:"total_count"`
+}
+
+type Complaint struct {
+	ComplaintID      string `json:"complaint_id"`
+	ComplaintTime    string `json:"complaint_time"`
+	ComplaintDetail  string `json:"complaint_detail"`
+	ComplaintState   string `json:"complaint_state"`
+	ComplaintedMchID string `json:"complainted_mchid"`
+	// PayerPhone is decrypted by ListComplaints and GetComplaint.
+	PayerPhone            string               `json:"payer_phone"`
+	PayerOpenid           string               `json:"payer_openid"`
+	ComplaintMediaList    []ComplaintMedia     `json:"complaint_media_list"`
+	ComplaintOrderInfo    []ComplaintOrderInfo `json:"complaint_order_info"`
+	ComplaintFullRefunded bool                 `json:"complaint_full_refunded"`
+	IncomingUserResponse  bool                 `json:"incoming_user_response"`
+	ProblemDescription    string               `json:"problem_description"`
+	UserComplaintTimes    int                  `json:"user_complaint_times"`
+	ProblemType           string               `json:"problem_type"`
+	ApplyRefundAmount     int                  `json:"apply_refund_amount"`
+	UserTagList           []string             `json:"user_tag_list"`
+}
+
+type ComplaintMedia struct {
+	MediaType string   `json:"media_type"`
+	MediaURL  []string `json:"media_url"`
+}
+
+type ComplaintOrderInfo struct {
+	TransactionID string `json:"transaction_id"`
+	OutTradeNo    string `json:"out_trade_no"`
+	Amount        int    `json:"amount"`
+}
+
+type NegotiationHistory struct {
+	Data       []NegotiationRecord `json:"data"`
+	Limit      int                 `json:"limit"`
+	Offset     int                 `json:"offset"`
+	TotalCount int                 `json:"total_count"`
+}
+
+type NegotiationRecord struct {
+	LogID              string          `json:"log_id"`
+	Operator           string          `json:"operator"`
+	OperateTime        string          `json:"operate_time"`
+	OperateType        string          `json:"operate_type"`
+	OperateDetails     string          `json:"operate_details"`
+	ImageList          []string        `json:"image_list"`
+	ComplaintMediaList *ComplaintMedia `json:"complaint_media_list,omitempty"`
+}
+
+type RespondComplaintRequest struct {
+	ResponseContent string
+	// ResponseImages are media IDs returned by UploadComplaintImage.
+	ResponseImages []string
+	JumpURL        string
+	JumpURLText    string
+}
+
+type ComplaintNotificationConfig struct {
+	Mchid string `json:"mchid"`
+	URL   string `json:"url"`
+}
+
+// complaintedMchID is the merchant the complaint was filed against: the
+// sub-merchant for a WithSubMerchant client, otherwise the merchant itself.
+func (c *Client) complaintedMchID() string {
+	if c.isPartner() {
+		return c.subMchID
+	}
+	return c.mchID
+}
+
+// ListComplaints lists the complaints filed in a date range, newest first.
+func (c *Client) ListComplaints(ctx context.Context, params *ListComplaintsRequest) (*ComplaintList, error) {
+	begin, err := time.Parse(billDateLayout, params.BeginDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid begin_date: %s", params.BeginDate)
+	}
+	end, err := time.Parse(billDateLayout, params.EndDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end_date: %s", params.EndDate)
+	}
+	if end.Before(begin) || end.Sub(begin) > maxComplaintDays*24*time.Hour {
+		return nil, fmt.Errorf("date range must be 0 to %d days", maxComplaintDays)
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	if limit > maxComplaintPageSize {
+		return nil, fmt.Errorf("limit must be at most %d", maxComplaintPageSize)
+	}
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(params.Offset))
+	query.Set("begin_date", params.BeginDate)
+	query.Set("end_date", params.EndDate)
+	if c.isPartner() {
+		query.Set("complainted_mchid", c.subMchID)
+	}
+
+	req, err := c.newRequest("GET", complaintsPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list ComplaintList
+	if err := c.doJSON(ctx, req, &list); err != nil {
+		return nil, err
+	}
+	for i := range list.Data {
+		if err := c.decryptComplaint(&list.Data[i]); err != nil {
+			return nil, err
+		}
+	}
+	return &list, nil
+}
+
+func (c *Client) GetComplaint(ctx context.Context, complaintID string) (*Complaint, error) {
+	if complaintID == "" {
+		return nil, errors.New("complaint_id is required")
+	}
+	req, err := c.newRequest("GET", complaintsPath+"/"+url.PathEscape(complaintID), nil)
+	if err != nil {
+		return nil, err
+	}
+	var complaint Complaint
+	if err := c.doJSON(ctx, req, &complaint); err != nil {
+		return nil, err
+	}
+	if err := c.decryptComplaint(&complaint); err != nil {
+		return nil, err
+	}
+	return &complaint, nil
+}
+
+// decryptComplaint replaces the encrypted payer_phone with plain text.
+func (c *Client) decryptComplaint(complaint *Complaint) error {
+	if complaint.PayerPhone == "" {
+		return nil
+	}
+	phone, err := c.DecryptSensitive(complaint.PayerPhone)
+	if err != nil {
+		return fmt.Errorf("decrypt payer_phone of complaint %s: %w", complaint.ComplaintID, err)
+	}
+	complaint.PayerPhone = phone
+	return nil
+}
+
+// ComplaintNegotiationHistory returns the conversation between payer,
+// merchant and WeChat Pay on a complaint, oldest first.
+func (c *Client) ComplaintNegotiationHistory(ctx context.Context, complaintID string, offset, limit int) (*NegotiationHistory, error) {
+	if complaintID == "" {
+		return nil, errors.New("complaint_id is required")
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	if limit > 300 {
+		return nil, errors.New("limit must be at most 300")
+	}
+	path := fmt.Sprintf("%s/%s/negotiation-historys?limit=%d&offset=%d", complaintsPath, url.PathEscape(complaintID), limit, offset)
+	req, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var history NegotiationHistory
+	if err := c.doJSON(ctx, req, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// RespondComplaint sends the merchant's reply to the payer.
+func (c *Client) RespondComplaint(ctx context.Context, complaintID string, params *RespondComplaintRequest) error {
+	if complaintID == "" {
+		return errors.New("complaint_id is required")
+	}
+	if params.ResponseContent == "" || len([]rune(params.ResponseContent)) > 200 {
+		return errors.New("response_content must be 1 to 200 characters")
+	}
+	if len(params.ResponseImages) > 4 {
+		return errors.New("at most 4 response_images are allowed")
+	}
+	if (params.JumpURL == "") != (params.JumpURLText == "") {
+		return errors.New("jump_url and jump_url_text must be set together")
+	}
+
+	body := map[string]interface{}{
+		"complainted_mchid": c.complaintedMchID(),
+		"response_content":  params.ResponseContent,
+	}
+	if len(params.ResponseImages) > 0 {
+		body["response_images"] = params.ResponseImages
+	}
+	if params.JumpURL != "" {
+		body["jump_url"] = params.JumpURL
+		body["jump_url_text"] = params.JumpURLText
+	}
+	req, err := c.newJSONRequest("POST", complaintsPath+"/"+url.PathEscape(complaintID)+"/response", body)
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, req, nil)
+}
+
+// CompleteComplaint marks a complaint as handled. WeChat Pay then asks the
+// payer to confirm.
+func (c *Client) CompleteComplaint(ctx context.Context, complaintID string) error {
+	if complaintID == "" {
+		return errors.New("complaint_id is required")
+	}
+	req, err := c.newJSONRequest("POST", complaintsPath+"/"+url.PathEscape(complaintID)+"/complete", map[string]string{
+		"complainted_mchid": c.complaintedMchID(),
+	})
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, req, nil)
+}
+
+// UploadComplaintImage uploads a JPG, PNG or BMP of at most 2MB for use in
+// RespondComplaint and returns its media_id. image can be any reader, such
+// as an object downloaded from COS or S3.
+func (c *Client) UploadComplaintImage(ctx context.Context, filename string, image io.Reader) (string, error) {
+	contentType, ok := map[string]string{
+		".jpg":  "image/jpeg",
+		".jpeg": "image/jpeg",
+		".png":  "image/png",
+		".bmp":  "image/bmp",
+	}[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type: %s", filename)
+	}
+	data, err := io.ReadAll(io.LimitReader(image, maxComplaintImageSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 || len(data) > maxComplaintImageSize {
+		return "", errors.New("image must be 1 byte to 2MB")
+	}
+
+	sum := sha256.Sum256(data)
+	meta, err := json.Marshal(map[string]string{"filename": filename, "sha256": hex.EncodeToString(sum[:])})
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	metaHeader := textproto.MIMEHeader{}
+	metaHeader.Set("Content-Disposition", `form-data; name="meta"`)
+	metaHeader.Set("Content-Type", "application/json")
+	part, err := writer.CreatePart(metaHeader)
+	if err != nil {
+		return "", err
+	}
+	part.Write(meta)
+	fileHeader := textproto.MIMEHeader{}
+	fileHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename))
+	fileHeader.Set("Content-Type", contentType)
+	if part, err = writer.CreatePart(fileHeader); err != nil {
+		return "", err
+	}
+	part.Write(data)
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+complaintImagesPath, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.sendSigned(ctx, req, meta)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(resp)
+	}
+	if err := c.verifyResponse(ctx, resp); err != nil {
+		return "", err
+	}
+
+	var result struct {
+		MediaID string `json:"media_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.MediaID, nil
+}
+
+// DownloadComplaintImage fetches an image from a complaint's media_url.
+func (c *Client) DownloadComplaintImage(ctx context.Context, mediaURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", mediaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.sendRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseAPIError(resp, data)
+	}
+	return data, nil
+}
+
+// SetComplaintNotificationURL registers the URL that receives COMPLAINT.*
+// notifications, replacing any previous one.
+func (c *Client) SetComplaintNotificationURL(ctx context.Context, notifyURL string) (*ComplaintNotificationConfig, error) {
+	if !strings.HasPrefix(notifyURL, "https://") {
+		return nil, errors.New("complaint notification url must be https")
+	}
+	current, err := c.ComplaintNotificationURL(ctx)
+	method := "POST"
+	if err == nil && current.URL != "" {
+		method = "PUT"
+	} else if err != nil && !errors.Is(err, ErrResourceNotExist) {
+		return nil, err
+	}
+
+	req, err := c.newJSONRequest(method, complaintNotificationsPath, map[string]string{"url": notifyURL})
+	if err != nil {
+		return nil, err
+	}
+	var config ComplaintNotificationConfig
+	if err := c.doJSON(ctx, req, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Client) ComplaintNotificationURL(ctx context.Context) (*ComplaintNotificationConfig, error) {
+	req, err := c.newRequest("GET", complaintNotificationsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	var config ComplaintNotificationConfig
+	if err := c.doJSON(ctx, req, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Client) DeleteComplaintNotificationURL(ctx context.Context) error {
+	req, err := c.newRequest("DELETE", complaintNotificationsPath, nil)
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, req, nil)
+}
+
+// ComplaintNotification is the decrypted resource of the COMPLAINT.CREATE
+// and COMPLAINT.STATE_CHANGE notifications. It only names the complaint;
+// use GetComplaint for the details.
+type ComplaintNotification struct {
+	ComplaintID string `json:"complaint_id"`
+	ActionType  string `json:"action_type"`
+}
+
+type ComplaintHandlerFunc func(ctx context.Context, notify *NotifyRequest, complaint *ComplaintNotification) error
+
+func NewComplaintNotifyHandler(parser *NotifyParser, fn ComplaintHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var complaint ComplaintNotification
+		serveNotify(w, r, parser, &complaint, func(notify *NotifyRequest) error {
+			return fn(r.Context(), notify, &complaint)
+		})
+	})
+}
@@ -0,0 +1,277 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListAndGetComplaint(t *testing.T) {
+	var phone string
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		complaint := `{"complaint_id":"200201820200101080076610000","complaint_time":"2020-01-01T10:01:01+08:00","complaint_detail":"反馈一个重复扣费的问题","complaint_state":"PENDING","payer_phone":"` + phone + `","complaint_order_info":[{"transaction_id":"4200000000000000000000000000","out_trade_no":"20190906154617947762231","amount":3}],"complaint_media_list":[{"media_type":"USER_COMPLAINT_IMAGE","media_url":["https://api.mch.weixin.qq.com/v3/merchant-service/images/xxxxx"]}],"user_complaint_times":1}`
+		switch r.URL.RequestURI() {
+		case "/v3/merchant-service/complaints-v2?begin_date=2020-01-01&end_date=2020-01-30&limit=5&offset=10":
+			w.Write([]byte(`{"data":[` + complaint + `],"limit":5,"offset":10,"total_count":11}`))
+		case "/v3/merchant-service/complaints-v2/200201820200101080076610000":
+			w.Write([]byte(complaint))
+		default:
+			t.Errorf("Unexpected request: %s", r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	var err error
+	if phone, err = EncryptOAEP(&client.privateKey.PublicKey, "13800138000"); err != nil {
+		t.Fatalf("EncryptOAEP failed: %v", err)
+	}
+
+	list, err := client.ListComplaints(context.Background(), &ListComplaintsRequest{BeginDate: "2020-01-01", EndDate: "2020-01-30", Offset: 10, Limit: 5})
+	if err != nil {
+		t.Fatalf("ListComplaints failed: %v", err)
+	}
+	if list.TotalCount != 11 || len(list.Data) != 1 {
+		t.Fatalf("Unexpected list: %+v", list)
+	}
+	got := list.Data[0]
+	if got.PayerPhone != "13800138000" || got.ComplaintState != ComplaintStatePending || got.ComplaintOrderInfo[0].Amount != 3 || len(got.ComplaintMediaList[0].MediaURL) != 1 {
+		t.Errorf("Unexpected complaint: %+v", got)
+	}
+
+	complaint, err := client.GetComplaint(context.Background(), "200201820200101080076610000")
+	if err != nil || complaint.PayerPhone != "13800138000" {
+		t.Errorf("GetComplaint: %+v, %v", complaint, err)
+	}
+}
+
+func TestListComplaints_Validation(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Invalid params should not reach the server")
+	})
+
+	tests := []struct {
+		name   string
+		params ListComplaintsRequest
+	}{
+		{"bad date", ListComplaintsRequest{BeginDate: "20200101", EndDate: "2020-01-02"}},
+		{"reversed", ListComplaintsRequest{BeginDate: "2020-01-02", EndDate: "2020-01-01"}},
+		{"too long", ListComplaintsRequest{BeginDate: "2020-01-01", EndDate: "2020-02-01"}},
+		{"large limit", ListComplaintsRequest{BeginDate: "2020-01-01", EndDate: "2020-01-02", Limit: 51}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.ListComplaints(context.Background(), &tt.params); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestComplaintNegotiationHistory(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != "/v3/merchant-service/complaints-v2/200201820200101080076610000/negotiation-historys?limit=10&offset=0" {
+			t.Errorf("Unexpected request: %s", r.URL.RequestURI())
+		}
+		w.Write([]byte(`{"data":[{"log_id":"300285320210322170000071077","operator":"投诉人","operate_time":"2015-05-20T13:29:35.120+08:00","operate_type":"USER_CREATE_COMPLAINT","operate_details":"已申请退款","image_list":["https://api.mch.weixin.qq.com/v3/merchant-service/images/xxxxx"]}],"limit":10,"offset":0,"total_count":1}`))
+	})
+
+	history, err := client.ComplaintNegotiationHistory(context.Background(), "200201820200101080076610000", 0, 0)
+	if err != nil {
+		t.Fatalf("ComplaintNegotiationHistory failed: %v", err)
+	}
+	if history.TotalCount != 1 || history.Data[0].OperateType != "USER_CREATE_COMPLAINT" || len(history.Data[0].ImageList) != 1 {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+}
+
+func TestRespondAndCompleteComplaint(t *testing.T) {
+	var bodies []map[string]interface{}
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		switch r.URL.Path {
+		case "/v3/merchant-service/complaints-v2/200201820200101080076610000/response",
+			"/v3/merchant-service/complaints-v2/200201820200101080076610000/complete":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected request: %s", r.URL.Path)
+		}
+	})
+
+	err := client.RespondComplaint(context.Background(), "200201820200101080076610000", &RespondComplaintRequest{
+		ResponseContent: "已与用户沟通解决",
+		ResponseImages:  []string{"xxxxx"},
+		JumpURL:         "https://www.xxx.com/notify",
+		JumpURLText:     "查看订单详情",
+	})
+	if err != nil {
+		t.Fatalf("RespondComplaint failed: %v", err)
+	}
+	partner := client.WithSubMerchant(SubMerchant{MchID: "1900000109"})
+	if err := partner.CompleteComplaint(context.Background(), "200201820200101080076610000"); err != nil {
+		t.Fatalf("CompleteComplaint failed: %v", err)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(bodies))
+	}
+	if bodies[0]["complainted_mchid"] != testMchID || bodies[0]["response_content"] != "已与用户沟通解决" || bodies[0]["jump_url_text"] != "查看订单详情" {
+		t.Errorf("Unexpected response body: %v", bodies[0])
+	}
+	if bodies[1]["complainted_mchid"] != "1900000109" {
+		t.Errorf("Unexpected complete body: %v", bodies[1])
+	}
+
+	if err := client.RespondComplaint(context.Background(), "200201820200101080076610000", &RespondComplaintRequest{
+		ResponseContent: "ok", JumpURL: "https://www.xxx.com",
+	}); err == nil {
+		t.Error("Expected error for jump_url without text")
+	}
+}
+
+func TestUploadComplaintImage(t *testing.T) {
+	image := []byte("\x89PNG\r\n\x1a\nimage")
+	sum := sha256.Sum256(image)
+	var client *Client
+	client, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v3/merchant-service/images/upload" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatalf("Invalid content type: %v", err)
+		}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+
+		part, err := reader.NextPart()
+		if err != nil || part.FormName() != "meta" {
+			t.Fatalf("Expected meta part first, got %v", err)
+		}
+		meta, _ := io.ReadAll(part)
+		// Only the meta JSON is covered by the signature.
+		if _, err := verifyTestAuthorization(r, meta, &client.privateKey.PublicKey); err != nil {
+			t.Errorf("Invalid authorization: %v", err)
+		}
+		var metaFields map[string]string
+		json.Unmarshal(meta, &metaFields)
+		if metaFields["filename"] != "evidence.png" || metaFields["sha256"] != hex.EncodeToString(sum[:]) {
+			t.Errorf("Unexpected meta: %s", meta)
+		}
+
+		part, err = reader.NextPart()
+		if err != nil || part.FormName() != "file" || part.FileName() != "evidence.png" {
+			t.Fatalf("Expected file part, got %v", err)
+		}
+		data, _ := io.ReadAll(part)
+		if !bytes.Equal(data, image) {
+			t.Errorf("Unexpected file content: %q", data)
+		}
+		w.Write([]byte(`{"media_id":"BB04A5DEEFEA18D4F2554C1EDD3B610B.bmp"}`))
+	})
+
+	mediaID, err := client.UploadComplaintImage(context.Background(), "evidence.png", bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("UploadComplaintImage failed: %v", err)
+	}
+	if mediaID != "BB04A5DEEFEA18D4F2554C1EDD3B610B.bmp" {
+		t.Errorf("Unexpected media_id: %s", mediaID)
+	}
+
+	if _, err := client.UploadComplaintImage(context.Background(), "evidence.gif", bytes.NewReader(image)); err == nil {
+		t.Error("Expected error for unsupported image type")
+	}
+	if _, err := client.UploadComplaintImage(context.Background(), "big.jpg", bytes.NewReader(make([]byte, maxComplaintImageSize+1))); err == nil {
+		t.Error("Expected error for oversized image")
+	}
+}
+
+func TestComplaintNotificationURL(t *testing.T) {
+	var configured string
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/merchant-service/complaint-notifications" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+		switch r.Method {
+		case "GET":
+			if configured == "" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"code":"RESOURCE_NOT_EXISTS","message":"商户未设置回调地址"}`))
+				return
+			}
+		case "POST":
+			if configured != "" {
+				t.Error("Expected PUT to replace an existing url")
+			}
+			fallthrough
+		case "PUT":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			configured = body["url"]
+		case "DELETE":
+			configured = ""
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(ComplaintNotificationConfig{Mchid: testMchID, URL: configured})
+	})
+	ctx := context.Background()
+
+	if _, err := client.ComplaintNotificationURL(ctx); !errors.Is(err, ErrResourceNotExist) {
+		t.Errorf("Expected ErrResourceNotExist, got %v", err)
+	}
+	if _, err := client.SetComplaintNotificationURL(ctx, "http://www.xxx.com/notify"); err == nil {
+		t.Error("Expected error for non-https url")
+	}
+	for _, notifyURL := range []string{"https://www.xxx.com/notify", "https://www.xxx.com/complaints"} {
+		config, err := client.SetComplaintNotificationURL(ctx, notifyURL)
+		if err != nil || config.URL != notifyURL {
+			t.Errorf("SetComplaintNotificationURL: %+v, %v", config, err)
+		}
+	}
+	if err := client.DeleteComplaintNotificationURL(ctx); err != nil || configured != "" {
+		t.Errorf("DeleteComplaintNotificationURL: %v", err)
+	}
+}
+
+func TestComplaintNotifyHandler(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+
+	var got *ComplaintNotification
+	handler := NewComplaintNotifyHandler(parser, func(ctx context.Context, notify *NotifyRequest, complaint *ComplaintNotification) error {
+		got = complaint
+		return nil
+	})
+
+	req := newTestNotifyRequest(t, key, serial, time.Now(), map[string]interface{}{
+		"complaint_id": "200201820200101080076610000",
+		"action_type":  "CREATE_COMPLAINT",
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got == nil || got.ComplaintID != "200201820200101080076610000" || got.ActionType != ComplaintActionCreate {
+		t.Errorf("Unexpected notification: %+v", got)
+	}
+
+	req = newTestNotifyRequest(t, key, serial, time.Now(), map[string]string{"complaint_id": "1"})
+	req.Header.Set("Wechatpay-Signature", strings.Repeat("A", 344))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK {
+		t.Error("Expected a forged notification to be rejected")
+	}
+}
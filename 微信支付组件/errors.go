@@ -0,0 +1,136 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Sentinels for errors.Is. They match any APIError with the same code.
+var (
+	ErrOrderPaid        = &APIError{Code: "ORDERPAID"}
+	ErrOrderNotExist    = &APIError{Code: "ORDERNOTEXIST"}
+	ErrOrderClosed      = &APIError{Code: "ORDER_CLOSED"}
+	ErrSystemError      = &APIError{Code: "SYSTEMERROR"}
+	ErrFrequencyLimited = &APIError{Code: "FREQUENCY_LIMITED"}
+	ErrNotEnough        = &APIError{Code: "NOT_ENOUGH"}
+	ErrInvalidRequest   = &APIError{Code: "INVALID_REQUEST"}
+	ErrParamError       = &APIError{Code: "PARAM_ERROR"}
+	ErrSignError        = &APIError{Code: "SIGN_ERROR"}
+	ErrResourceNotExist = &APIError{Code: "RESOURCE_NOT_EXISTS"}
+	ErrUserPaying       = &APIError{Code: "USERPAYING"}
+)
+
+// retryableCodes are the error codes WeChat Pay documents as transient:
+// the same request may succeed when sent again later.
+var retryableCodes = map[string]bool{
+	"SYSTEMERROR":       true,
+	"SYSTEM_ERROR":      true,
+	"FREQUENCY_LIMITED": true,
+	"BANKERROR":         true,
+	"RATELIMIT_EXCEED":  true,
+}
+
+// APIError is the {code, message, detail} body WeChat Pay returns with a
+// non-2xx status, together with the status and Request-ID needed when
+// contacting WeChat Pay support.
+type APIError struct {
+	StatusCode int             `json:"-"`
+	RequestID  string          `json:"-"`
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+	// Body is the raw response when it is not the documented error JSON.
+	Body string `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("wechat pay error: status=%d request_id=%s body=%s", e.StatusCode, e.RequestID, e.Body)
+	}
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("wechat pay error: %s", e.Code)
+	}
+	return fmt.Sprintf("wechat pay error: status=%d code=%s message=%s request_id=%s", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// Is lets errors.Is match an APIError against the sentinels by code.
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Retryable reports whether sending the same request again may succeed.
+func (e *APIError) Retryable() bool {
+	if retryableCodes[e.Code] {
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func IsAPIError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr)
+}
+
+// IsRetryable classifies an error returned by the client: retryable API
+// errors and network failures are worth retrying, invalid requests,
+// business rejections and cancelled contexts are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if contextDone(err) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// contextDone reports whether err comes from the caller's context being
+// cancelled or expiring. An http.Client.Timeout error also matches
+// context.DeadlineExceeded, but it is a network timeout worth retrying.
+func contextDone(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err == context.DeadlineExceeded {
+			return true
+		}
+	}
+	return false
+}
+
+// newAPIError builds an APIError from a non-2xx response, reading the rest
+// of its body.
+func newAPIError(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return parseAPIError(resp, body)
+}
+
+func parseAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("Request-ID"),
+	}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = ""
+		apiErr.Body = string(body)
+	}
+	return apiErr
+}
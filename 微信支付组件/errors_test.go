@@ -0,0 +1,90 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseAPIError(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{"Request-Id": []string{"08F78BB5AF0610D302A5BB7506A5FF2D"}},
+	}
+	apiErr := parseAPIError(resp, []byte(`{"code":"NOT_ENOUGH","message":"用户账户余额不足","detail":{"field":"amount"}}`))
+
+	if apiErr.StatusCode != 403 || apiErr.Code != "NOT_ENOUGH" || apiErr.Message != "用户账户余额不足" || apiErr.RequestID != "08F78BB5AF0610D302A5BB7506A5FF2D" {
+		t.Errorf("Unexpected error: %+v", apiErr)
+	}
+	if string(apiErr.Detail) != `{"field":"amount"}` {
+		t.Errorf("Unexpected detail: %s", apiErr.Detail)
+	}
+
+	wrapped := fmt.Errorf("create order: %w", apiErr)
+	if !errors.Is(wrapped, ErrNotEnough) || errors.Is(wrapped, ErrOrderPaid) {
+		t.Error("Sentinel matching failed")
+	}
+	if !IsAPIError(wrapped) {
+		t.Error("Expected IsAPIError")
+	}
+
+	raw := parseAPIError(&http.Response{StatusCode: 502, Header: http.Header{}}, []byte("<html>bad gateway</html>"))
+	if raw.Code != "" || raw.Body != "<html>bad gateway</html>" {
+		t.Errorf("Unexpected error for non-JSON body: %+v", raw)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"system error", &APIError{StatusCode: 500, Code: "SYSTEMERROR"}, true},
+		{"frequency limited", &APIError{StatusCode: 429, Code: "FREQUENCY_LIMITED"}, true},
+		{"bad gateway", &APIError{StatusCode: 502}, true},
+		{"order paid", &APIError{StatusCode: 400, Code: "ORDERPAID"}, false},
+		{"not enough", &APIError{StatusCode: 403, Code: "NOT_ENOUGH"}, false},
+		{"order not exist", &APIError{StatusCode: 404, Code: "ORDERNOTEXIST"}, false},
+		{"network", fmt.Errorf("query: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"truncated body", io.ErrUnexpectedEOF, true},
+		{"canceled", context.Canceled, false},
+		{"deadline exceeded", &url.Error{Op: "Get", URL: "https://api.mch.weixin.qq.com", Err: context.DeadlineExceeded}, false},
+		{"validation", errors.New("out_trade_no is required"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryable_ClientTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	_, err := (&http.Client{Timeout: 20 * time.Millisecond}).Get(ts.URL)
+	if err == nil || !IsRetryable(err) {
+		t.Errorf("Expected a retryable client timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	_, err = http.DefaultClient.Do(req)
+	if err == nil || IsRetryable(err) {
+		t.Errorf("Expected an expired context to be final, got %v", err)
+	}
+}
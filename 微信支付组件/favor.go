@@ -0,0 +1,417 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	favorPath             = "/v3/marketing/favor"
+	maxCouponListPageSize = 10
+)
+
+// Statuses of a coupon held by a user.
+const (
+	CouponStatusSended  = "SENDED"
+	CouponStatusUsed    = "USED"
+	CouponStatusExpired = "EXPIRED"
+)
+
+// CreateCouponStockRequest creates a stock of fixed-value coupons
+// (代金券). A coupon limited by GoodsTag only applies to orders created
+// with the same CreateOrderParams.GoodsTag; a used coupon shows up in the
+// order's Transaction.PromotionDetail with its StockID and CouponID.
+type CreateCouponStockRequest struct {
+	StockName string
+	Comment   string
+	// BelongMerchant defaults to the client's merchant ID.
+	BelongMerchant     string
+	AvailableBeginTime time.Time
+	AvailableEndTime   time.Time
+
+	// MaxCoupons is the number of coupons in the stock. The budget,
+	// max_amount, is MaxCoupons times CouponAmount.
+	MaxCoupons         int
+	MaxCouponsPerUser  int
+	MaxAmountByDay     int
+	NaturalPersonLimit bool
+	PreventAPIAbuse    bool
+
+	// CouponAmount is taken off orders of at least TransactionMinimum, in fen.
+	CouponAmount       int
+	TransactionMinimum int
+	GoodsTag           []string
+	// TradeType limits the coupon to e.g. MICROAPP, APPPAY, PPAY, CARD,
+	// FACEPAY or OTHER payments.
+	TradeType  []string
+	CombineUse bool
+	// AvailableMerchants defaults to BelongMerchant.
+	AvailableMerchants []string
+
+	// Description is the usage notice shown to the user.
+	Description     string
+	MerchantName    string
+	MerchantLogo    string
+	BackgroundColor string
+
+	NoCash       bool
+	OutRequestNo string
+}
+
+type CouponStock struct {
+	StockID    string `json:"stock_id"`
+	CreateTime string `json:"create_time"`
+}
+
+type CouponStockStart struct {
+	StockID   string `json:"stock_id"`
+	StartTime string `json:"start_time"`
+}
+
+type SendCouponRequest struct {
+	Openid  string
+	StockID string
+	// OutRequestNo makes the send idempotent: retrying with the same value
+	// returns the coupon already sent.
+	OutRequestNo string
+	Appid        string
+	// StockCreatorMchID defaults to the client's merchant ID.
+	StockCreatorMchID string
+}
+
+type ListUserCouponsRequest struct {
+	Openid  string
+	Appid   string
+	StockID string
+	// Status is one of the CouponStatus constants; empty lists all.
+	Status       string
+	CreatorMchID string
+	SenderMchID  string
+	Offset       int
+	// Limit defaults to 10, the maximum.
+	Limit int
+}
+
+type CouponList struct {
+	Data       []Coupon `json:"data"`
+	TotalCount int      `json:"total_count"`
+	Limit      int      `json:"limit"`
+	Offset     int      `json:"offset"`
+}
+
+// Coupon is a coupon held by a user, as returned by the coupon queries and
+// the COUPON.USE notification.
+type Coupon struct {
+	StockCreatorMchid       string                    `json:"stock_creator_mchid"`
+	StockID                 string                    `json:"stock_id"`
+	CouponID                string                    `json:"coupon_id"`
+	CouponName              string                    `json:"coupon_name"`
+	Status                  string                    `json:"status"`
+	Description             string                    `json:"description"`
+	CreateTime              string                    `json:"create_time"`
+	CouponType              string                    `json:"coupon_type"`
+	NoCash                  bool                      `json:"no_cash"`
+	AvailableBeginTime      string                    `json:"available_begin_time"`
+	AvailableEndTime        string                    `json:"available_end_time"`
+	Singleitem              bool                      `json:"singleitem"`
+	NormalCouponInformation *NormalCouponInformation  `json:"normal_coupon_information,omitempty"`
+	ConsumeInformation      *CouponConsumeInformation `json:"consume_information,omitempty"`
+}
+
+type NormalCouponInformation struct {
+	CouponAmount       int `json:"coupon_amount"`
+	TransactionMinimum int `json:"transaction_minimum"`
+}
+
+type CouponConsumeInformation struct {
+	ConsumeTime   string              `json:"consume_time"`
+	ConsumeMchid  string              `json:"consume_mchid"`
+	TransactionID string              `json:"transaction_id"`
+	GoodsDetail   []CouponGoodsDetail `json:"goods_detail,omitempty"`
+}
+
+type CouponGoodsDetail struct {
+	GoodsID        string `json:"goods_id"`
+	Quantity       int    `json:"quantity"`
+	Price          int    `json:"price"`
+	DiscountAmount int    `json:"discount_amount"`
+}
+
+type CouponCallback struct {
+	NotifyURL  string `json:"notify_url"`
+	UpdateTime string `json:"update_time"`
+}
+
+// CreateCouponStock creates a coupon stock. It cannot send coupons until
+// ActivateCouponStock is called.
+func (c *Client) CreateCouponStock(ctx context.Context, params *CreateCouponStockRequest) (*CouponStock, error) {
+	if err := validateCouponStock(params); err != nil {
+		return nil, err
+	}
+	belongMerchant := params.BelongMerchant
+	if belongMerchant == "" {
+		belongMerchant = c.mchID
+	}
+	availableMerchants := params.AvailableMerchants
+	if len(availableMerchants) == 0 {
+		availableMerchants = []string{belongMerchant}
+	}
+
+	stockUseRule := map[string]interface{}{
+		"max_coupons":          params.MaxCoupons,
+		"max_amount":           params.MaxCoupons * params.CouponAmount,
+		"max_coupons_per_user": params.MaxCouponsPerUser,
+		"natural_person_limit": params.NaturalPersonLimit,
+		"prevent_api_abuse":    params.PreventAPIAbuse,
+	}
+	if params.MaxAmountByDay > 0 {
+		stockUseRule["max_amount_by_day"] = params.MaxAmountByDay
+	}
+	couponUseRule := map[string]interface{}{
+		"fixed_normal_coupon": map[string]interface{}{
+			"coupon_amount":       params.CouponAmount,
+			"transaction_minimum": params.TransactionMinimum,
+		},
+		"combine_use":         params.CombineUse,
+		"available_merchants": availableMerchants,
+	}
+	if len(params.GoodsTag) > 0 {
+		couponUseRule["goods_tag"] = params.GoodsTag
+	}
+	if len(params.TradeType) > 0 {
+		couponUseRule["trade_type"] = params.TradeType
+	}
+	patternInfo := map[string]interface{}{"description": params.Description}
+	if params.MerchantName != "" {
+		patternInfo["merchant_name"] = params.MerchantName
+	}
+	if params.MerchantLogo != "" {
+		patternInfo["merchant_logo"] = params.MerchantLogo
+	}
+	if params.BackgroundColor != "" {
+		patternInfo["background_color"] = params.BackgroundColor
+	}
+
+	body := map[string]interface{}{
+		"stock_name":           params.StockName,
+		"belong_merchant":      belongMerchant,
+		"available_begin_time": params.AvailableBeginTime.In(cstLocation).Format(time.RFC3339),
+		"available_end_time":   params.AvailableEndTime.In(cstLocation).Format(time.RFC3339),
+		"stock_use_rule":       stockUseRule,
+		"coupon_use_rule":      couponUseRule,
+		"pattern_info":         patternInfo,
+		"no_cash":              params.NoCash,
+		"stock_type":           "NORMAL",
+		"out_request_no":       params.OutRequestNo,
+	}
+	if params.Comment != "" {
+		body["comment"] = params.Comment
+	}
+
+	req, err := c.newJSONRequest("POST", favorPath+"/coupon-stocks", body)
+	if err != nil {
+		return nil, err
+	}
+	var stock CouponStock
+	if err := c.doJSON(ctx, req, &stock); err != nil {
+		return nil, err
+	}
+	return &stock, nil
+}
+
+func validateCouponStock(params *CreateCouponStockRequest) error {
+	if params.StockName == "" || len([]rune(params.StockName)) > 20 {
+		return errors.New("stock_name must be 1 to 20 characters")
+	}
+	if len([]rune(params.Comment)) > 20 {
+		return errors.New("comment must be at most 20 characters")
+	}
+	if params.OutRequestNo == "" {
+		return errors.New("out_request_no is required")
+	}
+	if params.Description == "" {
+		return errors.New("description is required")
+	}
+	if params.AvailableBeginTime.IsZero() || !params.AvailableEndTime.After(params.AvailableBeginTime) {
+		return errors.New("available_end_time must be after available_begin_time")
+	}
+	if params.CouponAmount <= 0 {
+		return errors.New("coupon_amount must be positive")
+	}
+	if params.TransactionMinimum < params.CouponAmount {
+		return errors.New("transaction_minimum must be at least coupon_amount")
+	}
+	if params.MaxCoupons <= 0 || params.MaxCouponsPerUser <= 0 {
+		return errors.New("max_coupons and max_coupons_per_user must be positive")
+	}
+	if params.MaxCouponsPerUser > params.MaxCoupons {
+		return errors.New("max_coupons_per_user must be at most max_coupons")
+	}
+	if params.MaxAmountByDay < 0 || params.MaxAmountByDay > params.MaxCoupons*params.CouponAmount {
+		return errors.New("max_amount_by_day must be 0 to the stock budget")
+	}
+	return nil
+}
+
+// ActivateCouponStock starts a created stock so coupons can be sent.
+func (c *Client) ActivateCouponStock(ctx context.Context, stockID string) (*CouponStockStart, error) {
+	if stockID == "" {
+		return nil, errors.New("stock_id is required")
+	}
+	req, err := c.newJSONRequest("POST", favorPath+"/stocks/"+url.PathEscape(stockID)+"/start", map[string]string{
+		"stock_creator_mchid": c.mchID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var start CouponStockStart
+	if err := c.doJSON(ctx, req, &start); err != nil {
+		return nil, err
+	}
+	return &start, nil
+}
+
+// SendCoupon sends one coupon of an active stock to a user and returns its
+// coupon_id.
+func (c *Client) SendCoupon(ctx context.Context, params *SendCouponRequest) (string, error) {
+	if params.Openid == "" || params.StockID == "" || params.Appid == "" || params.OutRequestNo == "" {
+		return "", errors.New("openid, stock_id, appid and out_request_no are required")
+	}
+	creator := params.StockCreatorMchID
+	if creator == "" {
+		creator = c.mchID
+	}
+
+	req, err := c.newJSONRequest("POST", favorPath+"/users/"+url.PathEscape(params.Openid)+"/coupons", map[string]string{
+		"stock_id":            params.StockID,
+		"out_request_no":      params.OutRequestNo,
+		"appid":               params.Appid,
+		"stock_creator_mchid": creator,
+	})
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		CouponID string `json:"coupon_id"`
+	}
+	if err := c.doJSON(ctx, req, &result); err != nil {
+		return "", err
+	}
+	return result.CouponID, nil
+}
+
+// ListUserCoupons lists the coupons a user holds.
+func (c *Client) ListUserCoupons(ctx context.Context, params *ListUserCouponsRequest) (*CouponList, error) {
+	if params.Openid == "" || params.Appid == "" {
+		return nil, errors.New("openid and appid are required")
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = maxCouponListPageSize
+	}
+	if limit > maxCouponListPageSize {
+		return nil, fmt.Errorf("limit must be at most %d", maxCouponListPageSize)
+	}
+
+	query := url.Values{}
+	query.Set("appid", params.Appid)
+	query.Set("offset", strconv.Itoa(params.Offset))
+	query.Set("limit", strconv.Itoa(limit))
+	if params.StockID != "" {
+		query.Set("stock_id", params.StockID)
+	}
+	if params.Status != "" {
+		query.Set("status", params.Status)
+	}
+	if params.CreatorMchID != "" {
+		query.Set("creator_mchid", params.CreatorMchID)
+	}
+	if params.SenderMchID != "" {
+		query.Set("sender_mchid", params.SenderMchID)
+	}
+
+	req, err := c.newRequest("GET", favorPath+"/users/"+url.PathEscape(params.Openid)+"/coupons?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list CouponList
+	if err := c.doJSON(ctx, req, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (c *Client) GetUserCoupon(ctx context.Context, openid, appid, couponID string) (*Coupon, error) {
+	if openid == "" || appid == "" || couponID == "" {
+		return nil, errors.New("openid, appid and coupon_id are required")
+	}
+	path := fmt.Sprintf("%s/users/%s/coupons/%s?appid=%s", favorPath, url.PathEscape(openid), url.PathEscape(couponID), url.QueryEscape(appid))
+	req, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var coupon Coupon
+	if err := c.doJSON(ctx, req, &coupon); err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// DownloadCouponUseFlow downloads the CSV of the coupons of a stock that
+// were used. WeChat Pay generates it the day after use.
+func (c *Client) DownloadCouponUseFlow(ctx context.Context, stockID string) ([]byte, error) {
+	if stockID == "" {
+		return nil, errors.New("stock_id is required")
+	}
+	req, err := c.newRequest("GET", favorPath+"/stocks/"+url.PathEscape(stockID)+"/use-flow", nil)
+	if err != nil {
+		return nil, err
+	}
+	var flow struct {
+		URL       string `json:"url"`
+		HashValue string `json:"hash_value"`
+		HashType  string `json:"hash_type"`
+	}
+	if err := c.doJSON(ctx, req, &flow); err != nil {
+		return nil, err
+	}
+	return c.download(ctx, flow.URL, flow.HashType, flow.HashValue)
+}
+
+// SetCouponCallbackURL sets the URL that receives COUPON.USE notifications
+// for the merchant's stocks.
+func (c *Client) SetCouponCallbackURL(ctx context.Context, notifyURL string) (*CouponCallback, error) {
+	if notifyURL == "" {
+		return nil, errors.New("notify_url is required")
+	}
+	req, err := c.newJSONRequest("POST", favorPath+"/callbacks", map[string]string{
+		"mchid":      c.mchID,
+		"notify_url": notifyURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var callback CouponCallback
+	if err := c.doJSON(ctx, req, &callback); err != nil {
+		return nil, err
+	}
+	return &callback, nil
+}
+
+type CouponHandlerFunc func(ctx context.Context, notify *NotifyRequest, coupon *Coupon) error
+
+// NewCouponNotifyHandler returns the http.Handler to mount at the URL passed
+// to SetCouponCallbackURL.
+func NewCouponNotifyHandler(parser *NotifyParser, fn CouponHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var coupon Coupon
+		serveNotify(w, r, parser, &coupon, func(notify *NotifyRequest) error {
+			return fn(r.Context(), notify, &coupon)
+		})
+	})
+}
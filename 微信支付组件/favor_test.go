@@ -0,0 +1,219 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCouponStockRequest() *CreateCouponStockRequest {
+	begin := time.Date(2015, 5, 20, 13, 29, 35, 0, cstLocation)
+	return &CreateCouponStockRequest{
+		StockName:          "微信支付代金券",
+		Comment:            "零售批次",
+		AvailableBeginTime: begin,
+		AvailableEndTime:   begin.Add(30 * 24 * time.Hour),
+		MaxCoupons:         100,
+		MaxCouponsPerUser:  5,
+		CouponAmount:       100,
+		TransactionMinimum: 1000,
+		GoodsTag:           []string{"WXG"},
+		Description:        "微信支付营销代金券",
+		OutRequestNo:       "89560002019101000121",
+	}
+}
+
+func TestCreateAndActivateCouponStock(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/v3/marketing/favor/coupon-stocks":
+			stockUseRule := body["stock_use_rule"].(map[string]interface{})
+			couponUseRule := body["coupon_use_rule"].(map[string]interface{})
+			if body["belong_merchant"] != testMchID || body["stock_type"] != "NORMAL" || body["available_begin_time"] != "2015-05-20T13:29:35+08:00" {
+				t.Errorf("Unexpected body: %v", body)
+			}
+			if stockUseRule["max_amount"] != float64(10000) || stockUseRule["max_coupons_per_user"] != float64(5) {
+				t.Errorf("Unexpected stock_use_rule: %v", stockUseRule)
+			}
+			if tags := couponUseRule["goods_tag"].([]interface{}); len(tags) != 1 || tags[0] != "WXG" {
+				t.Errorf("Unexpected goods_tag: %v", couponUseRule["goods_tag"])
+			}
+			if merchants := couponUseRule["available_merchants"].([]interface{}); len(merchants) != 1 || merchants[0] != testMchID {
+				t.Errorf("Unexpected available_merchants: %v", couponUseRule["available_merchants"])
+			}
+			w.Write([]byte(`{"stock_id":"9856000","create_time":"2015-05-20T13:29:35.120+08:00"}`))
+		case "/v3/marketing/favor/stocks/9856000/start":
+			if body["stock_creator_mchid"] != testMchID {
+				t.Errorf("Unexpected body: %v", body)
+			}
+			w.Write([]byte(`{"stock_id":"9856000","start_time":"2015-05-20T13:29:35.120+08:00"}`))
+		default:
+			t.Errorf("Unexpected request: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	stock, err := client.CreateCouponStock(context.Background(), newTestCouponStockRequest())
+	if err != nil {
+		t.Fatalf("CreateCouponStock failed: %v", err)
+	}
+	if stock.StockID != "9856000" {
+		t.Errorf("Unexpected stock: %+v", stock)
+	}
+	start, err := client.ActivateCouponStock(context.Background(), stock.StockID)
+	if err != nil || start.StartTime == "" {
+		t.Errorf("ActivateCouponStock: %+v, %v", start, err)
+	}
+}
+
+func TestValidateCouponStock(t *testing.T) {
+	if err := validateCouponStock(newTestCouponStockRequest()); err != nil {
+		t.Fatalf("Expected valid stock, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*CreateCouponStockRequest)
+		errMsg string
+	}{
+		{"long name", func(p *CreateCouponStockRequest) { p.StockName = strings.Repeat("券", 21) }, "stock_name"},
+		{"missing out_request_no", func(p *CreateCouponStockRequest) { p.OutRequestNo = "" }, "out_request_no"},
+		{"reversed times", func(p *CreateCouponStockRequest) { p.AvailableEndTime = p.AvailableBeginTime }, "available_end_time"},
+		{"minimum below amount", func(p *CreateCouponStockRequest) { p.TransactionMinimum = 99 }, "transaction_minimum"},
+		{"per user above total", func(p *CreateCouponStockRequest) { p.MaxCouponsPerUser = 101 }, "max_coupons_per_user"},
+		{"daily above budget", func(p *CreateCouponStockRequest) { p.MaxAmountByDay = 10001 }, "max_amount_by_day"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := newTestCouponStockRequest()
+			tt.modify(params)
+			err := validateCouponStock(params)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+const testCoupon = `{"stock_creator_mchid":"1230000109","stock_id":"9856000","coupon_id":"9856000","coupon_name":"微信支付代金券","status":"USED","description":"微信支付营销代金券","create_time":"2015-05-20T13:29:35.120+08:00","coupon_type":"NORMAL","no_cash":false,"available_begin_time":"2015-05-20T13:29:35.120+08:00","available_end_time":"2015-06-19T13:29:35.120+08:00","singleitem":false,"normal_coupon_information":{"coupon_amount":100,"transaction_minimum":1000},"consume_information":{"consume_time":"2015-05-21T13:29:35.120+08:00","consume_mchid":"1230000109","transaction_id":"4200000000000000000000000000"}}`
+
+func TestSendAndListCoupons(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "POST /v3/marketing/favor/users/oUpF8uMuAJO_M2pxb1Q9zNjWeS6o/coupons":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["stock_id"] != "9856000" || body["stock_creator_mchid"] != testMchID || body["out_request_no"] != "89560002019101000121" {
+				t.Errorf("Unexpected body: %v", body)
+			}
+			w.Write([]byte(`{"coupon_id":"9856000"}`))
+		case "GET /v3/marketing/favor/users/oUpF8uMuAJO_M2pxb1Q9zNjWeS6o/coupons?appid=wx233544546545989&limit=10&offset=0&status=USED&stock_id=9856000":
+			w.Write([]byte(`{"data":[` + testCoupon + `],"total_count":1,"limit":10,"offset":0}`))
+		case "GET /v3/marketing/favor/users/oUpF8uMuAJO_M2pxb1Q9zNjWeS6o/coupons/9856000?appid=wx233544546545989":
+			w.Write([]byte(testCoupon))
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	ctx := context.Background()
+
+	couponID, err := client.SendCoupon(ctx, &SendCouponRequest{
+		Openid:       "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+		StockID:      "9856000",
+		OutRequestNo: "89560002019101000121",
+		Appid:        "wx233544546545989",
+	})
+	if err != nil || couponID != "9856000" {
+		t.Fatalf("SendCoupon: %s, %v", couponID, err)
+	}
+
+	list, err := client.ListUserCoupons(ctx, &ListUserCouponsRequest{
+		Openid: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o", Appid: "wx233544546545989", StockID: "9856000", Status: CouponStatusUsed,
+	})
+	if err != nil {
+		t.Fatalf("ListUserCoupons failed: %v", err)
+	}
+	if list.TotalCount != 1 || list.Data[0].ConsumeInformation.TransactionID != "4200000000000000000000000000" || list.Data[0].NormalCouponInformation.CouponAmount != 100 {
+		t.Errorf("Unexpected list: %+v", list)
+	}
+	if _, err := client.ListUserCoupons(ctx, &ListUserCouponsRequest{Openid: "o", Appid: "wx", Limit: 11}); err == nil {
+		t.Error("Expected error for large limit")
+	}
+
+	coupon, err := client.GetUserCoupon(ctx, "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o", "wx233544546545989", "9856000")
+	if err != nil || coupon.Status != CouponStatusUsed {
+		t.Errorf("GetUserCoupon: %+v, %v", coupon, err)
+	}
+}
+
+func TestDownloadCouponUseFlow(t *testing.T) {
+	flow := []byte("批次id,代金券id,优惠类型\n`9856000,`9856000,全场代金券\n")
+	sum := sha1.Sum(flow)
+	var client *Client
+	client, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RequestURI() {
+		case "/v3/marketing/favor/stocks/9856000/use-flow":
+			w.Write([]byte(`{"url":"` + client.baseURL + `/v3/billdownload/file?token=xxx","hash_value":"` + hex.EncodeToString(sum[:]) + `","hash_type":"SHA1"}`))
+		case "/v3/billdownload/file?token=xxx":
+			w.Write(flow)
+		default:
+			t.Errorf("Unexpected request: %s", r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	data, err := client.DownloadCouponUseFlow(context.Background(), "9856000")
+	if err != nil {
+		t.Fatalf("DownloadCouponUseFlow failed: %v", err)
+	}
+	if string(data) != string(flow) {
+		t.Errorf("Unexpected use flow: %q", data)
+	}
+}
+
+func TestSetCouponCallbackURL(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v3/marketing/favor/callbacks" || body["mchid"] != testMchID {
+			t.Errorf("Unexpected request: %s %v", r.URL.Path, body)
+		}
+		w.Write([]byte(`{"update_time":"2015-05-20T13:29:35.120+08:00","notify_url":"` + body["notify_url"] + `"}`))
+	})
+
+	callback, err := client.SetCouponCallbackURL(context.Background(), "https://pay.weixin.qq.com/coupon/notify")
+	if err != nil || callback.NotifyURL != "https://pay.weixin.qq.com/coupon/notify" {
+		t.Errorf("SetCouponCallbackURL: %+v, %v", callback, err)
+	}
+}
+
+func TestCouponNotifyHandler(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+
+	var got *Coupon
+	handler := NewCouponNotifyHandler(parser, func(ctx context.Context, notify *NotifyRequest, coupon *Coupon) error {
+		got = coupon
+		return nil
+	})
+
+	var resource map[string]interface{}
+	json.Unmarshal([]byte(testCoupon), &resource)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newTestNotifyRequest(t, key, serial, time.Now(), resource))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got == nil || got.CouponID != "9856000" || got.ConsumeInformation == nil || got.ConsumeInformation.ConsumeMchid != testMchID {
+		t.Errorf("Unexpected notification: %+v", got)
+	}
+}
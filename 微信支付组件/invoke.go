@@ -0,0 +1,75 @@
+package wechatpay
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// JSAPIInvokeParams is handed to wx.requestPayment in a Mini Program or to
+// WeixinJSBridge getBrandWCPayRequest in an official account page.
+type JSAPIInvokeParams struct {
+	AppID     string `json:"appId"`
+	TimeStamp string `json:"timeStamp"`
+	NonceStr  string `json:"nonceStr"`
+	Package   string `json:"package"`
+	SignType  string `json:"signType"`
+	PaySign   string `json:"paySign"`
+}
+
+func (c *Client) BuildJSAPIInvokeParams(appid, prepayID string) (*JSAPIInvokeParams, error) {
+	if appid == "" || prepayID == "" {
+		return nil, errors.New("appid and prepay_id are required")
+	}
+
+	params := &JSAPIInvokeParams{
+		AppID:     appid,
+		TimeStamp: strconv.FormatInt(time.Now().Unix(), 10),
+		NonceStr:  generateNonce(),
+		Package:   "prepay_id=" + prepayID,
+		SignType:  "RSA",
+	}
+
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n", params.AppID, params.TimeStamp, params.NonceStr, params.Package)
+	signature, err := c.sign([]byte(message))
+	if err != nil {
+		return nil, err
+	}
+	params.PaySign = signature
+	return params, nil
+}
+
+// AppInvokeParams is handed to the WeChat OpenSDK PayReq in a mobile app.
+type AppInvokeParams struct {
+	AppID     string `json:"appid"`
+	PartnerID string `json:"partnerid"`
+	PrepayID  string `json:"prepayid"`
+	Package   string `json:"package"`
+	NonceStr  string `json:"noncestr"`
+	TimeStamp string `json:"timestamp"`
+	Sign      string `json:"sign"`
+}
+
+func (c *Client) BuildAppInvokeParams(appid, prepayID string) (*AppInvokeParams, error) {
+	if appid == "" || prepayID == "" {
+		return nil, errors.New("appid and prepay_id are required")
+	}
+
+	params := &AppInvokeParams{
+		AppID:     appid,
+		PartnerID: c.mchID,
+		PrepayID:  prepayID,
+		Package:   "Sign=WXPay",
+		NonceStr:  generateNonce(),
+		TimeStamp: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n", params.AppID, params.TimeStamp, params.NonceStr, params.PrepayID)
+	signature, err := c.sign([]byte(message))
+	if err != nil {
+		return nil, err
+	}
+	params.Sign = signature
+	return params, nil
+}
@@ -0,0 +1,55 @@
+package wechatpay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestBuildJSAPIInvokeParams(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	params, err := client.BuildJSAPIInvokeParams("wx8888888888888888", "wx201410272009395522657a690389285100")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params.Package != "prepay_id=wx201410272009395522657a690389285100" || params.SignType != "RSA" {
+		t.Fatalf("unexpected params: %+v", params)
+	}
+
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n", params.AppID, params.TimeStamp, params.NonceStr, params.Package)
+	if err := verifySignature(&client.privateKey.PublicKey, []byte(message), params.PaySign); err != nil {
+		t.Errorf("paySign does not verify: %v", err)
+	}
+
+	encoded, _ := json.Marshal(params)
+	var fields map[string]string
+	json.Unmarshal(encoded, &fields)
+	for _, key := range []string{"appId", "timeStamp", "nonceStr", "package", "signType", "paySign"} {
+		if fields[key] == "" {
+			t.Errorf("missing %s in wx.requestPayment params", key)
+		}
+	}
+
+	if _, err := client.BuildJSAPIInvokeParams("wx8888888888888888", ""); err == nil {
+		t.Error("expected error for empty prepay_id")
+	}
+}
+
+func TestBuildAppInvokeParams(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	params, err := client.BuildAppInvokeParams("wxd678efh567hg6787", "WX1217752501201407033233368018")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params.Package != "Sign=WXPay" || params.PartnerID != testMchID || params.PrepayID != "WX1217752501201407033233368018" {
+		t.Fatalf("unexpected params: %+v", params)
+	}
+
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n", params.AppID, params.TimeStamp, params.NonceStr, params.PrepayID)
+	if err := verifySignature(&client.privateKey.PublicKey, []byte(message), params.Sign); err != nil {
+		t.Errorf("sign does not verify: %v", err)
+	}
+}
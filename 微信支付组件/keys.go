@@ -0,0 +1,82 @@
+package wechatpay
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// LoadPrivateKey parses the merchant key (apiclient_key.pem). Both PKCS#8
+// ("PRIVATE KEY") and PKCS#1 ("RSA PRIVATE KEY") encodings are accepted.
+func LoadPrivateKey(pemData []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+func LoadPrivateKeyFile(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPrivateKey(data)
+}
+
+// LoadCertificate parses the merchant certificate (apiclient_cert.pem).
+func LoadCertificate(pemData []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no CERTIFICATE PEM block found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func LoadCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCertificate(data)
+}
+
+// LoadPKCS12 extracts the merchant key and certificate from
+// apiclient_cert.p12. WeChat Pay protects the file with the merchant ID as
+// its password.
+func LoadPKCS12(data []byte, mchID string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, cert, err := pkcs12.Decode(data, mchID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode pkcs12: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, cert, nil
+}
+
+func LoadPKCS12File(path, mchID string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return LoadPKCS12(data, mchID)
+}
@@ -0,0 +1,93 @@
+package wechatpay
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadPrivateKey(t *testing.T) {
+	key, _ := generateTestKeyPair()
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal pkcs8: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		pem  []byte
+	}{
+		{"pkcs1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})},
+		{"pkcs8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded, err := LoadPrivateKey(tt.pem)
+			if err != nil {
+				t.Fatalf("LoadPrivateKey failed: %v", err)
+			}
+			if !loaded.Equal(key) {
+				t.Error("Loaded key does not match")
+			}
+		})
+	}
+
+	if _, err := LoadPrivateKey([]byte("not a pem")); err == nil {
+		t.Error("Expected error for invalid PEM")
+	}
+}
+
+func TestLoadCertificateFile(t *testing.T) {
+	key, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, key, 0x3775B6A45ACD5888, time.Now().Add(time.Hour))
+	path := filepath.Join(t.TempDir(), "apiclient_cert.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCertificateFile(path)
+	if err != nil {
+		t.Fatalf("LoadCertificateFile failed: %v", err)
+	}
+	if CertificateSerial(loaded) != "3775B6A45ACD5888" {
+		t.Errorf("Unexpected serial: %s", CertificateSerial(loaded))
+	}
+
+	client, err := NewClient(&Config{MchID: testMchID, PrivateKey: key, Certificate: loaded, MchAPIv3Key: testAPIv3Key})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if client.serialNo != "3775B6A45ACD5888" {
+		t.Errorf("Expected serial derived from certificate, got %s", client.serialNo)
+	}
+
+	other, _ := generateTestKeyPair()
+	if _, err := NewClient(&Config{MchID: testMchID, PrivateKey: other, Certificate: loaded, MchAPIv3Key: testAPIv3Key}); err == nil {
+		t.Error("Expected error for key not matching certificate")
+	}
+	if _, err := NewClient(&Config{MchID: testMchID, SerialNo: testSerialNo, PrivateKey: key, Certificate: loaded, MchAPIv3Key: testAPIv3Key}); err == nil {
+		t.Error("Expected error for serial not matching certificate")
+	}
+}
+
+func TestLoadPKCS12File(t *testing.T) {
+	// testdata/apiclient_cert.p12 is a self-signed certificate exported the
+	// way the merchant platform does, with the mchid as password.
+	key, cert, err := LoadPKCS12File(filepath.Join("testdata", "apiclient_cert.p12"), testMchID)
+	if err != nil {
+		t.Fatalf("LoadPKCS12File failed: %v", err)
+	}
+	if CertificateSerial(cert) != testSerialNo {
+		t.Errorf("Expected serial %s, got %s", testSerialNo, CertificateSerial(cert))
+	}
+	if _, err := NewClient(&Config{MchID: testMchID, PrivateKey: key, Certificate: cert, MchAPIv3Key: testAPIv3Key}); err != nil {
+		t.Errorf("NewClient failed: %v", err)
+	}
+
+	if _, _, err := LoadPKCS12File(filepath.Join("testdata", "apiclient_cert.p12"), "wrong"); err == nil {
+		t.Error("Expected error for wrong password")
+	}
+}
@@ -0,0 +1,211 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const v2TimeLayout = "20060102150405"
+
+// MicropayParams charges the barcode a cashier scanned from the payer's
+// WeChat (付款码支付).
+type MicropayParams struct {
+	// AuthCode is the 18 digit code read from the payer's barcode.
+	AuthCode    string
+	Description string
+	OutTradeNo  string
+	Amount      Amount
+	// SceneInfo.PayerClientIP is the terminal's IP and is required.
+	// DeviceID and StoreInfo identify the till.
+	SceneInfo  *SceneInfo
+	TimeExpire time.Time
+	Attach     string
+	GoodsTag   string
+	Detail     *OrderDetail
+}
+
+// Micropay charges a payment barcode. A nil error means the money has been
+// taken. ErrUserPaying (the payer is entering the password), ErrSystemError
+// and BANKERROR leave the outcome unknown: poll QueryOrder and Reverse the
+// order if it never completes.
+func (c *V2Client) Micropay(ctx context.Context, params *MicropayParams) (*Transaction, error) {
+	if params.AuthCode == "" || params.OutTradeNo == "" || params.Description == "" {
+		return nil, errors.New("auth_code, out_trade_no and description are required")
+	}
+	if params.Amount.Total <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	if params.SceneInfo == nil {
+		return nil, errors.New("scene_info.payer_client_ip is required")
+	}
+	if err := validateOrderOptions(&CreateOrderParams{
+		Description: params.Description,
+		SceneInfo:   params.SceneInfo,
+		TimeExpire:  params.TimeExpire,
+		Attach:      params.Attach,
+		GoodsTag:    params.GoodsTag,
+		Detail:      params.Detail,
+	}); err != nil {
+		return nil, err
+	}
+
+	req := map[string]string{
+		"auth_code":        params.AuthCode,
+		"body":             params.Description,
+		"out_trade_no":     params.OutTradeNo,
+		"total_fee":        strconv.Itoa(params.Amount.Total),
+		"fee_type":         params.Amount.Currency,
+		"spbill_create_ip": params.SceneInfo.PayerClientIP,
+		"device_info":      params.SceneInfo.DeviceID,
+		"attach":           params.Attach,
+		"goods_tag":        params.GoodsTag,
+	}
+	if !params.TimeExpire.IsZero() {
+		req["time_expire"] = params.TimeExpire.In(cstLocation).Format(v2TimeLayout)
+	}
+	if store := params.SceneInfo.StoreInfo; store != nil {
+		scene, err := json.Marshal(map[string]interface{}{
+			"store_info": map[string]string{
+				"id":        store.ID,
+				"name":      store.Name,
+				"area_code": store.AreaCode,
+				"address":   store.Address,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		req["scene_info"] = string(scene)
+	}
+	if params.Detail != nil {
+		detail, err := json.Marshal(buildV2OrderDetail(params.Detail))
+		if err != nil {
+			return nil, err
+		}
+		req["detail"] = string(detail)
+	}
+
+	resp, err := c.post(ctx, "/pay/micropay", req)
+	if err != nil {
+		return nil, err
+	}
+	tx := transactionFromV2(resp)
+	tx.TradeState = TradeStateSuccess
+	return tx, nil
+}
+
+// buildV2OrderDetail is buildOrderDetail with the v2 field names.
+func buildV2OrderDetail(detail *OrderDetail) map[string]interface{} {
+	result := map[string]interface{}{}
+	if detail.CostPrice > 0 {
+		result["cost_price"] = detail.CostPrice
+	}
+	if detail.InvoiceID != "" {
+		result["receipt_id"] = detail.InvoiceID
+	}
+	if len(detail.GoodsDetail) > 0 {
+		goodsDetail := make([]map[string]interface{}, 0, len(detail.GoodsDetail))
+		for _, goods := range detail.GoodsDetail {
+			line := map[string]interface{}{
+				"goods_id": goods.MerchantGoodsID,
+				"quantity": goods.Quantity,
+				"price":    goods.UnitPrice,
+			}
+			if goods.WechatpayGoodsID != "" {
+				line["wxpay_goods_id"] = goods.WechatpayGoodsID
+			}
+			if goods.GoodsName != "" {
+				line["goods_name"] = goods.GoodsName
+			}
+			goodsDetail = append(goodsDetail, line)
+		}
+		result["goods_detail"] = goodsDetail
+	}
+	return result
+}
+
+// QueryOrder looks up an order through the v2 API. The result uses the
+// same Transaction model as the v3 Client.
+func (c *V2Client) QueryOrder(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	if outTradeNo == "" {
+		return nil, errors.New("out_trade_no is required")
+	}
+	return c.queryOrder(ctx, map[string]string{"out_trade_no": outTradeNo})
+}
+
+func (c *V2Client) QueryOrderByTransactionID(ctx context.Context, transactionID string) (*Transaction, error) {
+	if transactionID == "" {
+		return nil, errors.New("transaction_id is required")
+	}
+	return c.queryOrder(ctx, map[string]string{"transaction_id": transactionID})
+}
+
+func (c *V2Client) queryOrder(ctx context.Context, params map[string]string) (*Transaction, error) {
+	resp, err := c.post(ctx, "/pay/orderquery", params)
+	if err != nil {
+		return nil, err
+	}
+	return transactionFromV2(resp), nil
+}
+
+// Reverse (撤销) cancels a barcode payment whose result is unknown or that
+// must be abandoned, refunding the payer if it went through. It needs the
+// mutual TLS credentials. When recall is true WeChat Pay asks for Reverse to
+// be called again.
+func (c *V2Client) Reverse(ctx context.Context, outTradeNo string) (recall bool, err error) {
+	if outTradeNo == "" {
+		return false, errors.New("out_trade_no is required")
+	}
+	if !c.hasCert {
+		return false, errors.New("reverse requires the merchant certificate for mutual TLS")
+	}
+
+	resp, err := c.post(ctx, "/secapi/pay/reverse", map[string]string{"out_trade_no": outTradeNo})
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			// The recall flag is also meaningful on failed reversals,
+			// e.g. after SYSTEMERROR.
+			if fields, decodeErr := DecodeV2XML([]byte(apiErr.Body)); decodeErr == nil {
+				recall = fields["recall"] == "Y"
+			}
+		}
+		return recall, err
+	}
+	return resp["recall"] == "Y", nil
+}
+
+// transactionFromV2 maps v2 order fields onto Transaction. time_end is
+// converted to the RFC 3339 form of the v3 success_time.
+func transactionFromV2(fields map[string]string) *Transaction {
+	total, _ := strconv.Atoi(fields["total_fee"])
+	cash, _ := strconv.Atoi(fields["cash_fee"])
+	tx := &Transaction{
+		Appid:          fields["appid"],
+		Mchid:          fields["mch_id"],
+		OutTradeNo:     fields["out_trade_no"],
+		TransactionID:  fields["transaction_id"],
+		TradeType:      fields["trade_type"],
+		TradeState:     fields["trade_state"],
+		TradeStateDesc: fields["trade_state_desc"],
+		BankType:       fields["bank_type"],
+		Attach:         fields["attach"],
+		Payer:          TransactionPayer{Openid: fields["openid"]},
+		Amount: TransactionAmount{
+			Total:         total,
+			PayerTotal:    cash,
+			Currency:      fields["fee_type"],
+			PayerCurrency: fields["cash_fee_type"],
+		},
+	}
+	if fields["device_info"] != "" {
+		tx.SceneInfo = &TransactionSceneInfo{DeviceID: fields["device_info"]}
+	}
+	if end, err := time.ParseInLocation(v2TimeLayout, fields["time_end"], cstLocation); err == nil {
+		tx.SuccessTime = end.Format(time.RFC3339)
+	}
+	return tx
+}
@@ -0,0 +1,135 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMicropay(t *testing.T) {
+	expire := time.Now().Add(time.Minute)
+	client := newTestV2Client(t, V2Config{SignType: SignTypeHMACSHA256}, func(r *http.Request, fields map[string]string) map[string]string {
+		if r.URL.Path != "/pay/micropay" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+		if fields["sign_type"] != SignTypeHMACSHA256 || fields["auth_code"] != "120061098828009406" ||
+			fields["total_fee"] != "888" || fields["spbill_create_ip"] != "14.17.22.52" ||
+			fields["device_info"] != "013467007045764" || fields["time_expire"] != expire.In(cstLocation).Format(v2TimeLayout) {
+			t.Errorf("Unexpected request fields: %v", fields)
+		}
+		var scene struct {
+			StoreInfo map[string]string `json:"store_info"`
+		}
+		json.Unmarshal([]byte(fields["scene_info"]), &scene)
+		if scene.StoreInfo["id"] != "SZTX001" {
+			t.Errorf("Unexpected scene_info: %s", fields["scene_info"])
+		}
+		var detail struct {
+			GoodsDetail []map[string]interface{} `json:"goods_detail"`
+		}
+		json.Unmarshal([]byte(fields["detail"]), &detail)
+		if len(detail.GoodsDetail) != 1 || detail.GoodsDetail[0]["goods_id"] != "1001" || detail.GoodsDetail[0]["price"] != float64(888) {
+			t.Errorf("Unexpected detail: %s", fields["detail"])
+		}
+		return map[string]string{
+			"result_code":    "SUCCESS",
+			"appid":          fields["appid"],
+			"mch_id":         fields["mch_id"],
+			"openid":         "oUpF8uN95-Ptaags6E_roPHg7AG0",
+			"trade_type":     "MICROPAY",
+			"bank_type":      "CMC",
+			"total_fee":      "888",
+			"cash_fee":       "888",
+			"fee_type":       "CNY",
+			"transaction_id": "1217752501201407033233368018",
+			"out_trade_no":   fields["out_trade_no"],
+			"time_end":       "20140703143003",
+		}
+	})
+
+	tx, err := client.Micropay(context.Background(), &MicropayParams{
+		AuthCode:    "120061098828009406",
+		Description: "image形象店-深圳腾大- QQ公仔",
+		OutTradeNo:  "1415757673",
+		Amount:      Amount{Total: 888},
+		SceneInfo: &SceneInfo{
+			PayerClientIP: "14.17.22.52",
+			DeviceID:      "013467007045764",
+			StoreInfo:     &StoreInfo{ID: "SZTX001", Name: "腾大餐厅"},
+		},
+		TimeExpire: expire,
+		Detail:     &OrderDetail{GoodsDetail: []GoodsDetail{{MerchantGoodsID: "1001", Quantity: 1, UnitPrice: 888}}},
+	})
+	if err != nil {
+		t.Fatalf("Micropay failed: %v", err)
+	}
+	if tx.TradeState != TradeStateSuccess || tx.TransactionID != "1217752501201407033233368018" ||
+		tx.Amount.Total != 888 || tx.Payer.Openid != "oUpF8uN95-Ptaags6E_roPHg7AG0" {
+		t.Errorf("Unexpected transaction: %+v", tx)
+	}
+	if tx.SuccessTime != "2014-07-03T14:30:03+08:00" {
+		t.Errorf("Unexpected success_time: %s", tx.SuccessTime)
+	}
+}
+
+func TestMicropay_UserPaying(t *testing.T) {
+	client := newTestV2Client(t, V2Config{}, func(r *http.Request, fields map[string]string) map[string]string {
+		switch r.URL.Path {
+		case "/pay/micropay":
+			return map[string]string{"result_code": "FAIL", "err_code": "USERPAYING", "err_code_des": "需要用户输入支付密码"}
+		case "/pay/orderquery":
+			if fields["out_trade_no"] != "1415757673" {
+				t.Errorf("Unexpected query fields: %v", fields)
+			}
+			return map[string]string{"result_code": "SUCCESS", "out_trade_no": "1415757673", "trade_state": "USERPAYING", "trade_state_desc": "需要用户输入支付密码", "total_fee": "888"}
+		}
+		t.Errorf("Unexpected path: %s", r.URL.Path)
+		return map[string]string{}
+	})
+
+	_, err := client.Micropay(context.Background(), &MicropayParams{
+		AuthCode:    "120061098828009406",
+		Description: "QQ公仔",
+		OutTradeNo:  "1415757673",
+		Amount:      Amount{Total: 888},
+		SceneInfo:   &SceneInfo{PayerClientIP: "14.17.22.52"},
+	})
+	if !errors.Is(err, ErrUserPaying) {
+		t.Fatalf("Expected ErrUserPaying, got %v", err)
+	}
+
+	tx, err := client.QueryOrder(context.Background(), "1415757673")
+	if err != nil {
+		t.Fatalf("QueryOrder failed: %v", err)
+	}
+	if tx.TradeState != TradeStateUserPaying || tx.Final() || tx.Amount.Total != 888 {
+		t.Errorf("Unexpected transaction: %+v", tx)
+	}
+}
+
+func TestMicropay_Validation(t *testing.T) {
+	client := newTestV2Client(t, V2Config{}, func(r *http.Request, fields map[string]string) map[string]string {
+		t.Error("Invalid params should not reach the server")
+		return map[string]string{}
+	})
+
+	tests := []struct {
+		name   string
+		params MicropayParams
+	}{
+		{"missing auth code", MicropayParams{Description: "d", OutTradeNo: "1", Amount: Amount{Total: 1}, SceneInfo: &SceneInfo{PayerClientIP: "1.1.1.1"}}},
+		{"zero amount", MicropayParams{AuthCode: "1", Description: "d", OutTradeNo: "1", SceneInfo: &SceneInfo{PayerClientIP: "1.1.1.1"}}},
+		{"missing scene", MicropayParams{AuthCode: "1", Description: "d", OutTradeNo: "1", Amount: Amount{Total: 1}}},
+		{"bad ip", MicropayParams{AuthCode: "1", Description: "d", OutTradeNo: "1", Amount: Amount{Total: 1}, SceneInfo: &SceneInfo{PayerClientIP: "till-1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.Micropay(context.Background(), &tt.params); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
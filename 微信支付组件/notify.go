@@ -0,0 +1,154 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultNotifyMaxSkew = 5 * time.Minute
+	notifyAlgorithm      = "AEAD_AES_256_GCM"
+)
+
+var (
+	ErrNotifySignature = errors.New("notify signature verification failed")
+	ErrNotifyExpired   = errors.New("notify timestamp outside allowed window")
+)
+
+type NotifyRequest struct {
+	ID           string          `json:"id"`
+	CreateTime   string          `json:"create_time"`
+	EventType    string          `json:"event_type"`
+	ResourceType string          `json:"resource_type"`
+	Summary      string          `json:"summary"`
+	Resource     *NotifyResource `json:"resource"`
+}
+
+type NotifyResource struct {
+	Algorithm      string `json:"algorithm"`
+	Ciphertext     string `json:"ciphertext"`
+	AssociatedData string `json:"associated_data"`
+	OriginalType   string `json:"original_type"`
+	Nonce          string `json:"nonce"`
+}
+
+// NotifyParser verifies and decrypts callbacks posted by WeChat Pay to a notify_url.
+type NotifyParser struct {
+	client  *Client
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+func NewNotifyParser(client *Client) *NotifyParser {
+	return &NotifyParser{
+		client:  client,
+		maxSkew: defaultNotifyMaxSkew,
+		now:     time.Now,
+	}
+}
+
+// SetMaxSkew changes how far Wechatpay-Timestamp may drift from local time
+// before a callback is treated as a replay.
+func (p *NotifyParser) SetMaxSkew(d time.Duration) {
+	p.maxSkew = d
+}
+
+// Parse checks the signature headers of r and decrypts its resource into content.
+func (p *NotifyParser) Parse(r *http.Request, content interface{}) (*NotifyRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.verify(r.Context(), r.Header, body); err != nil {
+		return nil, err
+	}
+
+	var notify NotifyRequest
+	if err := json.Unmarshal(body, &notify); err != nil {
+		return nil, fmt.Errorf("invalid notify body: %w", err)
+	}
+	if notify.Resource == nil {
+		return nil, errors.New("notify resource is missing")
+	}
+	if notify.Resource.Algorithm != notifyAlgorithm {
+		return nil, fmt.Errorf("unsupported notify algorithm: %s", notify.Resource.Algorithm)
+	}
+
+	plaintext, err := decryptAES256GCM(p.client.apiV3Key, notify.Resource.AssociatedData,
+		notify.Resource.Nonce, notify.Resource.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt notify resource: %w", err)
+	}
+	if err := json.Unmarshal(plaintext, content); err != nil {
+		return nil, fmt.Errorf("invalid notify resource: %w", err)
+	}
+
+	return &notify, nil
+}
+
+func (p *NotifyParser) verify(ctx context.Context, header http.Header, body []byte) error {
+	timestamp := header.Get("Wechatpay-Timestamp")
+	if timestamp == "" {
+		return fmt.Errorf("missing wechatpay headers")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid wechatpay timestamp: %s", timestamp)
+	}
+	skew := p.now().Sub(time.Unix(ts, 0))
+	if skew > p.maxSkew || skew < -p.maxSkew {
+		return ErrNotifyExpired
+	}
+
+	if err := p.client.verify(ctx, header, body); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotifySignature, err)
+	}
+	return nil
+}
+
+type TransactionHandlerFunc func(ctx context.Context, notify *NotifyRequest, transaction *Transaction) error
+
+// NewTransactionNotifyHandler returns the http.Handler to mount at the NotifyURL
+// passed to CreateOrder.
+func NewTransactionNotifyHandler(parser *NotifyParser, fn TransactionHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var transaction Transaction
+		serveNotify(w, r, parser, &transaction, func(notify *NotifyRequest) error {
+			return fn(r.Context(), notify, &transaction)
+		})
+	})
+}
+
+func serveNotify(w http.ResponseWriter, r *http.Request, parser *NotifyParser, content interface{}, fn func(*NotifyRequest) error) {
+	if r.Method != http.MethodPost {
+		writeNotifyResponse(w, http.StatusMethodNotAllowed, "FAIL", "method not allowed")
+		return
+	}
+
+	notify, err := parser.Parse(r, content)
+	if err != nil {
+		writeNotifyResponse(w, http.StatusBadRequest, "FAIL", err.Error())
+		return
+	}
+
+	if err := fn(notify); err != nil {
+		writeNotifyResponse(w, http.StatusInternalServerError, "FAIL", err.Error())
+		return
+	}
+
+	writeNotifyResponse(w, http.StatusOK, "SUCCESS", "成功")
+}
+
+func writeNotifyResponse(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Code: code, Message: message})
+}
@@ -0,0 +1,163 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encryptTestResource(t *testing.T, key, associatedData, nonce string, plaintext []byte) string {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatalf("new cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("new gcm: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nil, []byte(nonce), plaintext, []byte(associatedData)))
+}
+
+func newTestNotifyRequest(t *testing.T, key *rsa.PrivateKey, serial string, ts time.Time, resource interface{}) *http.Request {
+	t.Helper()
+	plaintext, _ := json.Marshal(resource)
+	body, _ := json.Marshal(NotifyRequest{
+		ID:           "EV-2018022511223320873",
+		CreateTime:   "2015-05-20T13:29:35+08:00",
+		EventType:    "TRANSACTION.SUCCESS",
+		ResourceType: "encrypt-resource",
+		Summary:      "支付成功",
+		Resource: &NotifyResource{
+			Algorithm:      notifyAlgorithm,
+			Ciphertext:     encryptTestResource(t, testAPIv3Key, "transaction", "fdasflkja484", plaintext),
+			AssociatedData: "transaction",
+			OriginalType:   "transaction",
+			Nonce:          "fdasflkja484",
+		},
+	})
+
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	nonce := "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
+	req := httptest.NewRequest("POST", "/notify", strings.NewReader(string(body)))
+	req.Header.Set("Wechatpay-Timestamp", timestamp)
+	req.Header.Set("Wechatpay-Nonce", nonce)
+	req.Header.Set("Wechatpay-Serial", serial)
+	req.Header.Set("Wechatpay-Signature", signTestMessage(t, key, buildVerifyMessage(timestamp, nonce, body)))
+	return req
+}
+
+func newTestNotifyParser(t *testing.T) (*NotifyParser, *rsa.PrivateKey, string) {
+	t.Helper()
+	client, platform := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	return NewNotifyParser(client), platform.key, platform.serial
+}
+
+func TestTransactionNotifyHandler_Success(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+
+	var got *Transaction
+	handler := NewTransactionNotifyHandler(parser, func(ctx context.Context, notify *NotifyRequest, transaction *Transaction) error {
+		if notify.EventType != "TRANSACTION.SUCCESS" {
+			t.Errorf("Unexpected event type: %s", notify.EventType)
+		}
+		got = transaction
+		return nil
+	})
+
+	req := newTestNotifyRequest(t, key, serial, time.Now(), map[string]interface{}{
+		"mchid":          "1230000109",
+		"out_trade_no":   "1217752501201407033233368018",
+		"transaction_id": "1217752501201407033233368018",
+		"trade_state":    "SUCCESS",
+		"success_time":   "2018-06-08T10:34:56+08:00",
+		"payer":          map[string]string{"openid": "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+		"amount":         map[string]interface{}{"total": 100, "payer_total": 100, "currency": "CNY"},
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Code != "SUCCESS" {
+		t.Errorf("Expected SUCCESS response, got %+v", resp)
+	}
+	if got == nil || got.OutTradeNo != "1217752501201407033233368018" || got.Amount.Total != 100 || got.Payer.Openid == "" {
+		t.Errorf("Unexpected transaction: %+v", got)
+	}
+}
+
+func TestTransactionNotifyHandler_Rejects(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+	otherKey, _ := generateTestKeyPair()
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"expired timestamp", newTestNotifyRequest(t, key, serial, time.Now().Add(-10*time.Minute), map[string]string{}), http.StatusBadRequest},
+		{"wrong signer", newTestNotifyRequest(t, otherKey, serial, time.Now(), map[string]string{}), http.StatusBadRequest},
+		{"unknown serial", newTestNotifyRequest(t, key, "FFFF", time.Now(), map[string]string{}), http.StatusBadRequest},
+		{"missing headers", httptest.NewRequest("POST", "/notify", strings.NewReader("{}")), http.StatusBadRequest},
+		{"wrong method", httptest.NewRequest("GET", "/notify", nil), http.StatusMethodNotAllowed},
+	}
+
+	handler := NewTransactionNotifyHandler(parser, func(ctx context.Context, notify *NotifyRequest, transaction *Transaction) error {
+		t.Error("Callback should not be invoked")
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tt.req)
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			var resp ErrorResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Code != "FAIL" {
+				t.Errorf("Expected FAIL response, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestTransactionNotifyHandler_CallbackError(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+	handler := NewTransactionNotifyHandler(parser, func(ctx context.Context, notify *NotifyRequest, transaction *Transaction) error {
+		return errors.New("database unavailable")
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newTestNotifyRequest(t, key, serial, time.Now(), map[string]string{"out_trade_no": "1"}))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", rec.Code)
+	}
+}
+
+func TestNotifyParser_Expired(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+	parser.SetMaxSkew(time.Minute)
+
+	req := newTestNotifyRequest(t, key, serial, time.Now().Add(-2*time.Minute), map[string]string{})
+	var transaction Transaction
+	if _, err := parser.Parse(req, &transaction); !errors.Is(err, ErrNotifyExpired) {
+		t.Errorf("Expected ErrNotifyExpired, got %v", err)
+	}
+}
@@ -1,27 +1,78 @@
-package payment
+package wechatpay
 
 import (
-	"bytes"
 	"context"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"math/rand"
+	"net"
 	"net/http"
 	"time"
 )
 
+// TradeType selects the /v3/pay/transactions endpoint an order is created on.
+// Mini Program checkouts use TradeTypeJSAPI with the Mini Program appid.
+type TradeType string
+
+const (
+	TradeTypeNative TradeType = "NATIVE"
+	TradeTypeJSAPI  TradeType = "JSAPI"
+	TradeTypeH5     TradeType = "MWEB"
+	TradeTypeApp    TradeType = "APP"
+)
+
+// tradeTypePaths are relative to Client.transactionsPath.
+var tradeTypePaths = map[TradeType]string{
+	TradeTypeNative: "/native",
+	TradeTypeJSAPI:  "/jsapi",
+	TradeTypeH5:     "/h5",
+	TradeTypeApp:    "/app",
+}
+
 type CreateOrderParams struct {
+	// TradeType defaults to TradeTypeNative when empty.
+	TradeType TradeType
+	// Appid is the merchant's appid, or the service provider's sp_appid
+	// for a client returned by WithSubMerchant.
 	Appid       string
-	Mchid       string
 	Description string
 	OutTradeNo  string
 	NotifyURL   string
 	Amount      Amount
+	Payer       *Payer
+	SceneInfo   *SceneInfo
+
+	// TimeExpire is when the order stops accepting payment. It is sent in
+	// RFC 3339 with the +08:00 offset WeChat Pay expects.
+	TimeExpire time.Time
+	// Attach is returned unchanged in queries and notifications.
+	Attach        string
+	GoodsTag      string
+	SupportFapiao bool
+	Detail        *OrderDetail
+	SettleInfo    *SettleInfo
+}
+
+// OrderDetail lists the goods of an order, used for single-item coupons
+// and invoicing.
+type OrderDetail struct {
+	CostPrice   int
+	InvoiceID   string
+	GoodsDetail []GoodsDetail
+}
+
+type GoodsDetail struct {
+	MerchantGoodsID  string
+	WechatpayGoodsID string
+	GoodsName        string
+	Quantity         int
+	UnitPrice        int
+}
+
+type SettleInfo struct {
+	// ProfitSharing freezes the funds of the order until they are split
+	// with profit sharing or unfrozen.
+	ProfitSharing bool
 }
 
 type Amount struct {
@@ -29,40 +80,67 @@ type Amount struct {
 	Currency string
 }
 
-type CreateOrderResponse struct {
-	PrepayID string `json:"prepay_id"`
+type Payer struct {
+	// Openid is the payer's openid under Appid. For a sub-merchant client
+	// it is sent as sp_openid.
+	Openid string
+	// SubOpenid is the payer's openid under the sub-merchant's appid and
+	// is only valid on a client from WithSubMerchant with an Appid.
+	SubOpenid string
+}
+
+type SceneInfo struct {
+	PayerClientIP string
+	DeviceID      string
+	// H5Info is required for TradeTypeH5.
+	H5Info    *H5Info
+	StoreInfo *StoreInfo
 }
 
-type Credential struct {
-	MchAPIv3Key  string
-	CertSerialNo string
-	PrivateKey   *rsa.PrivateKey
+type StoreInfo struct {
+	ID       string
+	Name     string
+	AreaCode string
+	Address  string
 }
 
-func CreateOrder(ctx context.Context, params *CreateOrderParams, credential *Credential) (*CreateOrderResponse, error) {
+type H5Info struct {
+	// Type is one of "Wap", "iOS" or "Android".
+	Type        string
+	AppName     string
+	AppURL      string
+	BundleID    string
+	PackageName string
+}
+
+// CreateOrderResponse holds the output of every trade type; only the field
+// matching the order's TradeType is set. JSAPI and APP orders return
+// PrepayID, H5 orders return H5URL and native orders return CodeURL, which
+// EncodeQRCode turns into a scannable image.
+type CreateOrderResponse struct {
+	PrepayID string `json:"prepay_id"`
+	H5URL    string `json:"h5_url"`
+	CodeURL  string `json:"code_url"`
+}
+
+func (c *Client) CreateOrder(ctx context.Context, params *CreateOrderParams) (*CreateOrderResponse, error) {
 	if err := validateCreateOrderParams(params); err != nil {
 		return nil, err
 	}
 
-	req, err := buildCreateOrderRequest(params)
+	req, err := c.buildCreateOrderRequest(params)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := signRequest(req, credential); err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req.WithContext(ctx))
+	resp, err := c.doRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("wechat pay error: %s, %s", resp.Status, body)
+		return nil, newAPIError(resp)
 	}
 
 	var orderResp CreateOrderResponse
@@ -73,11 +151,13 @@ func CreateOrder(ctx context.Context, params *CreateOrderParams, credential *Cre
 	return &orderResp, nil
 }
 
-func buildCreateOrderRequest(params *CreateOrderParams) (*http.Request, error) {
-	const apiURL = "https://api.mch.weixin.qq.com/v3/pay/transactions/native"
+func (c *Client) buildCreateOrderRequest(params *CreateOrderParams) (*http.Request, error) {
+	path, ok := tradeTypePaths[params.tradeType()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported trade type: %s", params.TradeType)
+	}
+
 	requestBody := map[string]interface{}{
-		"appid":        params.Appid,
-		"mchid":        params.Mchid,
 		"description":  params.Description,
 		"out_trade_no": params.OutTradeNo,
 		"notify_url":   params.NotifyURL,
@@ -86,29 +166,81 @@ func buildCreateOrderRequest(params *CreateOrderParams) (*http.Request, error) {
 			"currency": params.Amount.Currency,
 		},
 	}
+	if c.isPartner() {
+		requestBody["sp_appid"] = params.Appid
+		requestBody["sp_mchid"] = c.mchID
+		requestBody["sub_mchid"] = c.subMchID
+		if c.subAppid != "" {
+			requestBody["sub_appid"] = c.subAppid
+		}
+	} else {
+		requestBody["appid"] = params.Appid
+		requestBody["mchid"] = c.mchID
+	}
+	if params.Payer != nil {
+		payer, err := c.buildPayer(params.Payer)
+		if err != nil {
+			return nil, err
+		}
+		requestBody["payer"] = payer
+	}
+	if params.SceneInfo != nil {
+		requestBody["scene_info"] = buildSceneInfo(params.SceneInfo)
+	}
+	if !params.TimeExpire.IsZero() {
+		requestBody["time_expire"] = params.TimeExpire.In(cstLocation).Format(time.RFC3339)
+	}
+	if params.Attach != "" {
+		requestBody["attach"] = params.Attach
+	}
+	if params.GoodsTag != "" {
+		requestBody["goods_tag"] = params.GoodsTag
+	}
+	if params.SupportFapiao {
+		requestBody["support_fapiao"] = true
+	}
+	if params.Detail != nil {
+		requestBody["detail"] = buildOrderDetail(params.Detail)
+	}
+	if params.SettleInfo != nil {
+		requestBody["settle_info"] = map[string]interface{}{
+			"profit_sharing": params.SettleInfo.ProfitSharing,
+		}
+	}
 
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, err
+	return c.newRequest("POST", c.transactionsPath()+path, jsonBody)
+}
+
+func (c *Client) buildPayer(payer *Payer) (map[string]interface{}, error) {
+	if !c.isPartner() {
+		if payer.SubOpenid != "" {
+			return nil, errors.New("payer.sub_openid is only valid for sub-merchant orders")
+		}
+		return map[string]interface{}{"openid": payer.Openid}, nil
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	return req, nil
+	result := map[string]interface{}{}
+	if payer.Openid != "" {
+		result["sp_openid"] = payer.Openid
+	}
+	if payer.SubOpenid != "" {
+		if c.subAppid == "" {
+			return nil, errors.New("payer.sub_openid requires the sub-merchant appid")
+		}
+		result["sub_openid"] = payer.SubOpenid
+	}
+	return result, nil
 }
 
 func validateCreateOrderParams(params *CreateOrderParams) error {
 	if params.Appid == "" {
 		return errors.New("appid is required")
 	}
-	if params.Mchid == "" {
-		return errors.New("mchid is required")
-	}
 	if params.Description == "" {
 		return errors.New("description is required")
 	}
@@ -124,60 +256,162 @@ func validateCreateOrderParams(params *CreateOrderParams) error {
 	if params.Amount.Currency == "" {
 		return errors.New("currency is required")
 	}
-	return nil
+	if _, ok := tradeTypePaths[params.tradeType()]; !ok {
+		return fmt.Errorf("unsupported trade type: %s", params.TradeType)
+	}
+	if params.tradeType() == TradeTypeJSAPI && (params.Payer == nil || (params.Payer.Openid == "" && params.Payer.SubOpenid == "")) {
+		return errors.New("payer.openid is required for JSAPI orders")
+	}
+	if params.tradeType() == TradeTypeH5 {
+		if params.SceneInfo == nil || params.SceneInfo.PayerClientIP == "" {
+			return errors.New("scene_info.payer_client_ip is required for H5 orders")
+		}
+		if params.SceneInfo.H5Info == nil || params.SceneInfo.H5Info.Type == "" {
+			return errors.New("scene_info.h5_info.type is required for H5 orders")
+		}
+	}
+	return validateOrderOptions(params)
 }
 
-func signRequest(req *http.Request, credential *Credential) error {
-	const authType = "WECHATPAY2-SHA256-RSA2048"
-	timestamp := time.Now().Unix()
-	nonce := generateNonce(16)
-	body, err := getRequestBody(req)
-	if err != nil {
-		return err
+// validateOrderOptions checks the optional fields against WeChat Pay's
+// limits. Lengths are in bytes, as WeChat Pay counts them.
+func validateOrderOptions(params *CreateOrderParams) error {
+	if len(params.Description) > 127 {
+		return errors.New("description must be at most 127 bytes")
 	}
-
-	signatureStr := fmt.Sprintf("%s\n%s\n%d\n%s\n%s\n",
-		req.Method, req.URL.Path, timestamp, nonce, body)
-
-	hashed := sha256.Sum256([]byte(signatureStr))
-	signature, err := signWithPrivateKey(hashed[:], credential.PrivateKey)
-	if err != nil {
-		return err
+	if len(params.Attach) > 128 {
+		return errors.New("attach must be at most 128 bytes")
+	}
+	if len(params.GoodsTag) > 32 {
+		return errors.New("goods_tag must be at most 32 bytes")
+	}
+	if !params.TimeExpire.IsZero() && !params.TimeExpire.After(time.Now()) {
+		return errors.New("time_expire must be in the future")
 	}
 
-	authValue := fmt.Sprintf("%s mchid=\"%s\",nonce_str=\"%s\",timestamp=\"%d\",serial_no=\"%s\",signature=\"%s\"",
-		authType, credential.Mchid, nonce, timestamp, credential.CertSerialNo, signature)
+	if scene := params.SceneInfo; scene != nil {
+		if scene.PayerClientIP == "" {
+			return errors.New("scene_info.payer_client_ip is required")
+		}
+		if net.ParseIP(scene.PayerClientIP) == nil {
+			return fmt.Errorf("invalid scene_info.payer_client_ip: %s", scene.PayerClientIP)
+		}
+		if len(scene.DeviceID) > 32 {
+			return errors.New("scene_info.device_id must be at most 32 bytes")
+		}
+		if store := scene.StoreInfo; store != nil {
+			if store.ID == "" || len(store.ID) > 32 {
+				return errors.New("scene_info.store_info.id must be 1 to 32 bytes")
+			}
+			if len(store.Name) > 256 || len(store.AreaCode) > 32 || len(store.Address) > 512 {
+				return errors.New("scene_info.store_info name, area_code or address is too long")
+			}
+		}
+	}
 
-	req.Header.Set("Authorization", authValue)
+	if detail := params.Detail; detail != nil {
+		if detail.CostPrice < 0 {
+			return errors.New("detail.cost_price must not be negative")
+		}
+		if len(detail.InvoiceID) > 32 {
+			return errors.New("detail.invoice_id must be at most 32 bytes")
+		}
+		for i, goods := range detail.GoodsDetail {
+			if goods.MerchantGoodsID == "" || len(goods.MerchantGoodsID) > 32 {
+				return fmt.Errorf("detail.goods_detail[%d].merchant_goods_id must be 1 to 32 bytes", i)
+			}
+			if len(goods.WechatpayGoodsID) > 32 {
+				return fmt.Errorf("detail.goods_detail[%d].wechatpay_goods_id must be at most 32 bytes", i)
+			}
+			if len(goods.GoodsName) > 256 {
+				return fmt.Errorf("detail.goods_detail[%d].goods_name must be at most 256 bytes", i)
+			}
+			if goods.Quantity < 1 {
+				return fmt.Errorf("detail.goods_detail[%d].quantity must be positive", i)
+			}
+			if goods.UnitPrice < 0 {
+				return fmt.Errorf("detail.goods_detail[%d].unit_price must not be negative", i)
+			}
+		}
+	}
 	return nil
 }
 
-func generateNonce(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
+func buildSceneInfo(scene *SceneInfo) map[string]interface{} {
+	sceneInfo := map[string]interface{}{
+		"payer_client_ip": scene.PayerClientIP,
+	}
+	if scene.DeviceID != "" {
+		sceneInfo["device_id"] = scene.DeviceID
 	}
-	return string(b)
+	if scene.H5Info != nil {
+		h5Info := map[string]interface{}{
+			"type": scene.H5Info.Type,
+		}
+		if scene.H5Info.AppName != "" {
+			h5Info["app_name"] = scene.H5Info.AppName
+		}
+		if scene.H5Info.AppURL != "" {
+			h5Info["app_url"] = scene.H5Info.AppURL
+		}
+		if scene.H5Info.BundleID != "" {
+			h5Info["bundle_id"] = scene.H5Info.BundleID
+		}
+		if scene.H5Info.PackageName != "" {
+			h5Info["package_name"] = scene.H5Info.PackageName
+		}
+		sceneInfo["h5_info"] = h5Info
+	}
+	if scene.StoreInfo != nil {
+		storeInfo := map[string]interface{}{
+			"id": scene.StoreInfo.ID,
+		}
+		if scene.StoreInfo.Name != "" {
+			storeInfo["name"] = scene.StoreInfo.Name
+		}
+		if scene.StoreInfo.AreaCode != "" {
+			storeInfo["area_code"] = scene.StoreInfo.AreaCode
+		}
+		if scene.StoreInfo.Address != "" {
+			storeInfo["address"] = scene.StoreInfo.Address
+		}
+		sceneInfo["store_info"] = storeInfo
+	}
+	return sceneInfo
 }
 
-func getRequestBody(req *http.Request) (string, error) {
-	if req.Body == nil {
-		return "", nil
-	}
-	bodyBytes, err := io.ReadAll(req.Body)
-	if err != nil {
-		return "", err
+func (p *CreateOrderParams) tradeType() TradeType {
+	if p.TradeType == "" {
+		return TradeTypeNative
 	}
-	req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-	return string(bodyBytes), nil
+	return p.TradeType
 }
 
-func signWithPrivateKey(data []byte, privateKey *rsa.PrivateKey) (string, error) {
-	hashed := sha256.Sum256(data)
-	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, hashed[:])
-	if err != nil {
-		return "", err
+func buildOrderDetail(detail *OrderDetail) map[string]interface{} {
+	result := map[string]interface{}{}
+	if detail.CostPrice > 0 {
+		result["cost_price"] = detail.CostPrice
+	}
+	if detail.InvoiceID != "" {
+		result["invoice_id"] = detail.InvoiceID
+	}
+	if len(detail.GoodsDetail) > 0 {
+		goodsDetail := make([]map[string]interface{}, 0, len(detail.GoodsDetail))
+		for _, goods := range detail.GoodsDetail {
+			line := map[string]interface{}{
+				"merchant_goods_id": goods.MerchantGoodsID,
+				"quantity":          goods.Quantity,
+				"unit_price":        goods.UnitPrice,
+			}
+			if goods.WechatpayGoodsID != "" {
+				line["wechatpay_goods_id"] = goods.WechatpayGoodsID
+			}
+			if goods.GoodsName != "" {
+				line["goods_name"] = goods.GoodsName
+			}
+			goodsDetail = append(goodsDetail, line)
+		}
+		result["goods_detail"] = goodsDetail
 	}
-	return base64.StdEncoding.EncodeToString(signature), nil
+	return result
 }
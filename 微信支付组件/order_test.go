@@ -1,41 +1,48 @@
-package payment_test
+package wechatpay
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
-	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
-
-	"your_module_path/payment"
 )
 
+func newTestOrderParams() *CreateOrderParams {
+	return &CreateOrderParams{
+		Appid:       "appid",
+		Description: "desc",
+		OutTradeNo:  "order123",
+		NotifyURL:   "https://example.com/notify",
+		Amount:      Amount{Total: 100, Currency: "CNY"},
+	}
+}
+
 func TestValidateCreateOrderParams(t *testing.T) {
 	tests := []struct {
 		name    string
-		params  payment.CreateOrderParams
+		params  CreateOrderParams
 		wantErr error
 	}{
 		{
 			name:    "missing appid",
-			params:  payment.CreateOrderParams{Mchid: "mch", Description: "desc", OutTradeNo: "no", NotifyURL: "url", Amount: payment.Amount{Total: 100, Currency: "CNY"}},
+			params:  CreateOrderParams{Description: "desc", OutTradeNo: "no", NotifyURL: "url", Amount: Amount{Total: 100, Currency: "CNY"}},
 			wantErr: errors.New("appid is required"),
 		},
 		{
 			name:    "invalid amount",
-			params:  payment.CreateOrderParams{Appid: "app", Mchid: "mch", Description: "desc", OutTradeNo: "no", NotifyURL: "url", Amount: payment.Amount{Total: -1, Currency: "CNY"}},
+			params:  CreateOrderParams{Appid: "app", Description: "desc", OutTradeNo: "no", NotifyURL: "url", Amount: Amount{Total: -1, Currency: "CNY"}},
 			wantErr: errors.New("amount.total must be positive"),
 		},
 		{
 			name: "valid params",
-			params: payment.CreateOrderParams{
-				Appid: "app", Mchid: "mch", Description: "desc", 
-				OutTradeNo: "no", NotifyURL: "url", 
-				Amount: payment.Amount{Total: 100, Currency: "CNY"},
+			params: CreateOrderParams{
+				Appid: "app", Description: "desc",
+				OutTradeNo: "no", NotifyURL: "url",
+				Amount: Amount{Total: 100, Currency: "CNY"},
 			},
 			wantErr: nil,
 		},
@@ -43,7 +50,7 @@ func TestValidateCreateOrderParams(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := payment.ValidateCreateOrderParams(&tt.params)
+			err := validateCreateOrderParams(&tt.params)
 			if (err != nil) != (tt.wantErr != nil) {
 				t.Fatalf("expected error: %v, got: %v", tt.wantErr, err)
 			}
@@ -55,111 +62,265 @@ func TestValidateCreateOrderParams(t *testing.T) {
 }
 
 func TestCreateOrder_Success(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/pay/transactions/native" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["mchid"] != testMchID {
+			t.Errorf("expected mchid from client, got %v", body["mchid"])
+		}
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(payment.CreateOrderResponse{PrepayID: "prepay_123"})
-	}))
-	defer ts.Close()
+		w.Write([]byte(`{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`))
+	})
+
+	resp, err := client.CreateOrder(context.Background(), newTestOrderParams())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.CodeURL != "weixin://wxpay/bizpayurl?pr=p4lpSuKzz" {
+		t.Fatalf("expected code_url, got '%s'", resp.CodeURL)
+	}
+}
 
-	// Override API URL for testing
-	originalURL := payment.APIURL
-	payment.APIURL = ts.URL
-	defer func() { payment.APIURL = originalURL }()
+func TestCreateOrder_ErrorResponse(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"INVALID_REQUEST"}`))
+	})
 
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	credential := &payment.Credential{
-		MchAPIv3Key:  "key",
-		CertSerialNo: "serial",
-		PrivateKey:   privateKey,
+	_, err := client.CreateOrder(context.Background(), newTestOrderParams())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "INVALID_REQUEST" {
+		t.Fatalf("expected INVALID_REQUEST APIError, got: %v", err)
+	}
+	if IsRetryable(err) {
+		t.Error("INVALID_REQUEST should not be retryable")
 	}
+}
 
-	params := &payment.CreateOrderParams{
-		Appid:       "appid",
-		Mchid:       "mchid",
-		Description: "desc",
-		OutTradeNo:  "order123",
-		NotifyURL:   "https://example.com/notify",
-		Amount:      payment.Amount{Total: 100, Currency: "CNY"},
+func TestCreateOrder_ContextCancel(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.CreateOrder(ctx, newTestOrderParams())
+	if err == nil {
+		t.Fatal("expected context canceled error, got nil")
 	}
+}
 
-	resp, err := payment.CreateOrder(context.Background(), params, credential)
+func TestBuildCreateOrderRequest_JSAPI(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	params := &CreateOrderParams{
+		TradeType:   TradeTypeJSAPI,
+		Appid:       "wxd678efh567hg6787",
+		Description: "Image形象店-深圳腾大-QQ公仔",
+		OutTradeNo:  "1217752501201407033233368018",
+		NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+		Amount:      Amount{Total: 100, Currency: "CNY"},
+		Payer:       &Payer{Openid: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+	}
+	if err := validateCreateOrderParams(params); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	req, err := client.buildCreateOrderRequest(params)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if resp.PrepayID != "prepay_123" {
-		t.Fatalf("expected prepay_id 'prepay_123', got '%s'", resp.PrepayID)
+	if req.URL.Path != "/v3/pay/transactions/jsapi" {
+		t.Errorf("expected jsapi endpoint, got %s", req.URL.Path)
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	var decoded struct {
+		Payer struct {
+			Openid string `json:"openid"`
+		} `json:"payer"`
+	}
+	json.Unmarshal(body, &decoded)
+	if decoded.Payer.Openid != "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o" {
+		t.Errorf("expected payer.openid in body, got %s", body)
+	}
+
+	params.Payer = nil
+	if err := validateCreateOrderParams(params); err == nil {
+		t.Error("expected error for JSAPI order without openid")
+	}
+
+	params.TradeType = "UNKNOWN"
+	if _, err := client.buildCreateOrderRequest(params); err == nil {
+		t.Error("expected error for unsupported trade type")
 	}
 }
 
-func TestCreateOrder_ErrorResponse(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"code":"INVALID_REQUEST"}`))
-	}))
-	defer ts.Close()
+func TestBuildCreateOrderRequest_H5(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	params := &CreateOrderParams{
+		TradeType:   TradeTypeH5,
+		Appid:       "wxd678efh567hg6787",
+		Description: "Image形象店-深圳腾大-QQ公仔",
+		OutTradeNo:  "1217752501201407033233368018",
+		NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+		Amount:      Amount{Total: 100, Currency: "CNY"},
+	}
+	if err := validateCreateOrderParams(params); err == nil {
+		t.Fatal("expected error for H5 order without scene_info")
+	}
 
-	originalURL := payment.APIURL
-	payment.APIURL = ts.URL
-	defer func() { payment.APIURL = originalURL }()
+	params.SceneInfo = &SceneInfo{PayerClientIP: "14.23.150.211"}
+	if err := validateCreateOrderParams(params); err == nil {
+		t.Fatal("expected error for H5 order without h5_info")
+	}
 
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	credential := &payment.Credential{
-		MchAPIv3Key:  "key",
-		CertSerialNo: "serial",
-		PrivateKey:   privateKey,
+	params.SceneInfo.H5Info = &H5Info{Type: "iOS", AppName: "王者荣耀"}
+	if err := validateCreateOrderParams(params); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
 	}
 
-	params := &payment.CreateOrderParams{
-		Appid:       "appid",
-		Mchid:       "mchid",
-		Description: "desc",
-		OutTradeNo:  "order123",
-		NotifyURL:   "https://example.com/notify",
-		Amount:      payment.Amount{Total: 100, Currency: "CNY"},
+	req, err := client.buildCreateOrderRequest(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.URL.Path != "/v3/pay/transactions/h5" {
+		t.Errorf("expected h5 endpoint, got %s", req.URL.Path)
 	}
 
-	_, err := payment.CreateOrder(context.Background(), params, credential)
-	if err == nil {
-		t.Fatal("expected error, got nil")
+	body, _ := io.ReadAll(req.Body)
+	var decoded struct {
+		SceneInfo struct {
+			PayerClientIP string `json:"payer_client_ip"`
+			H5Info        struct {
+				Type    string `json:"type"`
+				AppName string `json:"app_name"`
+			} `json:"h5_info"`
+		} `json:"scene_info"`
 	}
-	expectedErr := "wechat pay error: 400 Bad Request, {\"code\":\"INVALID_REQUEST\"}"
-	if err.Error() != expectedErr {
-		t.Fatalf("expected error: %s, got: %v", expectedErr, err)
+	json.Unmarshal(body, &decoded)
+	if decoded.SceneInfo.PayerClientIP != "14.23.150.211" || decoded.SceneInfo.H5Info.Type != "iOS" || decoded.SceneInfo.H5Info.AppName != "王者荣耀" {
+		t.Errorf("unexpected scene_info in body: %s", body)
 	}
 }
 
-func TestCreateOrder_ContextCancel(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(100 * time.Millisecond)
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer ts.Close()
+func TestCreateOrderResponse_H5URL(t *testing.T) {
+	var resp CreateOrderResponse
+	json.Unmarshal([]byte(`{"h5_url":"https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx2916263004719461949c84457c735b0000&package=2150917749"}`), &resp)
+	if resp.H5URL == "" || resp.PrepayID != "" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
 
-	originalURL := payment.APIURL
-	payment.APIURL = ts.URL
-	defer func() { payment.APIURL = originalURL }()
+func TestBuildCreateOrderRequest_Options(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	params := newTestOrderParams()
+	params.TimeExpire = time.Now().Add(time.Hour).UTC()
+	params.Attach = "深圳分店"
+	params.GoodsTag = "WXG"
+	params.SupportFapiao = true
+	params.Detail = &OrderDetail{
+		CostPrice: 608800,
+		InvoiceID: "微信123",
+		GoodsDetail: []GoodsDetail{
+			{MerchantGoodsID: "1246464644", WechatpayGoodsID: "1001", GoodsName: "iPhoneX 256G", Quantity: 1, UnitPrice: 528800},
+		},
+	}
+	params.SceneInfo = &SceneInfo{
+		PayerClientIP: "14.23.150.211",
+		StoreInfo:     &StoreInfo{ID: "0001", Name: "腾讯大厦分店", AreaCode: "440305", Address: "广东省深圳市南山区科技中一道10000号"},
+	}
+	params.SettleInfo = &SettleInfo{ProfitSharing: true}
 
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	credential := &payment.Credential{
-		MchAPIv3Key:  "key",
-		CertSerialNo: "serial",
-		PrivateKey:   privateKey,
+	if err := validateCreateOrderParams(params); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	req, err := client.buildCreateOrderRequest(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	params := &payment.CreateOrderParams{
-		Appid:       "appid",
-		Mchid:       "mchid",
-		Description: "desc",
-		OutTradeNo:  "order123",
-		NotifyURL:   "https://example.com/notify",
-		Amount:      payment.Amount{Total: 100, Currency: "CNY"},
+	body, _ := io.ReadAll(req.Body)
+	var decoded struct {
+		TimeExpire    string `json:"time_expire"`
+		Attach        string `json:"attach"`
+		GoodsTag      string `json:"goods_tag"`
+		SupportFapiao bool   `json:"support_fapiao"`
+		Detail        struct {
+			CostPrice   int    `json:"cost_price"`
+			InvoiceID   string `json:"invoice_id"`
+			GoodsDetail []struct {
+				MerchantGoodsID string `json:"merchant_goods_id"`
+				Quantity        int    `json:"quantity"`
+				UnitPrice       int    `json:"unit_price"`
+			} `json:"goods_detail"`
+		} `json:"detail"`
+		SceneInfo struct {
+			StoreInfo struct {
+				ID       string `json:"id"`
+				AreaCode string `json:"area_code"`
+			} `json:"store_info"`
+		} `json:"scene_info"`
+		SettleInfo struct {
+			ProfitSharing bool `json:"profit_sharing"`
+		} `json:"settle_info"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("invalid body: %v", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
+	expire, err := time.Parse(time.RFC3339, decoded.TimeExpire)
+	if err != nil || !expire.Equal(params.TimeExpire.Truncate(time.Second)) || !strings.HasSuffix(decoded.TimeExpire, "+08:00") {
+		t.Errorf("unexpected time_expire: %s", decoded.TimeExpire)
+	}
+	if decoded.Attach != "深圳分店" || decoded.GoodsTag != "WXG" || !decoded.SupportFapiao || !decoded.SettleInfo.ProfitSharing {
+		t.Errorf("unexpected options in body: %s", body)
+	}
+	if decoded.Detail.CostPrice != 608800 || len(decoded.Detail.GoodsDetail) != 1 || decoded.Detail.GoodsDetail[0].UnitPrice != 528800 {
+		t.Errorf("unexpected detail in body: %s", body)
+	}
+	if decoded.SceneInfo.StoreInfo.ID != "0001" || decoded.SceneInfo.StoreInfo.AreaCode != "440305" {
+		t.Errorf("unexpected store_info in body: %s", body)
+	}
+}
 
-	_, err := payment.CreateOrder(ctx, params, credential)
-	if err == nil {
-		t.Fatal("expected context canceled error, got nil")
+func TestValidateOrderOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *CreateOrderParams)
+	}{
+		{"expired time_expire", func(p *CreateOrderParams) { p.TimeExpire = time.Now().Add(-time.Minute) }},
+		{"long attach", func(p *CreateOrderParams) { p.Attach = strings.Repeat("a", 129) }},
+		{"long goods_tag", func(p *CreateOrderParams) { p.GoodsTag = strings.Repeat("a", 33) }},
+		{"long description", func(p *CreateOrderParams) { p.Description = strings.Repeat("描", 43) }},
+		{"negative cost_price", func(p *CreateOrderParams) { p.Detail = &OrderDetail{CostPrice: -1} }},
+		{"goods without id", func(p *CreateOrderParams) {
+			p.Detail = &OrderDetail{GoodsDetail: []GoodsDetail{{Quantity: 1, UnitPrice: 1}}}
+		}},
+		{"goods without quantity", func(p *CreateOrderParams) {
+			p.Detail = &OrderDetail{GoodsDetail: []GoodsDetail{{MerchantGoodsID: "1", UnitPrice: 1}}}
+		}},
+		{"scene_info without ip", func(p *CreateOrderParams) { p.SceneInfo = &SceneInfo{DeviceID: "013467007045764"} }},
+		{"invalid ip", func(p *CreateOrderParams) { p.SceneInfo = &SceneInfo{PayerClientIP: "not-an-ip"} }},
+		{"store without id", func(p *CreateOrderParams) {
+			p.SceneInfo = &SceneInfo{PayerClientIP: "14.23.150.211", StoreInfo: &StoreInfo{Name: "店"}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := newTestOrderParams()
+			tt.modify(params)
+			if err := validateCreateOrderParams(params); err == nil {
+				t.Error("expected validation error")
+			}
+		})
 	}
 }
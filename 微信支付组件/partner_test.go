@@ -0,0 +1,91 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestWithSubMerchant(t *testing.T) {
+	var client *Client
+	client, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if mchid, err := verifyTestAuthorization(r, body, &client.privateKey.PublicKey); err != nil || mchid != testMchID {
+			t.Errorf("Partner request not signed by the service provider: %s, %v", mchid, err)
+		}
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "POST /v3/pay/partner/transactions/jsapi":
+			var decoded map[string]interface{}
+			json.Unmarshal(body, &decoded)
+			payer, _ := decoded["payer"].(map[string]interface{})
+			if decoded["sp_appid"] != "wx8888888888888888" || decoded["sp_mchid"] != testMchID ||
+				decoded["sub_mchid"] != "1900000109" || decoded["sub_appid"] != "wxd678efh567hg6999" ||
+				decoded["appid"] != nil || decoded["mchid"] != nil || payer["sub_openid"] != "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o" {
+				t.Errorf("Unexpected partner order body: %s", body)
+			}
+			w.Write([]byte(`{"prepay_id":"wx26112221580621e9b071c00d9e093b0000"}`))
+		case "GET /v3/pay/partner/transactions/out-trade-no/1217752501201407033233368018?sp_mchid=" + testMchID + "&sub_mchid=1900000109":
+			w.Write([]byte(`{"sp_mchid":"1230000109","sub_mchid":"1900000109","out_trade_no":"1217752501201407033233368018","trade_state":"NOTPAY","payer":{"sub_openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}}`))
+		case "POST /v3/pay/partner/transactions/out-trade-no/1217752501201407033233368018/close":
+			var decoded map[string]string
+			json.Unmarshal(body, &decoded)
+			if decoded["sp_mchid"] != testMchID || decoded["sub_mchid"] != "1900000109" || decoded["mchid"] != "" {
+				t.Errorf("Unexpected close body: %s", body)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	partner := client.WithSubMerchant(SubMerchant{MchID: "1900000109", Appid: "wxd678efh567hg6999"})
+	ctx := context.Background()
+
+	if client.isPartner() {
+		t.Fatal("WithSubMerchant must not modify the service provider client")
+	}
+
+	resp, err := partner.CreateOrder(ctx, &CreateOrderParams{
+		TradeType:   TradeTypeJSAPI,
+		Appid:       "wx8888888888888888",
+		Description: "Image形象店-深圳腾大-QQ公仔",
+		OutTradeNo:  "1217752501201407033233368018",
+		NotifyURL:   "https://www.weixin.qq.com/wxpay/pay.php",
+		Amount:      Amount{Total: 100, Currency: "CNY"},
+		Payer:       &Payer{SubOpenid: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+	})
+	if err != nil || resp.PrepayID == "" {
+		t.Fatalf("CreateOrder failed: %+v, %v", resp, err)
+	}
+
+	tx, err := partner.QueryOrder(ctx, "1217752501201407033233368018")
+	if err != nil {
+		t.Fatalf("QueryOrder failed: %v", err)
+	}
+	if tx.SubMchid != "1900000109" || tx.Payer.SubOpenid == "" {
+		t.Errorf("Unexpected partner transaction: %+v", tx)
+	}
+
+	if err := partner.CloseOrder(ctx, "1217752501201407033233368018"); err != nil {
+		t.Errorf("CloseOrder failed: %v", err)
+	}
+}
+
+func TestBuildPayer(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	if _, err := client.buildPayer(&Payer{SubOpenid: "o1"}); err == nil {
+		t.Error("Expected error for sub_openid on a direct merchant client")
+	}
+
+	partner := client.WithSubMerchant(SubMerchant{MchID: "1900000109"})
+	if _, err := partner.buildPayer(&Payer{SubOpenid: "o1"}); err == nil {
+		t.Error("Expected error for sub_openid without sub_appid")
+	}
+	payer, err := partner.buildPayer(&Payer{Openid: "o2"})
+	if err != nil || payer["sp_openid"] != "o2" {
+		t.Errorf("Unexpected payer: %v, %v", payer, err)
+	}
+}
@@ -0,0 +1,390 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Receiver types of a profit-sharing receiver.
+const (
+	ReceiverTypeMerchant       = "MERCHANT_ID"
+	ReceiverTypePersonalOpenid = "PERSONAL_OPENID"
+)
+
+// States of a profit-sharing order and results of each receiver.
+const (
+	ProfitSharingStateProcessing = "PROCESSING"
+	ProfitSharingStateFinished   = "FINISHED"
+
+	ProfitSharingResultPending = "PENDING"
+	ProfitSharingResultSuccess = "SUCCESS"
+	ProfitSharingResultClosed  = "CLOSED"
+)
+
+const maxProfitSharingReceivers = 50
+
+type AddReceiverRequest struct {
+	Appid   string
+	Type    string
+	Account string
+	// Name is required for merchant receivers and optional for personal
+	// ones. It is encrypted with the platform certificate before sending.
+	Name string
+	// RelationType is e.g. SERVICE_PROVIDER, STORE, STAFF, PARTNER or CUSTOM.
+	RelationType   string
+	CustomRelation string
+}
+
+type DeleteReceiverRequest struct {
+	Appid   string
+	Type    string
+	Account string
+}
+
+type CreateProfitSharingOrderRequest struct {
+	Appid         string
+	TransactionID string
+	OutOrderNo    string
+	Receivers     []ProfitSharingOrderReceiver
+	// UnfreezeUnsplit releases what is left of the order to the merchant
+	// once this split is done. Without it the rest stays frozen until
+	// UnfreezeProfitSharing is called.
+	UnfreezeUnsplit bool
+}
+
+type ProfitSharingOrderReceiver struct {
+	Type        string
+	Account     string
+	Name        string
+	Amount      int
+	Description string
+}
+
+type ProfitSharingOrder struct {
+	TransactionID string                        `json:"transaction_id"`
+	OutOrderNo    string                        `json:"out_order_no"`
+	OrderID       string                        `json:"order_id"`
+	State         string                        `json:"state"`
+	Receivers     []ProfitSharingReceiverResult `json:"receivers"`
+}
+
+type ProfitSharingReceiverResult struct {
+	Amount      int    `json:"amount"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Account     string `json:"account"`
+	Result      string `json:"result"`
+	FailReason  string `json:"fail_reason"`
+	DetailID    string `json:"detail_id"`
+	CreateTime  string `json:"create_time"`
+	FinishTime  string `json:"finish_time"`
+}
+
+// ProfitSharingReturnRequest asks a receiver to give back part of a share.
+// Either OrderID or OutOrderNo identifies the sharing order.
+type ProfitSharingReturnRequest struct {
+	OrderID     string
+	OutOrderNo  string
+	OutReturnNo string
+	ReturnMchid string
+	Amount      int
+	Description string
+}
+
+type ProfitSharingReturn struct {
+	OrderID     string `json:"order_id"`
+	OutOrderNo  string `json:"out_order_no"`
+	OutReturnNo string `json:"out_return_no"`
+	ReturnID    string `json:"return_id"`
+	ReturnMchid string `json:"return_mchid"`
+	Amount      int    `json:"amount"`
+	Description string `json:"description"`
+	// Result is PROCESSING, SUCCESS or FAILED.
+	Result     string `json:"result"`
+	FailReason string `json:"fail_reason"`
+	CreateTime string `json:"create_time"`
+	FinishTime string `json:"finish_time"`
+}
+
+type UnfreezeProfitSharingRequest struct {
+	TransactionID string
+	OutOrderNo    string
+	Description   string
+}
+
+type ProfitSharingAmount struct {
+	TransactionID string `json:"transaction_id"`
+	UnsplitAmount int    `json:"unsplit_amount"`
+}
+
+// AddProfitSharingReceiver registers an account the merchant may split
+// order funds to.
+func (c *Client) AddProfitSharingReceiver(ctx context.Context, params *AddReceiverRequest) error {
+	if params.Appid == "" || params.Type == "" || params.Account == "" {
+		return errors.New("appid, type and account are required")
+	}
+	if params.RelationType == "" {
+		return errors.New("relation_type is required")
+	}
+	if params.Type == ReceiverTypeMerchant && params.Name == "" {
+		return errors.New("name is required for merchant receivers")
+	}
+
+	body := map[string]interface{}{
+		"appid":         params.Appid,
+		"type":          params.Type,
+		"account":       params.Account,
+		"relation_type": params.RelationType,
+	}
+	if params.CustomRelation != "" {
+		body["custom_relation"] = params.CustomRelation
+	}
+
+	enc := c.NewSensitiveEncrypter()
+	if params.Name != "" {
+		name, err := enc.Encrypt(ctx, params.Name)
+		if err != nil {
+			return err
+		}
+		body["name"] = name
+	}
+
+	req, err := c.newJSONRequest("POST", "/v3/profitsharing/receivers/add", body, WithSensitiveEncrypter(enc))
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, req, nil)
+}
+
+func (c *Client) DeleteProfitSharingReceiver(ctx context.Context, params *DeleteReceiverRequest) error {
+	if params.Appid == "" || params.Type == "" || params.Account == "" {
+		return errors.New("appid, type and account are required")
+	}
+
+	req, err := c.newJSONRequest("POST", "/v3/profitsharing/receivers/delete", map[string]interface{}{
+		"appid":   params.Appid,
+		"type":    params.Type,
+		"account": params.Account,
+	})
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, req, nil)
+}
+
+// CreateProfitSharingOrder splits the funds of a paid order created with
+// SettleInfo.ProfitSharing. The split is asynchronous: poll
+// QueryProfitSharingOrder or handle the profit-sharing notification.
+func (c *Client) CreateProfitSharingOrder(ctx context.Context, params *CreateProfitSharingOrderRequest) (*ProfitSharingOrder, error) {
+	if err := validateProfitSharingOrder(params); err != nil {
+		return nil, err
+	}
+
+	enc := c.NewSensitiveEncrypter()
+	receivers := make([]map[string]interface{}, 0, len(params.Receivers))
+	for _, r := range params.Receivers {
+		receiver := map[string]interface{}{
+			"type":        r.Type,
+			"account":     r.Account,
+			"amount":      r.Amount,
+			"description": r.Description,
+		}
+		if r.Name != "" {
+			name, err := enc.Encrypt(ctx, r.Name)
+			if err != nil {
+				return nil, err
+			}
+			receiver["name"] = name
+		}
+		receivers = append(receivers, receiver)
+	}
+
+	req, err := c.newJSONRequest("POST", "/v3/profitsharing/orders", map[string]interface{}{
+		"appid":            params.Appid,
+		"transaction_id":   params.TransactionID,
+		"out_order_no":     params.OutOrderNo,
+		"receivers":        receivers,
+		"unfreeze_unsplit": params.UnfreezeUnsplit,
+	}, WithSensitiveEncrypter(enc))
+	if err != nil {
+		return nil, err
+	}
+
+	var order ProfitSharingOrder
+	if err := c.doJSON(ctx, req, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func validateProfitSharingOrder(params *CreateProfitSharingOrderRequest) error {
+	if params.Appid == "" || params.TransactionID == "" || params.OutOrderNo == "" {
+		return errors.New("appid, transaction_id and out_order_no are required")
+	}
+	if len(params.Receivers) == 0 || len(params.Receivers) > maxProfitSharingReceivers {
+		return fmt.Errorf("receivers must have 1 to %d entries", maxProfitSharingReceivers)
+	}
+	for i, r := range params.Receivers {
+		if r.Type == "" || r.Account == "" {
+			return fmt.Errorf("receivers[%d]: type and account are required", i)
+		}
+		if r.Amount <= 0 {
+			return fmt.Errorf("receivers[%d]: amount must be positive", i)
+		}
+		if r.Description == "" || len(r.Description) > 80 {
+			return fmt.Errorf("receivers[%d]: description must be 1 to 80 bytes", i)
+		}
+	}
+	return nil
+}
+
+func (c *Client) QueryProfitSharingOrder(ctx context.Context, transactionID, outOrderNo string) (*ProfitSharingOrder, error) {
+	if transactionID == "" || outOrderNo == "" {
+		return nil, errors.New("transaction_id and out_order_no are required")
+	}
+
+	path := fmt.Sprintf("/v3/profitsharing/orders/%s?transaction_id=%s", url.PathEscape(outOrderNo), url.QueryEscape(transactionID))
+	req, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var order ProfitSharingOrder
+	if err := c.doJSON(ctx, req, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ReturnProfitSharing takes back funds already split to a merchant receiver,
+// typically before refunding the order.
+func (c *Client) ReturnProfitSharing(ctx context.Context, params *ProfitSharingReturnRequest) (*ProfitSharingReturn, error) {
+	if params.OrderID == "" && params.OutOrderNo == "" {
+		return nil, errors.New("order_id or out_order_no is required")
+	}
+	if params.OutReturnNo == "" || params.ReturnMchid == "" {
+		return nil, errors.New("out_return_no and return_mchid are required")
+	}
+	if params.Amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	if params.Description == "" {
+		return nil, errors.New("description is required")
+	}
+
+	body := map[string]interface{}{
+		"out_return_no": params.OutReturnNo,
+		"return_mchid":  params.ReturnMchid,
+		"amount":        params.Amount,
+		"description":   params.Description,
+	}
+	if params.OrderID != "" {
+		body["order_id"] = params.OrderID
+	}
+	if params.OutOrderNo != "" {
+		body["out_order_no"] = params.OutOrderNo
+	}
+
+	req, err := c.newJSONRequest("POST", "/v3/profitsharing/return-orders", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret ProfitSharingReturn
+	if err := c.doJSON(ctx, req, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) QueryProfitSharingReturn(ctx context.Context, outOrderNo, outReturnNo string) (*ProfitSharingReturn, error) {
+	if outOrderNo == "" || outReturnNo == "" {
+		return nil, errors.New("out_order_no and out_return_no are required")
+	}
+
+	path := fmt.Sprintf("/v3/profitsharing/return-orders/%s?out_order_no=%s", url.PathEscape(outReturnNo), url.QueryEscape(outOrderNo))
+	req, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret ProfitSharingReturn
+	if err := c.doJSON(ctx, req, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// UnfreezeProfitSharing ends profit sharing for an order and releases the
+// remaining frozen funds to the merchant.
+func (c *Client) UnfreezeProfitSharing(ctx context.Context, params *UnfreezeProfitSharingRequest) (*ProfitSharingOrder, error) {
+	if params.TransactionID == "" || params.OutOrderNo == "" || params.Description == "" {
+		return nil, errors.New("transaction_id, out_order_no and description are required")
+	}
+
+	req, err := c.newJSONRequest("POST", "/v3/profitsharing/orders/unfreeze", map[string]interface{}{
+		"transaction_id": params.TransactionID,
+		"out_order_no":   params.OutOrderNo,
+		"description":    params.Description,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var order ProfitSharingOrder
+	if err := c.doJSON(ctx, req, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// QueryProfitSharingAmount returns how much of an order can still be split.
+func (c *Client) QueryProfitSharingAmount(ctx context.Context, transactionID string) (*ProfitSharingAmount, error) {
+	if transactionID == "" {
+		return nil, errors.New("transaction_id is required")
+	}
+
+	req, err := c.newRequest("GET", fmt.Sprintf("/v3/profitsharing/transactions/%s/amounts", url.PathEscape(transactionID)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var amount ProfitSharingAmount
+	if err := c.doJSON(ctx, req, &amount); err != nil {
+		return nil, err
+	}
+	return &amount, nil
+}
+
+// ProfitSharingNotification is the decrypted resource of the
+// PROFITSHARING.SUCCESS and PROFITSHARING.CLOSED notifications, sent once
+// per receiver.
+type ProfitSharingNotification struct {
+	Mchid         string                    `json:"mchid"`
+	TransactionID string                    `json:"transaction_id"`
+	OrderID       string                    `json:"order_id"`
+	OutOrderNo    string                    `json:"out_order_no"`
+	Receiver      ProfitSharingNotifyTarget `json:"receiver"`
+	SuccessTime   string                    `json:"success_time"`
+}
+
+type ProfitSharingNotifyTarget struct {
+	Type        string `json:"type"`
+	Account     string `json:"account"`
+	Amount      int    `json:"amount"`
+	Description string `json:"description"`
+}
+
+type ProfitSharingHandlerFunc func(ctx context.Context, notify *NotifyRequest, result *ProfitSharingNotification) error
+
+func NewProfitSharingNotifyHandler(parser *NotifyParser, fn ProfitSharingHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result ProfitSharingNotification
+		serveNotify(w, r, parser, &result, func(notify *NotifyRequest) error {
+			return fn(r.Context(), notify, &result)
+		})
+	})
+}
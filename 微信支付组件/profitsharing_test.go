@@ -0,0 +1,206 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAddProfitSharingReceiver(t *testing.T) {
+	var client *Client
+	var platform *testPlatform
+	client, platform = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/profitsharing/receivers/add" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+		if r.Header.Get("Wechatpay-Serial") != platform.serial {
+			t.Errorf("Expected Wechatpay-Serial %s, got %q", platform.serial, r.Header.Get("Wechatpay-Serial"))
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["type"] != ReceiverTypeMerchant || body["account"] != "86693852" || body["relation_type"] != "STORE" {
+			t.Errorf("Unexpected body: %v", body)
+		}
+		if got := decryptTestSensitive(t, platform.key, body["name"]); got != "深圳市腾讯计算机系统有限公司" {
+			t.Errorf("Unexpected decrypted name: %s", got)
+		}
+		w.Write([]byte(`{"type":"MERCHANT_ID","account":"86693852","relation_type":"STORE"}`))
+	})
+	useTestCertificates(client, platform)
+
+	err := client.AddProfitSharingReceiver(context.Background(), &AddReceiverRequest{
+		Appid:        "wx8888888888888888",
+		Type:         ReceiverTypeMerchant,
+		Account:      "86693852",
+		Name:         "深圳市腾讯计算机系统有限公司",
+		RelationType: "STORE",
+	})
+	if err != nil {
+		t.Fatalf("AddProfitSharingReceiver failed: %v", err)
+	}
+
+	err = client.AddProfitSharingReceiver(context.Background(), &AddReceiverRequest{
+		Appid: "wx8888888888888888", Type: ReceiverTypeMerchant, Account: "86693852", RelationType: "STORE",
+	})
+	if err == nil {
+		t.Error("Expected error for merchant receiver without name")
+	}
+}
+
+func TestProfitSharingOrderLifecycle(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "POST /v3/profitsharing/orders":
+			var req struct {
+				TransactionID   string `json:"transaction_id"`
+				UnfreezeUnsplit bool   `json:"unfreeze_unsplit"`
+				Receivers       []struct {
+					Account string `json:"account"`
+					Amount  int    `json:"amount"`
+				} `json:"receivers"`
+			}
+			json.Unmarshal(body, &req)
+			if req.TransactionID != "4208450740201411110007820472" || !req.UnfreezeUnsplit || len(req.Receivers) != 1 || req.Receivers[0].Amount != 100 {
+				t.Errorf("Unexpected create body: %s", body)
+			}
+			w.Write([]byte(`{"transaction_id":"4208450740201411110007820472","out_order_no":"P20150806125346","order_id":"3008450740201411110007820472","state":"PROCESSING","receivers":[{"amount":100,"description":"分给商户A","type":"PERSONAL_OPENID","account":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o","result":"PENDING","detail_id":"36011111111111111111111"}]}`))
+		case "GET /v3/profitsharing/orders/P20150806125346?transaction_id=4208450740201411110007820472":
+			w.Write([]byte(`{"order_id":"3008450740201411110007820472","state":"FINISHED","receivers":[{"amount":100,"result":"SUCCESS"}]}`))
+		case "POST /v3/profitsharing/return-orders":
+			w.Write([]byte(`{"order_id":"3008450740201411110007820472","out_return_no":"R20190516001","return_mchid":"86693852","amount":10,"result":"PROCESSING"}`))
+		case "GET /v3/profitsharing/return-orders/R20190516001?out_order_no=P20150806125346":
+			w.Write([]byte(`{"out_return_no":"R20190516001","result":"SUCCESS","amount":10}`))
+		case "POST /v3/profitsharing/orders/unfreeze":
+			w.Write([]byte(`{"out_order_no":"P20150806125346","state":"PROCESSING"}`))
+		case "GET /v3/profitsharing/transactions/4208450740201411110007820472/amounts":
+			w.Write([]byte(`{"transaction_id":"4208450740201411110007820472","unsplit_amount":1000}`))
+		case "POST /v3/profitsharing/receivers/delete":
+			w.Write([]byte(`{"type":"PERSONAL_OPENID","account":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}`))
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	ctx := context.Background()
+
+	order, err := client.CreateProfitSharingOrder(ctx, &CreateProfitSharingOrderRequest{
+		Appid:         "wx8888888888888888",
+		TransactionID: "4208450740201411110007820472",
+		OutOrderNo:    "P20150806125346",
+		Receivers: []ProfitSharingOrderReceiver{
+			{Type: ReceiverTypePersonalOpenid, Account: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o", Amount: 100, Description: "分给商户A"},
+		},
+		UnfreezeUnsplit: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateProfitSharingOrder failed: %v", err)
+	}
+	if order.State != ProfitSharingStateProcessing || order.Receivers[0].Result != ProfitSharingResultPending {
+		t.Errorf("Unexpected order: %+v", order)
+	}
+
+	order, err = client.QueryProfitSharingOrder(ctx, "4208450740201411110007820472", "P20150806125346")
+	if err != nil || order.State != ProfitSharingStateFinished {
+		t.Errorf("QueryProfitSharingOrder: %+v, %v", order, err)
+	}
+
+	ret, err := client.ReturnProfitSharing(ctx, &ProfitSharingReturnRequest{
+		OutOrderNo: "P20150806125346", OutReturnNo: "R20190516001", ReturnMchid: "86693852", Amount: 10, Description: "用户退款",
+	})
+	if err != nil || ret.Result != "PROCESSING" || ret.Amount != 10 {
+		t.Errorf("ReturnProfitSharing: %+v, %v", ret, err)
+	}
+
+	ret, err = client.QueryProfitSharingReturn(ctx, "P20150806125346", "R20190516001")
+	if err != nil || ret.Result != "SUCCESS" {
+		t.Errorf("QueryProfitSharingReturn: %+v, %v", ret, err)
+	}
+
+	order, err = client.UnfreezeProfitSharing(ctx, &UnfreezeProfitSharingRequest{
+		TransactionID: "4208450740201411110007820472", OutOrderNo: "P20150806125346", Description: "解冻全部剩余资金",
+	})
+	if err != nil || order.State != ProfitSharingStateProcessing {
+		t.Errorf("UnfreezeProfitSharing: %+v, %v", order, err)
+	}
+
+	amount, err := client.QueryProfitSharingAmount(ctx, "4208450740201411110007820472")
+	if err != nil || amount.UnsplitAmount != 1000 {
+		t.Errorf("QueryProfitSharingAmount: %+v, %v", amount, err)
+	}
+
+	err = client.DeleteProfitSharingReceiver(ctx, &DeleteReceiverRequest{
+		Appid: "wx8888888888888888", Type: ReceiverTypePersonalOpenid, Account: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o",
+	})
+	if err != nil {
+		t.Errorf("DeleteProfitSharingReceiver failed: %v", err)
+	}
+}
+
+func TestValidateProfitSharingOrder(t *testing.T) {
+	valid := func() *CreateProfitSharingOrderRequest {
+		return &CreateProfitSharingOrderRequest{
+			Appid: "wx8888888888888888", TransactionID: "4208450740201411110007820472", OutOrderNo: "P20150806125346",
+			Receivers: []ProfitSharingOrderReceiver{{Type: ReceiverTypeMerchant, Account: "86693852", Amount: 1, Description: "分账"}},
+		}
+	}
+	if err := validateProfitSharingOrder(valid()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*CreateProfitSharingOrderRequest)
+	}{
+		{"no receivers", func(r *CreateProfitSharingOrderRequest) { r.Receivers = nil }},
+		{"too many receivers", func(r *CreateProfitSharingOrderRequest) {
+			r.Receivers = make([]ProfitSharingOrderReceiver, maxProfitSharingReceivers+1)
+		}},
+		{"zero amount", func(r *CreateProfitSharingOrderRequest) { r.Receivers[0].Amount = 0 }},
+		{"no description", func(r *CreateProfitSharingOrderRequest) { r.Receivers[0].Description = "" }},
+		{"no transaction", func(r *CreateProfitSharingOrderRequest) { r.TransactionID = "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid()
+			tt.modify(req)
+			if err := validateProfitSharingOrder(req); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestProfitSharingNotifyHandler(t *testing.T) {
+	parser, key, serial := newTestNotifyParser(t)
+
+	var got *ProfitSharingNotification
+	handler := NewProfitSharingNotifyHandler(parser, func(ctx context.Context, notify *NotifyRequest, result *ProfitSharingNotification) error {
+		got = result
+		return nil
+	})
+
+	req := newTestNotifyRequest(t, key, serial, time.Now(), map[string]interface{}{
+		"mchid":          "1900000100",
+		"transaction_id": "4200000000000000000000000000",
+		"order_id":       "1217752501201407033233368018",
+		"out_order_no":   "P20150806125346",
+		"receiver": map[string]interface{}{
+			"type": "MERCHANT_ID", "account": "1900000109", "amount": 888, "description": "运费/交易分账",
+		},
+		"success_time": "2018-06-08T10:34:56+08:00",
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got == nil || got.OutOrderNo != "P20150806125346" || got.Receiver.Amount != 888 || got.Receiver.Account != "1900000109" {
+		t.Errorf("Unexpected notification: %+v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package wechatpay
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestQRReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, ISO/IEC 18004 worked example.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	got := qrReedSolomonRemainder(data, qrReedSolomonDivisor(len(want)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected ECC %v, got %v", want, got)
+	}
+}
+
+func TestEncodeQRCode_Version(t *testing.T) {
+	tests := []struct {
+		content string
+		level   QRLevel
+		version int
+	}{
+		{"weixin://wxpay/bizpayurl?pr=p4lpSuKzz", QRLevelL, 3},
+		{"weixin://wxpay/bizpayurl?pr=p4lpSuKzz", QRLevelQ, 4},
+		{"weixin://wxpay/bizpayurl?pr=p4lpSuKzz", QRLevelH, 5},
+		{strings.Repeat("a", 2953), QRLevelL, 40},
+	}
+
+	for _, tt := range tests {
+		q, err := EncodeQRCode(tt.content, tt.level)
+		if err != nil {
+			t.Fatalf("EncodeQRCode failed: %v", err)
+		}
+		if q.Version != tt.version || q.Size() != tt.version*4+17 {
+			t.Errorf("Expected version %d, got %d (size %d)", tt.version, q.Version, q.Size())
+		}
+	}
+
+	if _, err := EncodeQRCode(strings.Repeat("a", 2954), QRLevelL); err != ErrQRContentTooLong {
+		t.Errorf("Expected ErrQRContentTooLong, got %v", err)
+	}
+	if _, err := EncodeQRCode("x", QRLevel(9)); err == nil {
+		t.Error("Expected error for invalid level")
+	}
+}
+
+func TestEncodeQRCode_FunctionPatterns(t *testing.T) {
+	q, err := EncodeQRCode("weixin://wxpay/bizpayurl?pr=p4lpSuKzz", QRLevelM)
+	if err != nil {
+		t.Fatalf("EncodeQRCode failed: %v", err)
+	}
+
+	last := q.Size() - 1
+	for _, corner := range [][2]int{{0, 0}, {last - 6, 0}, {0, last - 6}} {
+		for i := 0; i < 7; i++ {
+			if !q.Dark(corner[0]+i, corner[1]) || !q.Dark(corner[0], corner[1]+i) {
+				t.Fatalf("Finder pattern border missing at %v", corner)
+			}
+		}
+		if q.Dark(corner[0]+1, corner[1]+1) || !q.Dark(corner[0]+3, corner[1]+3) {
+			t.Errorf("Finder pattern interior wrong at %v", corner)
+		}
+	}
+	for i := 8; i < q.Size()-8; i++ {
+		if q.Dark(i, 6) != (i%2 == 0) {
+			t.Errorf("Timing pattern wrong at %d", i)
+		}
+	}
+	if !q.Dark(8, q.Size()-8) {
+		t.Error("Dark module missing")
+	}
+}
+
+func TestQRCode_PNG(t *testing.T) {
+	q, _ := EncodeQRCode("weixin://wxpay/bizpayurl?pr=p4lpSuKzz", QRLevelM)
+
+	data, err := q.PNG(256)
+	if err != nil {
+		t.Fatalf("PNG failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode PNG failed: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		t.Errorf("Expected 256x256 image, got %v", b)
+	}
+
+	small := q.Image(10)
+	if b := small.Bounds(); b.Dx() != q.Size()+2*qrQuietZone {
+		t.Errorf("Expected image to grow to one pixel per module, got %v", b)
+	}
+}
+
+func TestQRCode_SVG(t *testing.T) {
+	q, _ := EncodeQRCode("weixin://wxpay/bizpayurl?pr=p4lpSuKzz", QRLevelQ)
+
+	svg := string(q.SVG(300))
+	if !strings.HasPrefix(svg, "<svg") || !strings.HasSuffix(svg, "</svg>") {
+		t.Fatalf("Unexpected SVG: %s", svg)
+	}
+	if !strings.Contains(svg, `width="300"`) || !strings.Contains(svg, "M4,4h1v1h-1z") {
+		t.Errorf("SVG missing size or top-left finder module: %s", svg[:200])
+	}
+}
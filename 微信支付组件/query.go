@@ -2,63 +2,36 @@ package wechatpay
 
 import (
 	"context"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
-	"strings"
-	"time"
+	"net/url"
 )
 
-type Credential struct {
-	MchID      string
-	SerialNo   string
-	PrivateKey *rsa.PrivateKey
-}
-
-type QueryOrderResponse struct {
-	Appid          string `json:"appid"`
-	Mchid          string `json:"mchid"`
-	OutTradeNo     string `json:"out_trade_no"`
-	TransactionID  string `json:"transaction_id"`
-	TradeType      string `json:"trade_type"`
-	TradeState     string `json:"trade_state"`
-	TradeStateDesc string `json:"trade_state_desc"`
-	Amount         struct {
-		Total         int    `json:"total"`
-		Currency      string `json:"currency"`
-		PayerTotal    int    `json:"payer_total"`
-		PayerCurrency string `json:"payer_currency"`
-	} `json:"amount"`
-	Payer struct {
-		Openid string `json:"openid"`
-	} `json:"payer"`
+func (c *Client) QueryOrder(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	if outTradeNo == "" {
+		return nil, errors.New("out_trade_no is required")
+	}
+	return c.queryOrder(ctx, c.transactionsPath()+"/out-trade-no/"+url.PathEscape(outTradeNo))
 }
 
-func QueryOrder(ctx context.Context, mchid string, outTradeNo string, credential *Credential) (*QueryOrderResponse, error) {
-	req, err := buildQueryOrderRequest(mchid, outTradeNo)
-	if err != nil {
-		return nil, err
+// QueryOrderByTransactionID looks an order up by the WeChat Pay transaction_id,
+// for reconciliation records that only carry the WeChat side identifier.
+func (c *Client) QueryOrderByTransactionID(ctx context.Context, transactionID string) (*Transaction, error) {
+	if transactionID == "" {
+		return nil, errors.New("transaction_id is required")
 	}
+	return c.queryOrder(ctx, c.transactionsPath()+"/id/"+url.PathEscape(transactionID))
+}
 
-	timestamp := fmt.Sprintf("%d", time.Now().Unix())
-	nonce := generateNonce(32)
-	signature, err := generateSignature(req, timestamp, nonce, credential.PrivateKey)
+func (c *Client) queryOrder(ctx context.Context, path string) (*Transaction, error) {
+	req, err := c.buildQueryOrderRequest(path)
 	if err != nil {
 		return nil, err
 	}
 
-	authHeader := fmt.Sprintf("WECHATPAY2-SHA256-RSA2048 mchid=\"%s\",nonce_str=\"%s\",signature=\"%s\",timestamp=\"%s\",serial_no=\"%s\"",
-		credential.MchID, nonce, signature, timestamp, credential.SerialNo)
-	req.Header.Set("Authorization", authHeader)
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "WechatPay-Go-Component")
-
-	client := &http.Client{}
-	resp, err := client.Do(req.WithContext(ctx))
+	resp, err := c.doRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -67,57 +40,23 @@ func QueryOrder(ctx context.Context, mchid string, outTradeNo string, credential
 	return parseQueryOrderResponse(resp)
 }
 
-func buildQueryOrderRequest(mchid string, outTradeNo string) (*http.Request, error) {
-	url := fmt.Sprintf("https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/%s?mchid=%s",
-		outTradeNo, mchid)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	return req, nil
+func (c *Client) buildQueryOrderRequest(path string) (*http.Request, error) {
+	return c.newRequest("GET", path+"?"+c.merchantQuery(), nil)
 }
 
-func parseQueryOrderResponse(resp *http.Response) (*QueryOrderResponse, error) {
+func parseQueryOrderResponse(resp *http.Response) (*Transaction, error) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %s, body: %s", resp.Status, string(body))
+		return nil, parseAPIError(resp, body)
 	}
 
-	var orderResp QueryOrderResponse
-	if err := json.Unmarshal(body, &orderResp); err != nil {
+	var transaction Transaction
+	if err := json.Unmarshal(body, &transaction); err != nil {
 		return nil, err
 	}
-	return &orderResp, nil
-}
-
-func generateNonce(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
-	}
-	return string(b)
-}
-
-func generateSignature(req *http.Request, timestamp, nonce string, privateKey *rsa.PrivateKey) (string, error) {
-	signData := buildSignData(req, timestamp, nonce)
-	hashed := sha256.Sum256([]byte(signData))
-	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, hashed[:])
-	if err != nil {
-		return "", err
-	}
-	return base64.StdEncoding.EncodeToString(signature), nil
-}
-
-func buildSignData(req *http.Request, timestamp, nonce string) string {
-	urlParts := strings.Split(req.URL.String(), "://")
-	path := urlParts[1]
-	if len(urlParts) > 1 {
-		path = urlParts[1][strings.Index(urlParts[1], "/"):]
-	}
-	return fmt.Sprintf("%s\n%s\n%s\n%s\n\n", req.Method, path, timestamp, nonce)
+	return &transaction, nil
 }
@@ -1,72 +1,39 @@
-package wechatpay_test
+package wechatpay
 
 import (
 	"context"
-	"crypto/rsa"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"net/http"
-	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
-	"your_module_path/wechatpay"
 )
 
 func TestQueryOrder_Success(t *testing.T) {
-	// 创建模拟服务器
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		expectedPath := fmt.Sprintf("/v3/pay/transactions/out-trade-no/testOrder?mchid=testMchID")
-		if r.URL.Path+r.URL.RawQuery != expectedPath[1:] {
-			t.Errorf("expected path %s, got %s", expectedPath, r.URL.Path+"?"+r.URL.RawQuery)
-		}
-
-		resp := wechatpay.QueryOrderResponse{
-			Appid:          "app123",
-			Mchid:          "testMchID",
-			OutTradeNo:     "testOrder",
-			TransactionID:  "trans123",
-			TradeType:      "JSAPI",
-			TradeState:     "SUCCESS",
-			TradeStateDesc: "支付成功",
-			Amount: struct {
-				Total         int    `json:"total"`
-				Currency      string `json:"currency"`
-				PayerTotal    int    `json:"payer_total"`
-				PayerCurrency string `json:"payer_currency"`
-			}{
-				Total:    100,
-				Currency: "CNY",
-			},
-			Payer: struct {
-				Openid string `json:"openid"`
-			}{
-				Openid: "user123",
-			},
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		expectedURI := "/v3/pay/transactions/out-trade-no/testOrder?mchid=" + testMchID
+		if r.URL.RequestURI() != expectedURI {
+			t.Errorf("expected path %s, got %s", expectedURI, r.URL.RequestURI())
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(resp)
-	}))
-	defer ts.Close()
-
-	// 重写URL构建函数
-	originalBuild := wechatpay.BuildQueryOrderRequest
-	wechatpay.BuildQueryOrderRequest = func(mchid, outTradeNo string) (*http.Request, error) {
-		url := fmt.Sprintf("%s/v3/pay/transactions/out-trade-no/%s?mchid=%s", ts.URL, outTradeNo, mchid)
-		return http.NewRequest("GET", url, nil)
-	}
-	defer func() { wechatpay.BuildQueryOrderRequest = originalBuild }()
-
-	// 准备测试数据
-	cred := &wechatpay.Credential{
-		MchID:      "testMchID",
-		SerialNo:   "serial123",
-		PrivateKey: &rsa.PrivateKey{}, // 实际测试中应使用有效私钥
-	}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"appid":            "app123",
+			"mchid":            testMchID,
+			"out_trade_no":     "testOrder",
+			"transaction_id":   "trans123",
+			"trade_type":       "JSAPI",
+			"trade_state":      "SUCCESS",
+			"trade_state_desc": "支付成功",
+			"amount":           map[string]interface{}{"total": 100, "currency": "CNY"},
+			"payer":            map[string]string{"openid": "user123"},
+		})
+	})
 
 	// 调用被测函数
-	resp, err := wechatpay.QueryOrder(context.Background(), "testMchID", "testOrder", cred)
+	resp, err := client.QueryOrder(context.Background(), "testOrder")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -82,73 +49,53 @@ func TestQueryOrder_Success(t *testing.T) {
 
 func TestQueryOrder_HTTPError(t *testing.T) {
 	// 创建模拟服务器返回错误
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Request-ID", "08F78BB5AF0610D302A5BB7506A5FF2D")
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("server error"))
-	}))
-	defer ts.Close()
+	})
 
-	// 重写URL构建函数
-	originalBuild := wechatpay.BuildQueryOrderRequest
-	wechatpay.BuildQueryOrderRequest = func(mchid, outTradeNo string) (*http.Request, error) {
-		url := fmt.Sprintf("%s/v3/pay/transactions/out-trade-no/%s?mchid=%s", ts.URL, outTradeNo, mchid)
-		return http.NewRequest("GET", url, nil)
-	}
-	defer func() { wechatpay.BuildQueryOrderRequest = originalBuild }()
-
-	cred := &wechatpay.Credential{
-		MchID:      "testMchID",
-		SerialNo:   "serial123",
-		PrivateKey: &rsa.PrivateKey{},
-	}
-
-	_, err := wechatpay.QueryOrder(context.Background(), "testMchID", "testOrder", cred)
+	_, err := client.QueryOrder(context.Background(), "testOrder")
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
 
-	expectedErr := "HTTP error: 500 Internal Server Error, body: server error"
-	if err.Error() != expectedErr {
-		t.Errorf("expected error '%s', got '%v'", expectedErr, err)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %T", err)
+	}
+	if apiErr.StatusCode != 500 || apiErr.Body != "server error" || apiErr.RequestID != "08F78BB5AF0610D302A5BB7506A5FF2D" {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+	if !IsRetryable(err) {
+		t.Error("expected 500 to be retryable")
 	}
 }
 
 func TestQueryOrder_InvalidJSON(t *testing.T) {
 	// 创建返回无效JSON的模拟服务器
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("{invalid json}"))
-	}))
-	defer ts.Close()
+	})
 
-	// 重写URL构建函数
-	originalBuild := wechatpay.BuildQueryOrderRequest
-	wechatpay.BuildQueryOrderRequest = func(mchid, outTradeNo string) (*http.Request, error) {
-		url := fmt.Sprintf("%s/v3/pay/transactions/out-trade-no/%s?mchid=%s", ts.URL, outTradeNo, mchid)
-		return http.NewRequest("GET", url, nil)
-	}
-	defer func() { wechatpay.BuildQueryOrderRequest = originalBuild }()
-
-	cred := &wechatpay.Credential{
-		MchID:      "testMchID",
-		SerialNo:   "serial123",
-		PrivateKey: &rsa.PrivateKey{},
-	}
-
-	_, err := wechatpay.QueryOrder(context.Background(), "testMchID", "testOrder", cred)
+	_, err := client.QueryOrder(context.Background(), "testOrder")
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
 }
 
 func TestBuildQueryOrderRequest(t *testing.T) {
-	req, err := wechatpay.BuildQueryOrderRequest("mch123", "order456")
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	client.baseURL = defaultBaseURL
+
+	req, err := client.buildQueryOrderRequest("/v3/pay/transactions/out-trade-no/order456")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	expectedURL := "https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/order456?mchid=mch123"
+	expectedURL := "https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/order456?mchid=" + testMchID
 	if req.URL.String() != expectedURL {
 		t.Errorf("expected URL %s, got %s", expectedURL, req.URL.String())
 	}
@@ -163,7 +110,7 @@ func TestParseQueryOrderResponse_Success(t *testing.T) {
 		Body:       mockBody(`{"appid":"app789","out_trade_no":"order123","amount":{"total":200}}`),
 	}
 
-	result, err := wechatpay.ParseQueryOrderResponse(resp)
+	result, err := parseQueryOrderResponse(resp)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -179,17 +126,18 @@ func TestParseQueryOrderResponse_Success(t *testing.T) {
 func TestParseQueryOrderResponse_ErrorStatus(t *testing.T) {
 	resp := &http.Response{
 		StatusCode: http.StatusBadRequest,
-		Body:       mockBody(`{"code":"INVALID_REQUEST"}`),
+		Status:     "400 Bad Request",
+		Header:     http.Header{},
+		Body:       mockBody(`{"code":"ORDERNOTEXIST","message":"订单不存在"}`),
 	}
 
-	_, err := wechatpay.ParseQueryOrderResponse(resp)
+	_, err := parseQueryOrderResponse(resp)
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
 
-	expectedErr := "HTTP error: 400 Bad Request, body: {\"code\":\"INVALID_REQUEST\"}"
-	if err.Error() != expectedErr {
-		t.Errorf("expected error '%s', got '%v'", expectedErr, err)
+	if !errors.Is(err, ErrOrderNotExist) {
+		t.Errorf("expected ErrOrderNotExist, got '%v'", err)
 	}
 }
 
@@ -197,17 +145,55 @@ func mockBody(content string) io.ReadCloser {
 	return io.NopCloser(strings.NewReader(content))
 }
 
-func TestGenerateNonce(t *testing.T) {
-	nonce := wechatpay.GenerateNonce(16)
-	if len(nonce) != 16 {
-		t.Errorf("expected length 16, got %d", len(nonce))
+func TestQueryOrderByTransactionID(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		expectedURI := "/v3/pay/transactions/id/4200000985202103031441826014?mchid=" + testMchID
+		if r.URL.RequestURI() != expectedURI {
+			t.Errorf("expected path %s, got %s", expectedURI, r.URL.RequestURI())
+		}
+		w.Write([]byte(`{
+			"mchid": "1230000109",
+			"out_trade_no": "1217752501201407033233368018",
+			"transaction_id": "4200000985202103031441826014",
+			"trade_type": "JSAPI",
+			"trade_state": "SUCCESS",
+			"bank_type": "CMC",
+			"attach": "自定义数据",
+			"success_time": "2018-06-08T10:34:56+08:00",
+			"payer": {"openid": "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+			"amount": {"total": 100, "payer_total": 90, "currency": "CNY", "payer_currency": "CNY"},
+			"scene_info": {"device_id": "013467007045764"},
+			"promotion_detail": [{
+				"coupon_id": "109519",
+				"name": "单品惠-6",
+				"scope": "SINGLE",
+				"type": "DISCOUNT",
+				"amount": 10,
+				"stock_id": "931386",
+				"wechatpay_contribute": 0,
+				"merchant_contribute": 10,
+				"other_contribute": 0,
+				"currency": "CNY",
+				"goods_detail": [{"goods_id": "M1006", "quantity": 1, "unit_price": 100, "discount_amount": 10}]
+			}]
+		}`))
+	})
+
+	tx, err := client.QueryOrderByTransactionID(context.Background(), "4200000985202103031441826014")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tx.BankType != "CMC" || tx.Attach != "自定义数据" || tx.SuccessTime == "" || tx.SceneInfo == nil || tx.SceneInfo.DeviceID != "013467007045764" {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+	if len(tx.PromotionDetail) != 1 || tx.PromotionDetail[0].MerchantContribute != 10 || tx.PromotionDetail[0].GoodsDetail[0].GoodsID != "M1006" {
+		t.Errorf("unexpected promotion_detail: %+v", tx.PromotionDetail)
+	}
+	if !tx.Final() {
+		t.Error("expected SUCCESS to be final")
 	}
 
-	// 验证字符集
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	for _, c := range nonce {
-		if !strings.ContainsRune(charset, c) {
-			t.Errorf("invalid character in nonce: %c", c)
-		}
+	if _, err := client.QueryOrderByTransactionID(context.Background(), ""); err == nil {
+		t.Error("expected error for empty transaction_id")
 	}
 }
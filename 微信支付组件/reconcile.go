@@ -0,0 +1,290 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Mismatch kinds reported by the Reconciler.
+const (
+	MismatchMissingLocal  = "MISSING_LOCAL"
+	MismatchMissingWechat = "MISSING_WECHAT"
+	MismatchAmount        = "AMOUNT"
+	MismatchStatus        = "STATUS"
+)
+
+// ReconcileErrorKind is the kind column of ReconcileReport.Errors in CSV.
+const ReconcileErrorKind = "ERROR"
+
+// LocalOrder is the merchant's own record of an order. Total is in fen and
+// TradeState uses the WeChat Pay trade states.
+type LocalOrder struct {
+	OutTradeNo    string
+	TransactionID string
+	Total         int64
+	TradeState    string
+}
+
+// OrderSource supplies the local orders created on a bill date (2006-01-02).
+type OrderSource interface {
+	LocalOrders(ctx context.Context, billDate string) ([]LocalOrder, error)
+}
+
+// OrderFinder is optionally implemented by an OrderSource to look up a
+// single local order. An order created before midnight and paid after it is
+// billed on the next day; without a finder it is reported as MISSING_LOCAL
+// there. FindLocalOrder returns nil when the order does not exist.
+type OrderFinder interface {
+	FindLocalOrder(ctx context.Context, outTradeNo string) (*LocalOrder, error)
+}
+
+type Mismatch struct {
+	Kind          string `json:"kind"`
+	OutTradeNo    string `json:"out_trade_no"`
+	TransactionID string `json:"transaction_id,omitempty"`
+	LocalAmount   int64  `json:"local_amount"`
+	WechatAmount  int64  `json:"wechat_amount"`
+	LocalState    string `json:"local_state,omitempty"`
+	WechatState   string `json:"wechat_state,omitempty"`
+}
+
+// ReconcileError records a local order that could not be checked, for
+// example because QueryOrder failed.
+type ReconcileError struct {
+	OutTradeNo string `json:"out_trade_no"`
+	Message    string `json:"message"`
+}
+
+type ReconcileReport struct {
+	BillDate   string     `json:"bill_date"`
+	LocalCount int        `json:"local_count"`
+	BillCount  int        `json:"bill_count"`
+	Matched    int        `json:"matched"`
+	Mismatches []Mismatch `json:"mismatches"`
+	// Errors lists the orders that could not be checked; they are neither
+	// matched nor mismatched and should be reconciled again later.
+	Errors []ReconcileError `json:"errors,omitempty"`
+}
+
+// Reconciler compares local orders with the trade bill of a day.
+type Reconciler struct {
+	client *Client
+	orders OrderSource
+}
+
+func NewReconciler(client *Client, orders OrderSource) *Reconciler {
+	return &Reconciler{client: client, orders: orders}
+}
+
+// Reconcile downloads the ALL trade bill of billDate and compares it with
+// the local orders of the same day.
+func (r *Reconciler) Reconcile(ctx context.Context, billDate string) (*ReconcileReport, error) {
+	bill, err := r.client.DownloadTradeBill(ctx, &TradeBillRequest{BillDate: billDate, BillType: BillTypeAll, TarType: TarTypeGzip})
+	if err != nil {
+		return nil, err
+	}
+	return r.ReconcileBill(ctx, billDate, bill)
+}
+
+// ReconcileBill compares an already downloaded bill with the local orders.
+// Local orders that look paid but are absent from the bill are looked up
+// with QueryOrder first: orders paid just before midnight are billed on the
+// day they succeeded, which is not a discrepancy. Orders whose lookup fails
+// are listed in the report's Errors instead of aborting the reconciliation.
+func (r *Reconciler) ReconcileBill(ctx context.Context, billDate string, bill *TradeBill) (*ReconcileReport, error) {
+	locals, err := r.orders.LocalOrders(ctx, billDate)
+	if err != nil {
+		return nil, err
+	}
+
+	billed := summarizeBill(bill)
+	report := &ReconcileReport{
+		BillDate:   billDate,
+		LocalCount: len(locals),
+		BillCount:  len(billed),
+		Mismatches: []Mismatch{},
+	}
+
+	seen := make(map[string]bool, len(locals))
+	for _, local := range locals {
+		seen[local.OutTradeNo] = true
+
+		remote, ok := billed[local.OutTradeNo]
+		if !ok {
+			mismatch, err := r.checkUnbilled(ctx, billDate, local)
+			if err != nil {
+				report.addError(local.OutTradeNo, err)
+				continue
+			}
+			if mismatch != nil {
+				report.Mismatches = append(report.Mismatches, *mismatch)
+			}
+			continue
+		}
+		report.compare(local, remote)
+	}
+
+	finder, _ := r.orders.(OrderFinder)
+	for outTradeNo, remote := range billed {
+		if seen[outTradeNo] {
+			continue
+		}
+		if finder != nil {
+			local, err := finder.FindLocalOrder(ctx, outTradeNo)
+			if err != nil {
+				report.addError(outTradeNo, err)
+				continue
+			}
+			if local != nil {
+				report.compare(*local, remote)
+				continue
+			}
+		}
+		report.Mismatches = append(report.Mismatches, Mismatch{
+			Kind:          MismatchMissingLocal,
+			OutTradeNo:    outTradeNo,
+			TransactionID: remote.transactionID,
+			WechatAmount:  remote.total,
+			WechatState:   remote.state,
+		})
+	}
+
+	sort.SliceStable(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].OutTradeNo < report.Mismatches[j].OutTradeNo
+	})
+	return report, nil
+}
+
+// compare reports the amount and state differences between a local order
+// and its bill entry, or counts it as matched.
+func (r *ReconcileReport) compare(local LocalOrder, remote *billedOrder) {
+	mismatch := Mismatch{
+		OutTradeNo:    local.OutTradeNo,
+		TransactionID: remote.transactionID,
+		LocalAmount:   local.Total,
+		WechatAmount:  remote.total,
+		LocalState:    local.TradeState,
+		WechatState:   remote.state,
+	}
+	matched := true
+	if local.Total != remote.total {
+		mismatch.Kind = MismatchAmount
+		r.Mismatches = append(r.Mismatches, mismatch)
+		matched = false
+	}
+	if local.TradeState != remote.state {
+		mismatch.Kind = MismatchStatus
+		r.Mismatches = append(r.Mismatches, mismatch)
+		matched = false
+	}
+	if matched {
+		r.Matched++
+	}
+}
+
+func (r *ReconcileReport) addError(outTradeNo string, err error) {
+	r.Errors = append(r.Errors, ReconcileError{OutTradeNo: outTradeNo, Message: err.Error()})
+}
+
+func (r *Reconciler) checkUnbilled(ctx context.Context, billDate string, local LocalOrder) (*Mismatch, error) {
+	if local.TradeState != TradeStateSuccess && local.TradeState != TradeStateRefund {
+		return nil, nil
+	}
+
+	mismatch := &Mismatch{
+		Kind:          MismatchMissingWechat,
+		OutTradeNo:    local.OutTradeNo,
+		TransactionID: local.TransactionID,
+		LocalAmount:   local.Total,
+		LocalState:    local.TradeState,
+	}
+	if r.client == nil {
+		return mismatch, nil
+	}
+
+	tx, err := r.client.QueryOrder(ctx, local.OutTradeNo)
+	if errors.Is(err, ErrOrderNotExist) {
+		return mismatch, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if successTime, err := time.Parse(time.RFC3339, tx.SuccessTime); err == nil {
+		if successTime.In(cstLocation).Format(billDateLayout) != billDate {
+			return nil, nil
+		}
+	}
+	mismatch.TransactionID = tx.TransactionID
+	mismatch.WechatAmount = int64(tx.Amount.Total)
+	mismatch.WechatState = tx.TradeState
+	return mismatch, nil
+}
+
+type billedOrder struct {
+	transactionID string
+	total         int64
+	state         string
+}
+
+// summarizeBill folds the payment and refund lines of each order into one
+// entry. An order with any refund line is reported as REFUND, matching what
+// QueryOrder returns for it. Refund lines of orders paid on an earlier day
+// are dropped, since those orders belong to another day's reconciliation.
+func summarizeBill(bill *TradeBill) map[string]*billedOrder {
+	orders := make(map[string]*billedOrder)
+	paid := make(map[string]bool)
+	for _, row := range bill.Rows {
+		order, ok := orders[row.OutTradeNo]
+		if !ok {
+			order = &billedOrder{transactionID: row.TransactionID, state: TradeStateSuccess}
+			orders[row.OutTradeNo] = order
+		}
+		if row.TradeState == TradeStateRefund {
+			order.state = TradeStateRefund
+		} else {
+			order.total = row.Total
+			paid[row.OutTradeNo] = true
+		}
+	}
+	for outTradeNo := range orders {
+		if !paid[outTradeNo] {
+			delete(orders, outTradeNo)
+		}
+	}
+	return orders
+}
+
+// HasMismatches reports whether the reconciliation found any discrepancy.
+func (r *ReconcileReport) HasMismatches() bool {
+	return len(r.Mismatches) > 0
+}
+
+// WriteCSV writes the mismatches as CSV with a header line, followed by the
+// orders that could not be checked as rows of kind ERROR with a message.
+func (r *ReconcileReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"bill_date", "kind", "out_trade_no", "transaction_id", "local_amount", "wechat_amount", "local_state", "wechat_state", "message"})
+	for _, m := range r.Mismatches {
+		writer.Write([]string{
+			r.BillDate,
+			m.Kind,
+			m.OutTradeNo,
+			m.TransactionID,
+			strconv.FormatInt(m.LocalAmount, 10),
+			strconv.FormatInt(m.WechatAmount, 10),
+			m.LocalState,
+			m.WechatState,
+			"",
+		})
+	}
+	for _, e := range r.Errors {
+		writer.Write([]string{r.BillDate, ReconcileErrorKind, e.OutTradeNo, "", "", "", "", "", e.Message})
+	}
+	writer.Flush()
+	return writer.Error()
+}
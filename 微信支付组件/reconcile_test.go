@@ -0,0 +1,157 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type staticOrders []LocalOrder
+
+func (s staticOrders) LocalOrders(ctx context.Context, billDate string) ([]LocalOrder, error) {
+	return s, nil
+}
+
+func newTestBill() *TradeBill {
+	return &TradeBill{Rows: []TradeBillRow{
+		{OutTradeNo: "matched", TransactionID: "4200000001", TradeState: "SUCCESS", Total: 100},
+		{OutTradeNo: "amount", TransactionID: "4200000002", TradeState: "SUCCESS", Total: 200},
+		{OutTradeNo: "refunded", TransactionID: "4200000003", TradeState: "SUCCESS", Total: 300},
+		{OutTradeNo: "refunded", TransactionID: "4200000003", TradeState: "REFUND", RefundApplied: 300},
+		{OutTradeNo: "unknown", TransactionID: "4200000004", TradeState: "SUCCESS", Total: 400},
+		{OutTradeNo: "old-order", TransactionID: "4200000005", TradeState: "REFUND", RefundApplied: 500},
+	}}
+}
+
+func TestReconcileBill(t *testing.T) {
+	queried := map[string]bool{}
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		outTradeNo := strings.TrimPrefix(r.URL.Path, "/v3/pay/transactions/out-trade-no/")
+		queried[outTradeNo] = true
+		switch outTradeNo {
+		case "ghost":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"ORDERNOTEXIST","message":"订单不存在"}`))
+		case "broken":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":"PARAM_ERROR","message":"参数错误"}`))
+		case "late":
+			w.Write([]byte(`{"out_trade_no":"late","trade_state":"SUCCESS","success_time":"2019-06-12T00:00:03+08:00","amount":{"total":600}}`))
+		default:
+			w.Write([]byte(`{"out_trade_no":"lost","transaction_id":"4200000007","trade_state":"NOTPAY","amount":{"total":700}}`))
+		}
+	})
+
+	orders := staticOrders{
+		{OutTradeNo: "matched", Total: 100, TradeState: "SUCCESS"},
+		{OutTradeNo: "amount", Total: 250, TradeState: "SUCCESS"},
+		{OutTradeNo: "refunded", Total: 300, TradeState: "SUCCESS"},
+		{OutTradeNo: "late", Total: 600, TradeState: "SUCCESS"},
+		{OutTradeNo: "lost", Total: 700, TradeState: "SUCCESS"},
+		{OutTradeNo: "unpaid", Total: 800, TradeState: "NOTPAY"},
+		{OutTradeNo: "ghost", TransactionID: "4200000009", Total: 900, TradeState: "SUCCESS"},
+		{OutTradeNo: "broken", Total: 1000, TradeState: "SUCCESS"},
+	}
+
+	report, err := NewReconciler(client, orders).ReconcileBill(context.Background(), "2019-06-11", newTestBill())
+	if err != nil {
+		t.Fatalf("ReconcileBill failed: %v", err)
+	}
+
+	if report.LocalCount != 8 || report.BillCount != 4 || report.Matched != 1 {
+		t.Errorf("Unexpected counts: %+v", report)
+	}
+	want := []Mismatch{
+		{Kind: MismatchAmount, OutTradeNo: "amount", TransactionID: "4200000002", LocalAmount: 250, WechatAmount: 200, LocalState: "SUCCESS", WechatState: "SUCCESS"},
+		{Kind: MismatchMissingWechat, OutTradeNo: "ghost", TransactionID: "4200000009", LocalAmount: 900, LocalState: "SUCCESS"},
+		{Kind: MismatchMissingWechat, OutTradeNo: "lost", TransactionID: "4200000007", LocalAmount: 700, WechatAmount: 700, LocalState: "SUCCESS", WechatState: "NOTPAY"},
+		{Kind: MismatchStatus, OutTradeNo: "refunded", TransactionID: "4200000003", LocalAmount: 300, WechatAmount: 300, LocalState: "SUCCESS", WechatState: "REFUND"},
+		{Kind: MismatchMissingLocal, OutTradeNo: "unknown", TransactionID: "4200000004", WechatAmount: 400, WechatState: "SUCCESS"},
+	}
+	if len(report.Mismatches) != len(want) {
+		t.Fatalf("Expected %d mismatches, got %+v", len(want), report.Mismatches)
+	}
+	for i := range want {
+		if report.Mismatches[i] != want[i] {
+			t.Errorf("Mismatch %d: expected %+v, got %+v", i, want[i], report.Mismatches[i])
+		}
+	}
+	if queried["unpaid"] || !queried["late"] {
+		t.Errorf("Unexpected queries: %v", queried)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].OutTradeNo != "broken" || !strings.Contains(report.Errors[0].Message, "PARAM_ERROR") {
+		t.Errorf("Unexpected errors: %+v", report.Errors)
+	}
+	if !report.HasMismatches() {
+		t.Error("Expected HasMismatches")
+	}
+}
+
+// findableOrders also looks up orders created on other days.
+type findableOrders struct {
+	staticOrders
+	all map[string]LocalOrder
+}
+
+func (f findableOrders) FindLocalOrder(ctx context.Context, outTradeNo string) (*LocalOrder, error) {
+	if order, ok := f.all[outTradeNo]; ok {
+		return &order, nil
+	}
+	return nil, nil
+}
+
+func TestReconcileBill_OrderFinder(t *testing.T) {
+	// order created the day before and paid after midnight
+	orders := findableOrders{
+		staticOrders: staticOrders{{OutTradeNo: "matched", Total: 100, TradeState: "SUCCESS"}},
+		all: map[string]LocalOrder{
+			"unknown": {OutTradeNo: "unknown", Total: 400, TradeState: "SUCCESS"},
+		},
+	}
+	rows := newTestBill().Rows
+	bill := &TradeBill{Rows: []TradeBillRow{rows[0], rows[4], {OutTradeNo: "stranger", TransactionID: "4200000006", TradeState: "SUCCESS", Total: 600}}}
+
+	report, err := NewReconciler(nil, orders).ReconcileBill(context.Background(), "2019-06-11", bill)
+	if err != nil {
+		t.Fatalf("ReconcileBill failed: %v", err)
+	}
+	if report.Matched != 2 {
+		t.Errorf("Expected 2 matched orders, got %+v", report)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Kind != MismatchMissingLocal || report.Mismatches[0].OutTradeNo != "stranger" {
+		t.Errorf("Unexpected mismatches: %+v", report.Mismatches)
+	}
+}
+
+func TestReconcileReport_Serialize(t *testing.T) {
+	report := &ReconcileReport{
+		BillDate: "2019-06-11",
+		Mismatches: []Mismatch{
+			{Kind: MismatchAmount, OutTradeNo: "amount", TransactionID: "4200000002", LocalAmount: 250, WechatAmount: 200, LocalState: "SUCCESS", WechatState: "SUCCESS"},
+		},
+		Errors: []ReconcileError{{OutTradeNo: "broken", Message: "wechat pay error: PARAM_ERROR"}},
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	wantCSV := "bill_date,kind,out_trade_no,transaction_id,local_amount,wechat_amount,local_state,wechat_state,message\n" +
+		"2019-06-11,AMOUNT,amount,4200000002,250,200,SUCCESS,SUCCESS,\n" +
+		"2019-06-11,ERROR,broken,,,,,,wechat pay error: PARAM_ERROR\n"
+	if buf.String() != wantCSV {
+		t.Errorf("Unexpected CSV:\n%s", buf.String())
+	}
+
+	encoded, _ := json.Marshal(report)
+	var decoded ReconcileReport
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.BillDate != "2019-06-11" || len(decoded.Mismatches) != 1 || decoded.Mismatches[0] != report.Mismatches[0] || len(decoded.Errors) != 1 {
+		t.Errorf("Unexpected JSON round trip: %s", encoded)
+	}
+}
@@ -0,0 +1,656 @@
+package wechatpay
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sandboxMaxSkew = 5 * time.Minute
+
+// SandboxConfig describes the merchant a Sandbox accepts requests from.
+type SandboxConfig struct {
+	MchID    string
+	APIv3Key string
+	// MerchantKey is the public half of the key the client signs with.
+	MerchantKey *rsa.PublicKey
+}
+
+// Sandbox is an in-process fake of the WeChat Pay v3 API for tests and
+// offline development. It checks the Authorization signature of every
+// request, keeps orders and refunds in memory, signs its responses with a
+// generated platform key served from /v3/certificates and posts encrypted
+// notifications to notify_url. Payments and refund results happen only when
+// the caller drives them with Pay and CompleteRefund.
+//
+// Point a Client at it with Config.BaseURL = sandbox.URL().
+type Sandbox struct {
+	mchID          string
+	apiV3Key       string
+	merchantKey    *rsa.PublicKey
+	platformKey    *rsa.PrivateKey
+	platformCert   *x509.Certificate
+	platformSerial string
+	notifyClient   *http.Client
+
+	listener net.Listener
+	server   *http.Server
+
+	mu      sync.Mutex
+	seq     int
+	orders  map[string]*sandboxOrder
+	refunds map[string]*sandboxRefund
+}
+
+type sandboxOrder struct {
+	tx        Transaction
+	notifyURL string
+	prepayID  string
+	refunded  int64
+}
+
+type sandboxRefund struct {
+	RefundID            string              `json:"refund_id"`
+	OutRefundNo         string              `json:"out_refund_no"`
+	TransactionID       string              `json:"transaction_id"`
+	OutTradeNo          string              `json:"out_trade_no"`
+	Channel             string              `json:"channel"`
+	UserReceivedAccount string              `json:"user_received_account"`
+	SuccessTime         string              `json:"success_time,omitempty"`
+	CreateTime          string              `json:"create_time"`
+	Status              string              `json:"status"`
+	FundsAccount        string              `json:"funds_account"`
+	Amount              sandboxRefundAmount `json:"amount"`
+
+	notifyURL string
+}
+
+type sandboxRefundAmount struct {
+	Total            int64  `json:"total"`
+	Refund           int64  `json:"refund"`
+	PayerTotal       int64  `json:"payer_total"`
+	PayerRefund      int64  `json:"payer_refund"`
+	SettlementRefund int64  `json:"settlement_refund"`
+	SettlementTotal  int64  `json:"settlement_total"`
+	DiscountRefund   int64  `json:"discount_refund"`
+	Currency         string `json:"currency"`
+}
+
+// NewSandbox starts a sandbox on a loopback port. Call Close when done.
+func NewSandbox(config *SandboxConfig) (*Sandbox, error) {
+	if config.MchID == "" || config.MerchantKey == nil {
+		return nil, errors.New("mchid and merchant key are required")
+	}
+	if len(config.APIv3Key) != 32 {
+		return nil, errors.New("apiv3 key must be 32 bytes")
+	}
+
+	platformKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "Tenpay.com Sandbox", Organization: []string{"Tenpay.com"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &platformKey.PublicKey, platformKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sandbox{
+		mchID:          config.MchID,
+		apiV3Key:       config.APIv3Key,
+		merchantKey:    config.MerchantKey,
+		platformKey:    platformKey,
+		platformCert:   cert,
+		platformSerial: CertificateSerial(cert),
+		notifyClient:   &http.Client{Timeout: 10 * time.Second},
+		listener:       listener,
+		orders:         make(map[string]*sandboxOrder),
+		refunds:        make(map[string]*sandboxRefund),
+	}
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// URL is the base URL to use as Config.BaseURL.
+func (s *Sandbox) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+func (s *Sandbox) Close() error {
+	return s.server.Close()
+}
+
+// PlatformCertificate is the certificate the sandbox signs with, for
+// clients that are configured with a fixed Verifier.
+func (s *Sandbox) PlatformCertificate() *x509.Certificate {
+	return s.platformCert
+}
+
+// Order returns a copy of the sandbox's view of an order.
+func (s *Sandbox) Order(outTradeNo string) (Transaction, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[outTradeNo]
+	if !ok {
+		return Transaction{}, false
+	}
+	return order.tx, true
+}
+
+// Pay completes an unpaid order as if openid had paid it, then posts the
+// TRANSACTION.SUCCESS notification. The returned error reports a refused
+// or failed notification.
+func (s *Sandbox) Pay(outTradeNo, openid string) error {
+	s.mu.Lock()
+	order, ok := s.orders[outTradeNo]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("sandbox: order %s does not exist", outTradeNo)
+	}
+	if order.tx.TradeState != TradeStateNotPay && order.tx.TradeState != TradeStateUserPaying {
+		s.mu.Unlock()
+		return fmt.Errorf("sandbox: order %s is %s", outTradeNo, order.tx.TradeState)
+	}
+	s.seq++
+	order.tx.TransactionID = fmt.Sprintf("4200%024d", s.seq)
+	order.tx.TradeState = TradeStateSuccess
+	order.tx.TradeStateDesc = "支付成功"
+	order.tx.BankType = "OTHERS"
+	order.tx.SuccessTime = time.Now().In(cstLocation).Format(time.RFC3339)
+	order.tx.Amount.PayerTotal = order.tx.Amount.Total
+	order.tx.Amount.PayerCurrency = order.tx.Amount.Currency
+	if openid != "" {
+		order.tx.Payer.Openid = openid
+	}
+	tx, notifyURL := order.tx, order.notifyURL
+	s.mu.Unlock()
+
+	return s.notify(notifyURL, "TRANSACTION.SUCCESS", "支付成功", "transaction", tx)
+}
+
+// CompleteRefund moves a PROCESSING refund to status (SUCCESS, CLOSED or
+// ABNORMAL) and posts the matching REFUND.* notification.
+func (s *Sandbox) CompleteRefund(outRefundNo, status string) error {
+	if status != "SUCCESS" && status != "CLOSED" && status != "ABNORMAL" {
+		return fmt.Errorf("sandbox: unsupported refund status %s", status)
+	}
+
+	s.mu.Lock()
+	refund, ok := s.refunds[outRefundNo]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("sandbox: refund %s does not exist", outRefundNo)
+	}
+	if refund.Status != "PROCESSING" {
+		s.mu.Unlock()
+		return fmt.Errorf("sandbox: refund %s is %s", outRefundNo, refund.Status)
+	}
+	refund.Status = status
+	switch status {
+	case "SUCCESS":
+		refund.SuccessTime = time.Now().In(cstLocation).Format(time.RFC3339)
+	case "CLOSED":
+		s.orders[refund.OutTradeNo].refunded -= refund.Amount.Refund
+	}
+	resource := map[string]interface{}{
+		"mchid":                 s.mchID,
+		"out_trade_no":          refund.OutTradeNo,
+		"transaction_id":        refund.TransactionID,
+		"out_refund_no":         refund.OutRefundNo,
+		"refund_id":             refund.RefundID,
+		"refund_status":         refund.Status,
+		"success_time":          refund.SuccessTime,
+		"user_received_account": refund.UserReceivedAccount,
+		"amount": map[string]int64{
+			"total":        refund.Amount.Total,
+			"refund":       refund.Amount.Refund,
+			"payer_total":  refund.Amount.PayerTotal,
+			"payer_refund": refund.Amount.PayerRefund,
+		},
+	}
+	notifyURL := refund.notifyURL
+	s.mu.Unlock()
+
+	return s.notify(notifyURL, "REFUND."+status, "退款状态变更", "refund", resource)
+}
+
+// notify encrypts resource with the APIv3 key and posts it, signed, to
+// notifyURL. An empty notifyURL sends nothing.
+func (s *Sandbox) notify(notifyURL, eventType, summary, originalType string, resource interface{}) error {
+	if notifyURL == "" {
+		return nil
+	}
+	plaintext, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	nonce := generateNonce()[:12]
+	ciphertext, err := encryptAES256GCM(s.apiV3Key, originalType, nonce, plaintext)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.seq++
+	id := fmt.Sprintf("sandbox-%d", s.seq)
+	s.mu.Unlock()
+
+	body, err := json.Marshal(NotifyRequest{
+		ID:           id,
+		CreateTime:   time.Now().In(cstLocation).Format(time.RFC3339),
+		EventType:    eventType,
+		ResourceType: "encrypt-resource",
+		Summary:      summary,
+		Resource: &NotifyResource{
+			Algorithm:      notifyAlgorithm,
+			Ciphertext:     ciphertext,
+			AssociatedData: originalType,
+			OriginalType:   originalType,
+			Nonce:          nonce,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", notifyURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := s.signHeader(req.Header, body); err != nil {
+		return err
+	}
+	resp, err := s.notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		answer, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("sandbox: notify %s answered %d: %s", notifyURL, resp.StatusCode, answer)
+	}
+	return nil
+}
+
+func (s *Sandbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.writeResponse(w, http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: err.Error()})
+		return
+	}
+	if err := s.checkAuthorization(r, body); err != nil {
+		s.writeResponse(w, http.StatusUnauthorized, ErrorResponse{Code: "SIGN_ERROR", Message: err.Error()})
+		return
+	}
+
+	status, resp := s.route(r, body)
+	s.writeResponse(w, status, resp)
+}
+
+func (s *Sandbox) route(r *http.Request, body []byte) (int, interface{}) {
+	path := r.URL.Path
+	switch {
+	case r.Method == "GET" && path == certificatesPath:
+		return s.certificates()
+	case r.Method == "POST" && strings.HasPrefix(path, "/v3/pay/transactions/") && strings.HasSuffix(path, "/close"):
+		outTradeNo := strings.TrimSuffix(strings.TrimPrefix(path, "/v3/pay/transactions/out-trade-no/"), "/close")
+		return s.closeOrder(outTradeNo, body)
+	case r.Method == "POST" && strings.HasPrefix(path, "/v3/pay/transactions/"):
+		return s.createOrder(strings.TrimPrefix(path, "/v3/pay/transactions/"), body)
+	case r.Method == "GET" && strings.HasPrefix(path, "/v3/pay/transactions/out-trade-no/"):
+		return s.queryOrder(r.URL.Query(), func(o *sandboxOrder) bool {
+			return o.tx.OutTradeNo == strings.TrimPrefix(path, "/v3/pay/transactions/out-trade-no/")
+		})
+	case r.Method == "GET" && strings.HasPrefix(path, "/v3/pay/transactions/id/"):
+		return s.queryOrder(r.URL.Query(), func(o *sandboxOrder) bool {
+			return o.tx.TransactionID == strings.TrimPrefix(path, "/v3/pay/transactions/id/")
+		})
+	case r.Method == "POST" && path == "/v3/refund/domestic/refunds":
+		return s.createRefund(body)
+	case r.Method == "GET" && strings.HasPrefix(path, "/v3/refund/domestic/refunds/"):
+		return s.queryRefund(strings.TrimPrefix(path, "/v3/refund/domestic/refunds/"))
+	}
+	return http.StatusNotFound, ErrorResponse{Code: "NOT_FOUND", Message: "sandbox does not implement " + r.Method + " " + path}
+}
+
+func (s *Sandbox) certificates() (int, interface{}) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.platformCert.Raw})
+	nonce := generateNonce()[:12]
+	ciphertext, err := encryptAES256GCM(s.apiV3Key, "certificate", nonce, certPEM)
+	if err != nil {
+		return http.StatusInternalServerError, ErrorResponse{Code: "SYSTEM_ERROR", Message: err.Error()}
+	}
+	return http.StatusOK, map[string]interface{}{
+		"data": []map[string]interface{}{{
+			"serial_no":      s.platformSerial,
+			"effective_time": s.platformCert.NotBefore.In(cstLocation).Format(time.RFC3339),
+			"expire_time":    s.platformCert.NotAfter.In(cstLocation).Format(time.RFC3339),
+			"encrypt_certificate": map[string]string{
+				"algorithm":       notifyAlgorithm,
+				"nonce":           nonce,
+				"associated_data": "certificate",
+				"ciphertext":      ciphertext,
+			},
+		}},
+	}
+}
+
+func (s *Sandbox) createOrder(endpoint string, body []byte) (int, interface{}) {
+	var req struct {
+		Appid       string `json:"appid"`
+		Mchid       string `json:"mchid"`
+		Description string `json:"description"`
+		OutTradeNo  string `json:"out_trade_no"`
+		NotifyURL   string `json:"notify_url"`
+		Attach      string `json:"attach"`
+		Amount      struct {
+			Total    int    `json:"total"`
+			Currency string `json:"currency"`
+		} `json:"amount"`
+		Payer struct {
+			Openid string `json:"openid"`
+		} `json:"payer"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: err.Error()}
+	}
+	if req.Mchid != s.mchID {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: "mchid mismatch"}
+	}
+	if req.Appid == "" || req.Description == "" || req.OutTradeNo == "" || req.Amount.Total <= 0 {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: "appid, description, out_trade_no and amount are required"}
+	}
+	tradeType, ok := sandboxTradeType(endpoint)
+	if !ok {
+		return http.StatusNotFound, ErrorResponse{Code: "NOT_FOUND", Message: "unknown endpoint " + endpoint}
+	}
+	if tradeType == TradeTypeJSAPI && req.Payer.Openid == "" {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: "payer.openid is required"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[req.OutTradeNo]
+	if ok {
+		switch order.tx.TradeState {
+		case TradeStateSuccess, TradeStateRefund:
+			return http.StatusBadRequest, ErrorResponse{Code: "ORDERPAID", Message: "该订单已支付"}
+		case TradeStateClosed:
+			return http.StatusBadRequest, ErrorResponse{Code: "ORDER_CLOSED", Message: "订单已关闭"}
+		}
+	} else {
+		currency := req.Amount.Currency
+		if currency == "" {
+			currency = "CNY"
+		}
+		s.seq++
+		order = &sandboxOrder{
+			notifyURL: req.NotifyURL,
+			prepayID:  fmt.Sprintf("wx%026d", s.seq),
+			tx: Transaction{
+				Appid:          req.Appid,
+				Mchid:          req.Mchid,
+				OutTradeNo:     req.OutTradeNo,
+				TradeType:      string(tradeType),
+				TradeState:     TradeStateNotPay,
+				TradeStateDesc: "订单未支付",
+				Attach:         req.Attach,
+				Payer:          TransactionPayer{Openid: req.Payer.Openid},
+				Amount:         TransactionAmount{Total: req.Amount.Total, Currency: currency},
+			},
+		}
+		s.orders[req.OutTradeNo] = order
+	}
+
+	switch tradeType {
+	case TradeTypeNative:
+		return http.StatusOK, map[string]string{"code_url": "weixin://wxpay/bizpayurl?pr=" + order.prepayID}
+	case TradeTypeH5:
+		return http.StatusOK, map[string]string{"h5_url": s.URL() + "/h5pay?prepay_id=" + order.prepayID}
+	}
+	return http.StatusOK, map[string]string{"prepay_id": order.prepayID}
+}
+
+// sandboxTradeType maps the last segment of a create-order path back to
+// its TradeType.
+func sandboxTradeType(endpoint string) (TradeType, bool) {
+	for tradeType, path := range tradeTypePaths {
+		if path == "/"+endpoint {
+			return tradeType, true
+		}
+	}
+	return "", false
+}
+
+func (s *Sandbox) queryOrder(query url.Values, match func(*sandboxOrder) bool) (int, interface{}) {
+	if query.Get("mchid") != s.mchID {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: "mchid mismatch"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, order := range s.orders {
+		if match(order) {
+			return http.StatusOK, order.tx
+		}
+	}
+	return http.StatusNotFound, ErrorResponse{Code: "ORDERNOTEXIST", Message: "订单不存在"}
+}
+
+func (s *Sandbox) closeOrder(outTradeNo string, body []byte) (int, interface{}) {
+	var req struct {
+		Mchid string `json:"mchid"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Mchid != s.mchID {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: "mchid mismatch"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[outTradeNo]
+	if !ok {
+		return http.StatusNotFound, ErrorResponse{Code: "ORDERNOTEXIST", Message: "订单不存在"}
+	}
+	switch order.tx.TradeState {
+	case TradeStateSuccess, TradeStateRefund:
+		return http.StatusBadRequest, ErrorResponse{Code: "ORDERPAID", Message: "该订单已支付"}
+	}
+	order.tx.TradeState = TradeStateClosed
+	order.tx.TradeStateDesc = "订单已关闭"
+	return http.StatusNoContent, nil
+}
+
+func (s *Sandbox) createRefund(body []byte) (int, interface{}) {
+	var req struct {
+		TransactionID string `json:"transaction_id"`
+		OutTradeNo    string `json:"out_trade_no"`
+		OutRefundNo   string `json:"out_refund_no"`
+		NotifyURL     string `json:"notify_url"`
+		FundsAccount  string `json:"funds_account"`
+		Amount        struct {
+			Refund   int64  `json:"refund"`
+			Total    int64  `json:"total"`
+			Currency string `json:"currency"`
+		} `json:"amount"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: err.Error()}
+	}
+	if req.OutRefundNo == "" || req.Amount.Refund <= 0 {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: "out_refund_no and amount.refund are required"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if refund, ok := s.refunds[req.OutRefundNo]; ok {
+		return http.StatusOK, refund
+	}
+
+	var order *sandboxOrder
+	for _, o := range s.orders {
+		if (req.OutTradeNo != "" && o.tx.OutTradeNo == req.OutTradeNo) ||
+			(req.TransactionID != "" && o.tx.TransactionID == req.TransactionID) {
+			order = o
+			break
+		}
+	}
+	if order == nil {
+		return http.StatusNotFound, ErrorResponse{Code: "RESOURCE_NOT_EXISTS", Message: "订单不存在"}
+	}
+	if order.tx.TradeState != TradeStateSuccess && order.tx.TradeState != TradeStateRefund {
+		return http.StatusBadRequest, ErrorResponse{Code: "INVALID_REQUEST", Message: "订单未支付"}
+	}
+	if req.Amount.Total != int64(order.tx.Amount.Total) {
+		return http.StatusBadRequest, ErrorResponse{Code: "PARAM_ERROR", Message: "amount.total does not match the order"}
+	}
+	if order.refunded+req.Amount.Refund > req.Amount.Total {
+		return http.StatusBadRequest, ErrorResponse{Code: "INVALID_REQUEST", Message: "申请退款金额超过订单可退金额"}
+	}
+
+	fundsAccount := req.FundsAccount
+	if fundsAccount == "" {
+		fundsAccount = "AVAILABLE"
+	}
+	s.seq++
+	refund := &sandboxRefund{
+		RefundID:            fmt.Sprintf("5030%024d", s.seq),
+		OutRefundNo:         req.OutRefundNo,
+		TransactionID:       order.tx.TransactionID,
+		OutTradeNo:          order.tx.OutTradeNo,
+		Channel:             "ORIGINAL",
+		UserReceivedAccount: "支付用户零钱",
+		CreateTime:          time.Now().In(cstLocation).Format(time.RFC3339),
+		Status:              "PROCESSING",
+		FundsAccount:        fundsAccount,
+		Amount: sandboxRefundAmount{
+			Total:            req.Amount.Total,
+			Refund:           req.Amount.Refund,
+			PayerTotal:       req.Amount.Total,
+			PayerRefund:      req.Amount.Refund,
+			SettlementRefund: req.Amount.Refund,
+			SettlementTotal:  req.Amount.Total,
+			Currency:         order.tx.Amount.Currency,
+		},
+		notifyURL: req.NotifyURL,
+	}
+	s.refunds[req.OutRefundNo] = refund
+	order.refunded += req.Amount.Refund
+	order.tx.TradeState = TradeStateRefund
+	order.tx.TradeStateDesc = "转入退款"
+	return http.StatusOK, refund
+}
+
+func (s *Sandbox) queryRefund(outRefundNo string) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refund, ok := s.refunds[outRefundNo]
+	if !ok {
+		return http.StatusNotFound, ErrorResponse{Code: "RESOURCE_NOT_EXISTS", Message: "退款单不存在"}
+	}
+	return http.StatusOK, refund
+}
+
+// checkAuthorization verifies the WECHATPAY2-SHA256-RSA2048 header against
+// the merchant key, the same way WeChat Pay does.
+func (s *Sandbox) checkAuthorization(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, authType+" ") {
+		return errors.New("missing or unsupported Authorization scheme")
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, authType+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	if fields["mchid"] != s.mchID {
+		return fmt.Errorf("unknown mchid %q", fields["mchid"])
+	}
+	if fields["serial_no"] == "" || fields["nonce_str"] == "" {
+		return errors.New("serial_no and nonce_str are required")
+	}
+	ts, err := strconv.ParseInt(fields["timestamp"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", fields["timestamp"])
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > sandboxMaxSkew || skew < -sandboxMaxSkew {
+		return errors.New("timestamp expired")
+	}
+
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", r.Method, r.URL.RequestURI(), fields["timestamp"], fields["nonce_str"], body)
+	if err := verifySignature(s.merchantKey, []byte(message), fields["signature"]); err != nil {
+		return errors.New("signature does not match the merchant key")
+	}
+	return nil
+}
+
+func (s *Sandbox) writeResponse(w http.ResponseWriter, status int, payload interface{}) {
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := s.signHeader(w.Header(), body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	s.seq++
+	w.Header().Set("Request-ID", fmt.Sprintf("sandbox-request-%d", s.seq))
+	s.mu.Unlock()
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// signHeader adds the Wechatpay-* headers WeChat Pay puts on responses and
+// notifications.
+func (s *Sandbox) signHeader(header http.Header, body []byte) error {
+	timestamp := generateTimestamp()
+	nonce := generateNonce()
+	signature, err := signMessage(s.platformKey, buildVerifyMessage(timestamp, nonce, body))
+	if err != nil {
+		return err
+	}
+	header.Set("Wechatpay-Timestamp", timestamp)
+	header.Set("Wechatpay-Nonce", nonce)
+	header.Set("Wechatpay-Serial", s.platformSerial)
+	header.Set("Wechatpay-Signature", signature)
+	return nil
+}
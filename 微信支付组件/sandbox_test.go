@@ -0,0 +1,223 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSandbox(t *testing.T) (*Sandbox, *Client) {
+	t.Helper()
+	key, _ := generateTestKeyPair()
+	sandbox, err := NewSandbox(&SandboxConfig{MchID: testMchID, APIv3Key: testAPIv3Key, MerchantKey: &key.PublicKey})
+	if err != nil {
+		t.Fatalf("NewSandbox failed: %v", err)
+	}
+	t.Cleanup(func() { sandbox.Close() })
+
+	// The client downloads the sandbox's platform certificate from
+	// /v3/certificates like it would in production.
+	client, err := NewClient(&Config{
+		MchID:       testMchID,
+		SerialNo:    testSerialNo,
+		PrivateKey:  key,
+		MchAPIv3Key: testAPIv3Key,
+		BaseURL:     sandbox.URL(),
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return sandbox, client
+}
+
+func TestSandbox_PaymentWorkflow(t *testing.T) {
+	sandbox, client := newTestSandbox(t)
+	ctx := context.Background()
+
+	notified := make(chan *Transaction, 1)
+	notifyServer := httptest.NewServer(NewTransactionNotifyHandler(NewNotifyParser(client), func(ctx context.Context, notify *NotifyRequest, tx *Transaction) error {
+		if notify.EventType != "TRANSACTION.SUCCESS" {
+			t.Errorf("Unexpected event type: %s", notify.EventType)
+		}
+		notified <- tx
+		return nil
+	}))
+	defer notifyServer.Close()
+
+	resp, err := client.CreateOrder(ctx, &CreateOrderParams{
+		Appid:       "wxd678efh567hg6787",
+		Description: "Image形象店-深圳腾大-QQ公仔",
+		OutTradeNo:  "1217752501201407033233368018",
+		NotifyURL:   notifyServer.URL,
+		Amount:      Amount{Total: 100, Currency: "CNY"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if !strings.HasPrefix(resp.CodeURL, "weixin://wxpay/bizpayurl") {
+		t.Errorf("Unexpected code_url: %s", resp.CodeURL)
+	}
+
+	tx, err := client.QueryOrder(ctx, "1217752501201407033233368018")
+	if err != nil || tx.TradeState != TradeStateNotPay {
+		t.Fatalf("QueryOrder before payment: %+v, %v", tx, err)
+	}
+
+	if err := sandbox.Pay("1217752501201407033233368018", "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"); err != nil {
+		t.Fatalf("Pay failed: %v", err)
+	}
+	select {
+	case tx := <-notified:
+		if tx.TradeState != TradeStateSuccess || tx.Amount.PayerTotal != 100 || tx.Payer.Openid != "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o" {
+			t.Errorf("Unexpected notified transaction: %+v", tx)
+		}
+	default:
+		t.Fatal("Expected a payment notification")
+	}
+
+	tx, err = client.WaitForPayment(ctx, "1217752501201407033233368018", &WaitOptions{Deadline: time.Now().Add(time.Minute)})
+	if err != nil || tx.TradeState != TradeStateSuccess || tx.TransactionID == "" {
+		t.Errorf("WaitForPayment: %+v, %v", tx, err)
+	}
+
+	if err := client.CloseOrder(ctx, "1217752501201407033233368018"); !errors.Is(err, ErrOrderPaid) {
+		t.Errorf("Expected ErrOrderPaid closing a paid order, got %v", err)
+	}
+	if _, err := client.QueryOrder(ctx, "missing"); !errors.Is(err, ErrOrderNotExist) {
+		t.Errorf("Expected ErrOrderNotExist, got %v", err)
+	}
+}
+
+func TestSandbox_CloseOrder(t *testing.T) {
+	sandbox, client := newTestSandbox(t)
+	ctx := context.Background()
+
+	_, err := client.CreateOrder(ctx, &CreateOrderParams{
+		TradeType:   TradeTypeJSAPI,
+		Appid:       "wxd678efh567hg6787",
+		Description: "QQ公仔",
+		OutTradeNo:  "ORDER_CLOSE",
+		NotifyURL:   "https://example.com/notify",
+		Amount:      Amount{Total: 1, Currency: "CNY"},
+		Payer:       &Payer{Openid: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if err := client.CloseOrder(ctx, "ORDER_CLOSE"); err != nil {
+		t.Fatalf("CloseOrder failed: %v", err)
+	}
+	if tx, _ := sandbox.Order("ORDER_CLOSE"); tx.TradeState != TradeStateClosed {
+		t.Errorf("Expected CLOSED, got %s", tx.TradeState)
+	}
+	if err := sandbox.Pay("ORDER_CLOSE", ""); err == nil {
+		t.Error("Expected error paying a closed order")
+	}
+}
+
+func TestSandbox_Refund(t *testing.T) {
+	sandbox, client := newTestSandbox(t)
+	ctx := context.Background()
+
+	notified := make(chan map[string]interface{}, 1)
+	notifyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resource map[string]interface{}
+		notify, err := NewNotifyParser(client).Parse(r, &resource)
+		if err != nil {
+			t.Errorf("Parse notification: %v", err)
+			return
+		}
+		if strings.HasPrefix(notify.EventType, "REFUND.") {
+			notified <- resource
+		}
+	}))
+	defer notifyServer.Close()
+
+	if _, err := client.CreateOrder(ctx, &CreateOrderParams{
+		Appid: "wxd678efh567hg6787", Description: "QQ公仔", OutTradeNo: "ORDER_REFUND",
+		NotifyURL: notifyServer.URL, Amount: Amount{Total: 100, Currency: "CNY"},
+	}); err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	if err := sandbox.Pay("ORDER_REFUND", "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"); err != nil {
+		t.Fatalf("Pay failed: %v", err)
+	}
+
+	refund := func(outRefundNo string, amount int) (map[string]interface{}, error) {
+		var result map[string]interface{}
+		err := client.Do(ctx, "POST", "/v3/refund/domestic/refunds", map[string]interface{}{
+			"out_trade_no":  "ORDER_REFUND",
+			"out_refund_no": outRefundNo,
+			"notify_url":    notifyServer.URL,
+			"amount":        map[string]interface{}{"refund": amount, "total": 100, "currency": "CNY"},
+		}, &result)
+		return result, err
+	}
+
+	result, err := refund("REFUND_1", 60)
+	if err != nil || result["status"] != "PROCESSING" {
+		t.Fatalf("Refund: %v, %v", result, err)
+	}
+	if _, err := refund("REFUND_2", 50); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected over-refund to be rejected, got %v", err)
+	}
+	if again, err := refund("REFUND_1", 60); err != nil || again["refund_id"] != result["refund_id"] {
+		t.Errorf("Expected the same refund on retry, got %v, %v", again, err)
+	}
+
+	if err := sandbox.CompleteRefund("REFUND_1", "SUCCESS"); err != nil {
+		t.Fatalf("CompleteRefund failed: %v", err)
+	}
+	select {
+	case refund := <-notified:
+		if refund["refund_status"] != "SUCCESS" || refund["out_refund_no"] != "REFUND_1" {
+			t.Errorf("Unexpected refund notification: %v", refund)
+		}
+	default:
+		t.Fatal("Expected a refund notification")
+	}
+
+	var queried map[string]interface{}
+	if err := client.Do(ctx, "GET", "/v3/refund/domestic/refunds/REFUND_1", nil, &queried); err != nil || queried["status"] != "SUCCESS" {
+		t.Errorf("Query refund: %v, %v", queried, err)
+	}
+	if tx, _ := sandbox.Order("ORDER_REFUND"); tx.TradeState != TradeStateRefund {
+		t.Errorf("Expected REFUND, got %s", tx.TradeState)
+	}
+}
+
+func TestSandbox_RejectsBadSignature(t *testing.T) {
+	sandbox, _ := newTestSandbox(t)
+
+	other, _ := generateTestKeyPair()
+	client, err := NewClient(&Config{
+		MchID:       testMchID,
+		SerialNo:    testSerialNo,
+		PrivateKey:  other,
+		MchAPIv3Key: testAPIv3Key,
+		BaseURL:     sandbox.URL(),
+		Verifier:    mustVerifier(t, sandbox),
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	_, err = client.QueryOrder(context.Background(), "1217752501201407033233368018")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || !errors.Is(err, ErrSignError) {
+		t.Errorf("Expected 401 SIGN_ERROR, got %v", err)
+	}
+}
+
+func mustVerifier(t *testing.T, sandbox *Sandbox) Verifier {
+	t.Helper()
+	verifier, err := NewCertificateVerifier(sandbox.PlatformCertificate())
+	if err != nil {
+		t.Fatalf("NewCertificateVerifier failed: %v", err)
+	}
+	return verifier
+}
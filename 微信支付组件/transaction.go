@@ -0,0 +1,85 @@
+package wechatpay
+
+// Trade states reported in Transaction.TradeState.
+const (
+	TradeStateSuccess    = "SUCCESS"
+	TradeStateRefund     = "REFUND"
+	TradeStateNotPay     = "NOTPAY"
+	TradeStateClosed     = "CLOSED"
+	TradeStateRevoked    = "REVOKED"
+	TradeStateUserPaying = "USERPAYING"
+	TradeStatePayError   = "PAYERROR"
+)
+
+// Transaction is the order model returned by both query endpoints and
+// carried, decrypted, by the payment notification. Partner orders fill the
+// Sp* and Sub* identifiers instead of Appid and Mchid.
+type Transaction struct {
+	Appid           string                 `json:"appid"`
+	Mchid           string                 `json:"mchid"`
+	SpAppid         string                 `json:"sp_appid,omitempty"`
+	SpMchid         string                 `json:"sp_mchid,omitempty"`
+	SubAppid        string                 `json:"sub_appid,omitempty"`
+	SubMchid        string                 `json:"sub_mchid,omitempty"`
+	OutTradeNo      string                 `json:"out_trade_no"`
+	TransactionID   string                 `json:"transaction_id"`
+	TradeType       string                 `json:"trade_type"`
+	TradeState      string                 `json:"trade_state"`
+	TradeStateDesc  string                 `json:"trade_state_desc"`
+	BankType        string                 `json:"bank_type"`
+	Attach          string                 `json:"attach"`
+	SuccessTime     string                 `json:"success_time"`
+	Payer           TransactionPayer       `json:"payer"`
+	Amount          TransactionAmount      `json:"amount"`
+	SceneInfo       *TransactionSceneInfo  `json:"scene_info,omitempty"`
+	PromotionDetail []TransactionPromotion `json:"promotion_detail,omitempty"`
+}
+
+type TransactionPayer struct {
+	Openid    string `json:"openid"`
+	SpOpenid  string `json:"sp_openid,omitempty"`
+	SubOpenid string `json:"sub_openid,omitempty"`
+}
+
+type TransactionAmount struct {
+	Total         int    `json:"total"`
+	PayerTotal    int    `json:"payer_total"`
+	Currency      string `json:"currency"`
+	PayerCurrency string `json:"payer_currency"`
+}
+
+type TransactionSceneInfo struct {
+	DeviceID string `json:"device_id"`
+}
+
+// TransactionPromotion describes one coupon or discount applied to the order.
+type TransactionPromotion struct {
+	CouponID            string                 `json:"coupon_id"`
+	Name                string                 `json:"name"`
+	Scope               string                 `json:"scope"`
+	Type                string                 `json:"type"`
+	Amount              int                    `json:"amount"`
+	StockID             string                 `json:"stock_id"`
+	WechatpayContribute int                    `json:"wechatpay_contribute"`
+	MerchantContribute  int                    `json:"merchant_contribute"`
+	OtherContribute     int                    `json:"other_contribute"`
+	Currency            string                 `json:"currency"`
+	GoodsDetail         []PromotionGoodsDetail `json:"goods_detail,omitempty"`
+}
+
+type PromotionGoodsDetail struct {
+	GoodsID        string `json:"goods_id"`
+	Quantity       int    `json:"quantity"`
+	UnitPrice      int    `json:"unit_price"`
+	DiscountAmount int    `json:"discount_amount"`
+	GoodsRemark    string `json:"goods_remark"`
+}
+
+// Final reports whether the trade state can no longer change by itself.
+func (t *Transaction) Final() bool {
+	switch t.TradeState {
+	case TradeStateSuccess, TradeStateRefund, TradeStateClosed, TradeStateRevoked, TradeStatePayError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,367 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// transferNameRequiredAmount is the detail amount, in fen, from which
+	// WeChat Pay requires the payee's real name (2000 yuan).
+	transferNameRequiredAmount = 200000
+	// transferNameForbiddenAmount is the amount below which a name must
+	// not be sent (0.3 yuan).
+	transferNameForbiddenAmount = 30
+	maxTransferDetails          = 1000
+)
+
+// Batch statuses of a transfer batch and statuses of its details.
+const (
+	TransferBatchAccepted   = "ACCEPTED"
+	TransferBatchProcessing = "PROCESSING"
+	TransferBatchFinished   = "FINISHED"
+	TransferBatchClosed     = "CLOSED"
+
+	TransferDetailInit       = "INIT"
+	TransferDetailWaitPay    = "WAIT_PAY"
+	TransferDetailProcessing = "PROCESSING"
+	TransferDetailSuccess    = "SUCCESS"
+	TransferDetailFail       = "FAIL"
+)
+
+type TransferBatchRequest struct {
+	Appid       string
+	OutBatchNo  string
+	BatchName   string
+	BatchRemark string
+	// TransferSceneID is only needed when the merchant has more than one
+	// transfer scene enabled.
+	TransferSceneID string
+	Details         []TransferDetail
+}
+
+type TransferDetail struct {
+	OutDetailNo    string
+	TransferAmount int
+	TransferRemark string
+	Openid         string
+	// UserName is the payee's real name. It is required from 2000 yuan,
+	// must be empty below 0.3 yuan, and is encrypted before sending.
+	UserName string
+}
+
+type TransferBatchResponse struct {
+	OutBatchNo  string `json:"out_batch_no"`
+	BatchID     string `json:"batch_id"`
+	CreateTime  string `json:"create_time"`
+	BatchStatus string `json:"batch_status"`
+}
+
+type QueryTransferBatchRequest struct {
+	NeedQueryDetail bool
+	Offset          int
+	// Limit defaults to 20 and is at most 100.
+	Limit int
+	// DetailStatus filters details: ALL, SUCCESS or FAIL.
+	DetailStatus string
+}
+
+type TransferBatch struct {
+	TransferBatch      TransferBatchInfo     `json:"transfer_batch"`
+	TransferDetailList []TransferDetailBrief `json:"transfer_detail_list"`
+}
+
+type TransferBatchInfo struct {
+	Mchid         string `json:"mchid"`
+	OutBatchNo    string `json:"out_batch_no"`
+	BatchID       string `json:"batch_id"`
+	Appid         string `json:"appid"`
+	BatchStatus   string `json:"batch_status"`
+	BatchType     string `json:"batch_type"`
+	BatchName     string `json:"batch_name"`
+	BatchRemark   string `json:"batch_remark"`
+	CloseReason   string `json:"close_reason"`
+	TotalAmount   int    `json:"total_amount"`
+	TotalNum      int    `json:"total_num"`
+	CreateTime    string `json:"create_time"`
+	UpdateTime    string `json:"update_time"`
+	SuccessAmount int    `json:"success_amount"`
+	SuccessNum    int    `json:"success_num"`
+	FailAmount    int    `json:"fail_amount"`
+	FailNum       int    `json:"fail_num"`
+}
+
+type TransferDetailBrief struct {
+	DetailID     string `json:"detail_id"`
+	OutDetailNo  string `json:"out_detail_no"`
+	DetailStatus string `json:"detail_status"`
+}
+
+type TransferDetailResult struct {
+	Mchid          string `json:"mchid"`
+	OutBatchNo     string `json:"out_batch_no"`
+	BatchID        string `json:"batch_id"`
+	Appid          string `json:"appid"`
+	OutDetailNo    string `json:"out_detail_no"`
+	DetailID       string `json:"detail_id"`
+	DetailStatus   string `json:"detail_status"`
+	TransferAmount int    `json:"transfer_amount"`
+	TransferRemark string `json:"transfer_remark"`
+	FailReason     string `json:"fail_reason"`
+	Openid         string `json:"openid"`
+	// UserName comes back encrypted with the merchant certificate; use
+	// Client.DecryptSensitive to read it.
+	UserName     string `json:"user_name"`
+	InitiateTime string `json:"initiate_time"`
+	UpdateTime   string `json:"update_time"`
+}
+
+// TransferReceipt is an electronic receipt (电子回单) of a whole batch or of
+// one detail. Once SignatureStatus is FINISHED it can be fetched with
+// DownloadTransferReceipt.
+type TransferReceipt struct {
+	AcceptType      string `json:"accept_type,omitempty"`
+	OutBatchNo      string `json:"out_batch_no"`
+	OutDetailNo     string `json:"out_detail_no,omitempty"`
+	SignatureNo     string `json:"signature_no"`
+	SignatureStatus string `json:"signature_status"`
+	HashType        string `json:"hash_type"`
+	HashValue       string `json:"hash_value"`
+	DownloadURL     string `json:"download_url"`
+	CreateTime      string `json:"create_time"`
+	UpdateTime      string `json:"update_time"`
+}
+
+// CreateTransferBatch sends money from the merchant's operating account to
+// the WeChat balance of each payee in the batch.
+func (c *Client) CreateTransferBatch(ctx context.Context, params *TransferBatchRequest) (*TransferBatchResponse, error) {
+	if err := validateTransferBatch(params); err != nil {
+		return nil, err
+	}
+
+	total := 0
+	enc := c.NewSensitiveEncrypter()
+	details := make([]map[string]interface{}, 0, len(params.Details))
+	for _, d := range params.Details {
+		total += d.TransferAmount
+		detail := map[string]interface{}{
+			"out_detail_no":   d.OutDetailNo,
+			"transfer_amount": d.TransferAmount,
+			"transfer_remark": d.TransferRemark,
+			"openid":          d.Openid,
+		}
+		if d.UserName != "" {
+			name, err := enc.Encrypt(ctx, d.UserName)
+			if err != nil {
+				return nil, err
+			}
+			detail["user_name"] = name
+		}
+		details = append(details, detail)
+	}
+
+	body := map[string]interface{}{
+		"appid":                params.Appid,
+		"out_batch_no":         params.OutBatchNo,
+		"batch_name":           params.BatchName,
+		"batch_remark":         params.BatchRemark,
+		"total_amount":         total,
+		"total_num":            len(details),
+		"transfer_detail_list": details,
+	}
+	if params.TransferSceneID != "" {
+		body["transfer_scene_id"] = params.TransferSceneID
+	}
+
+	req, err := c.newJSONRequest("POST", "/v3/transfer/batches", body, WithSensitiveEncrypter(enc))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp TransferBatchResponse
+	if err := c.doJSON(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func validateTransferBatch(params *TransferBatchRequest) error {
+	if params.Appid == "" || params.OutBatchNo == "" {
+		return errors.New("appid and out_batch_no are required")
+	}
+	if params.BatchName == "" || len(params.BatchName) > 32 {
+		return errors.New("batch_name must be 1 to 32 bytes")
+	}
+	if params.BatchRemark == "" || len(params.BatchRemark) > 32 {
+		return errors.New("batch_remark must be 1 to 32 bytes")
+	}
+	if len(params.Details) == 0 || len(params.Details) > maxTransferDetails {
+		return fmt.Errorf("details must have 1 to %d entries", maxTransferDetails)
+	}
+	for i, d := range params.Details {
+		if d.OutDetailNo == "" || d.Openid == "" {
+			return fmt.Errorf("details[%d]: out_detail_no and openid are required", i)
+		}
+		if d.TransferAmount <= 0 {
+			return fmt.Errorf("details[%d]: transfer_amount must be positive", i)
+		}
+		if d.TransferRemark == "" || len(d.TransferRemark) > 32 {
+			return fmt.Errorf("details[%d]: transfer_remark must be 1 to 32 bytes", i)
+		}
+		if d.TransferAmount >= transferNameRequiredAmount && d.UserName == "" {
+			return fmt.Errorf("details[%d]: user_name is required from 2000 yuan", i)
+		}
+		if d.TransferAmount < transferNameForbiddenAmount && d.UserName != "" {
+			return fmt.Errorf("details[%d]: user_name must be empty below 0.3 yuan", i)
+		}
+	}
+	return nil
+}
+
+func (c *Client) QueryTransferBatchByID(ctx context.Context, batchID string, params *QueryTransferBatchRequest) (*TransferBatch, error) {
+	if batchID == "" {
+		return nil, errors.New("batch_id is required")
+	}
+	return c.queryTransferBatch(ctx, "/v3/transfer/batches/batch-id/"+url.PathEscape(batchID), params)
+}
+
+func (c *Client) QueryTransferBatchByOutBatchNo(ctx context.Context, outBatchNo string, params *QueryTransferBatchRequest) (*TransferBatch, error) {
+	if outBatchNo == "" {
+		return nil, errors.New("out_batch_no is required")
+	}
+	return c.queryTransferBatch(ctx, "/v3/transfer/batches/out-batch-no/"+url.PathEscape(outBatchNo), params)
+}
+
+func (c *Client) queryTransferBatch(ctx context.Context, path string, params *QueryTransferBatchRequest) (*TransferBatch, error) {
+	if params == nil {
+		params = &QueryTransferBatchRequest{}
+	}
+	query := url.Values{}
+	query.Set("need_query_detail", strconv.FormatBool(params.NeedQueryDetail))
+	if params.NeedQueryDetail {
+		limit := params.Limit
+		if limit <= 0 {
+			limit = 20
+		}
+		query.Set("offset", strconv.Itoa(params.Offset))
+		query.Set("limit", strconv.Itoa(limit))
+		if params.DetailStatus != "" {
+			query.Set("detail_status", params.DetailStatus)
+		}
+	}
+
+	req, err := c.newRequest("GET", path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch TransferBatch
+	if err := c.doJSON(ctx, req, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func (c *Client) QueryTransferDetailByID(ctx context.Context, batchID, detailID string) (*TransferDetailResult, error) {
+	if batchID == "" || detailID == "" {
+		return nil, errors.New("batch_id and detail_id are required")
+	}
+	path := fmt.Sprintf("/v3/transfer/batches/batch-id/%s/details/detail-id/%s", url.PathEscape(batchID), url.PathEscape(detailID))
+	return c.queryTransferDetail(ctx, path)
+}
+
+func (c *Client) QueryTransferDetailByOutNo(ctx context.Context, outBatchNo, outDetailNo string) (*TransferDetailResult, error) {
+	if outBatchNo == "" || outDetailNo == "" {
+		return nil, errors.New("out_batch_no and out_detail_no are required")
+	}
+	path := fmt.Sprintf("/v3/transfer/batches/out-batch-no/%s/details/out-detail-no/%s", url.PathEscape(outBatchNo), url.PathEscape(outDetailNo))
+	return c.queryTransferDetail(ctx, path)
+}
+
+func (c *Client) queryTransferDetail(ctx context.Context, path string) (*TransferDetailResult, error) {
+	req, err := c.newRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var detail TransferDetailResult
+	if err := c.doJSON(ctx, req, &detail); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// ApplyTransferBatchReceipt asks WeChat Pay to produce the electronic
+// receipt of a finished batch.
+func (c *Client) ApplyTransferBatchReceipt(ctx context.Context, outBatchNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" {
+		return nil, errors.New("out_batch_no is required")
+	}
+	req, err := c.newJSONRequest("POST", "/v3/transfer/bill-receipt", map[string]string{"out_batch_no": outBatchNo})
+	if err != nil {
+		return nil, err
+	}
+	return c.doReceipt(ctx, req)
+}
+
+func (c *Client) QueryTransferBatchReceipt(ctx context.Context, outBatchNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" {
+		return nil, errors.New("out_batch_no is required")
+	}
+	req, err := c.newRequest("GET", "/v3/transfer/bill-receipt/"+url.PathEscape(outBatchNo), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.doReceipt(ctx, req)
+}
+
+// ApplyTransferDetailReceipt asks for the electronic receipt of one detail.
+func (c *Client) ApplyTransferDetailReceipt(ctx context.Context, outBatchNo, outDetailNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" || outDetailNo == "" {
+		return nil, errors.New("out_batch_no and out_detail_no are required")
+	}
+	req, err := c.newJSONRequest("POST", "/v3/transfer-detail/electronic-receipts", map[string]string{
+		"accept_type":   "BATCH_TRANSFER",
+		"out_batch_no":  outBatchNo,
+		"out_detail_no": outDetailNo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.doReceipt(ctx, req)
+}
+
+func (c *Client) QueryTransferDetailReceipt(ctx context.Context, outBatchNo, outDetailNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" || outDetailNo == "" {
+		return nil, errors.New("out_batch_no and out_detail_no are required")
+	}
+	query := url.Values{}
+	query.Set("accept_type", "BATCH_TRANSFER")
+	query.Set("out_batch_no", outBatchNo)
+	query.Set("out_detail_no", outDetailNo)
+	req, err := c.newRequest("GET", "/v3/transfer-detail/electronic-receipts?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.doReceipt(ctx, req)
+}
+
+func (c *Client) doReceipt(ctx context.Context, req *http.Request) (*TransferReceipt, error) {
+	var receipt TransferReceipt
+	if err := c.doJSON(ctx, req, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// DownloadTransferReceipt fetches the receipt PDF and checks its hash.
+func (c *Client) DownloadTransferReceipt(ctx context.Context, receipt *TransferReceipt) ([]byte, error) {
+	if receipt.SignatureStatus != "FINISHED" {
+		return nil, fmt.Errorf("receipt of %s is not ready: %s", receipt.OutBatchNo, receipt.SignatureStatus)
+	}
+	return c.download(ctx, receipt.DownloadURL, receipt.HashType, receipt.HashValue)
+}
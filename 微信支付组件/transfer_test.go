@@ -0,0 +1,185 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateTransferBatch(t *testing.T) {
+	var client *Client
+	var platform *testPlatform
+	client, platform = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v3/transfer/batches" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Wechatpay-Serial") != platform.serial {
+			t.Errorf("Expected Wechatpay-Serial %s, got %q", platform.serial, r.Header.Get("Wechatpay-Serial"))
+		}
+		var body struct {
+			OutBatchNo  string `json:"out_batch_no"`
+			TotalAmount int    `json:"total_amount"`
+			TotalNum    int    `json:"total_num"`
+			Details     []struct {
+				OutDetailNo string `json:"out_detail_no"`
+				Amount      int    `json:"transfer_amount"`
+				UserName    string `json:"user_name"`
+			} `json:"transfer_detail_list"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.OutBatchNo != "plfk2020042013" || body.TotalAmount != 200100 || body.TotalNum != 2 || len(body.Details) != 2 {
+			t.Fatalf("Unexpected body: %+v", body)
+		}
+		if got := decryptTestSensitive(t, platform.key, body.Details[0].UserName); got != "张三" {
+			t.Errorf("Unexpected decrypted user_name: %s", got)
+		}
+		if body.Details[1].UserName != "" {
+			t.Errorf("Expected no user_name for small detail, got %q", body.Details[1].UserName)
+		}
+		w.Write([]byte(`{"out_batch_no":"plfk2020042013","batch_id":"1030000071100999991182020050700019480001","create_time":"2015-05-20T13:29:35.120+08:00","batch_status":"ACCEPTED"}`))
+	})
+	useTestCertificates(client, platform)
+
+	resp, err := client.CreateTransferBatch(context.Background(), &TransferBatchRequest{
+		Appid:       "wxf636efh567hg4356",
+		OutBatchNo:  "plfk2020042013",
+		BatchName:   "2019年1月深圳分部报销单",
+		BatchRemark: "1月报销",
+		Details: []TransferDetail{
+			{OutDetailNo: "x23zy545Bd5436", TransferAmount: 200000, TransferRemark: "报销", Openid: "o-MYE42l80oelYMDE34nYD456Xoy", UserName: "张三"},
+			{OutDetailNo: "x23zy545Bd5437", TransferAmount: 100, TransferRemark: "报销", Openid: "o-MYE42l80oelYMDE34nYD456Xoz"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTransferBatch failed: %v", err)
+	}
+	if resp.BatchID != "1030000071100999991182020050700019480001" || resp.BatchStatus != TransferBatchAccepted {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestValidateTransferBatch(t *testing.T) {
+	valid := func() *TransferBatchRequest {
+		return &TransferBatchRequest{
+			Appid: "wx1", OutBatchNo: "B1", BatchName: "name", BatchRemark: "remark",
+			Details: []TransferDetail{{OutDetailNo: "D1", TransferAmount: 100, TransferRemark: "r", Openid: "o1"}},
+		}
+	}
+	if err := validateTransferBatch(valid()); err != nil {
+		t.Fatalf("Expected valid batch, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*TransferBatchRequest)
+		errMsg string
+	}{
+		{"no details", func(p *TransferBatchRequest) { p.Details = nil }, "details must have"},
+		{"long batch name", func(p *TransferBatchRequest) { p.BatchName = strings.Repeat("a", 33) }, "batch_name"},
+		{"zero amount", func(p *TransferBatchRequest) { p.Details[0].TransferAmount = 0 }, "transfer_amount"},
+		{"large without name", func(p *TransferBatchRequest) { p.Details[0].TransferAmount = 200000 }, "user_name is required"},
+		{"tiny with name", func(p *TransferBatchRequest) {
+			p.Details[0].TransferAmount = 29
+			p.Details[0].UserName = "张三"
+		}, "user_name must be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := valid()
+			tt.modify(params)
+			err := validateTransferBatch(params)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestQueryTransferBatchAndDetail(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RequestURI() {
+		case "/v3/transfer/batches/out-batch-no/plfk2020042013?detail_status=FAIL&limit=20&need_query_detail=true&offset=0":
+			w.Write([]byte(`{"transfer_batch":{"mchid":"1900001109","out_batch_no":"plfk2020042013","batch_id":"1030000071100999991182020050700019480001","batch_status":"FINISHED","total_amount":200100,"total_num":2,"fail_amount":100,"fail_num":1},"transfer_detail_list":[{"detail_id":"1040000071100999991182020050700019500100","out_detail_no":"x23zy545Bd5437","detail_status":"FAIL"}]}`))
+		case "/v3/transfer/batches/batch-id/1030000071100999991182020050700019480001?need_query_detail=false":
+			w.Write([]byte(`{"transfer_batch":{"batch_status":"PROCESSING"}}`))
+		case "/v3/transfer/batches/out-batch-no/plfk2020042013/details/out-detail-no/x23zy545Bd5437":
+			w.Write([]byte(`{"out_detail_no":"x23zy545Bd5437","detail_status":"FAIL","transfer_amount":100,"fail_reason":"ACCOUNT_FROZEN"}`))
+		default:
+			t.Errorf("Unexpected request: %s", r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	batch, err := client.QueryTransferBatchByOutBatchNo(context.Background(), "plfk2020042013", &QueryTransferBatchRequest{NeedQueryDetail: true, DetailStatus: "FAIL"})
+	if err != nil {
+		t.Fatalf("QueryTransferBatchByOutBatchNo failed: %v", err)
+	}
+	if batch.TransferBatch.BatchStatus != TransferBatchFinished || batch.TransferBatch.FailNum != 1 || len(batch.TransferDetailList) != 1 {
+		t.Errorf("Unexpected batch: %+v", batch)
+	}
+
+	batch, err = client.QueryTransferBatchByID(context.Background(), "1030000071100999991182020050700019480001", nil)
+	if err != nil || batch.TransferBatch.BatchStatus != TransferBatchProcessing {
+		t.Errorf("QueryTransferBatchByID: %+v, %v", batch, err)
+	}
+
+	detail, err := client.QueryTransferDetailByOutNo(context.Background(), "plfk2020042013", "x23zy545Bd5437")
+	if err != nil {
+		t.Fatalf("QueryTransferDetailByOutNo failed: %v", err)
+	}
+	if detail.DetailStatus != TransferDetailFail || detail.FailReason != "ACCOUNT_FROZEN" {
+		t.Errorf("Unexpected detail: %+v", detail)
+	}
+}
+
+func TestTransferReceipt(t *testing.T) {
+	pdf := []byte("%PDF-1.4 receipt")
+	sum := sha256.Sum256(pdf)
+	var baseURL string
+	var client *Client
+	client, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "POST /v3/transfer/bill-receipt":
+			w.Write([]byte(`{"out_batch_no":"plfk2020042013","signature_no":"1050000010509999485212020110200058820001","signature_status":"ACCEPTED"}`))
+		case "GET /v3/transfer/bill-receipt/plfk2020042013":
+			w.Write([]byte(`{"out_batch_no":"plfk2020042013","signature_status":"FINISHED","hash_type":"SHA256","hash_value":"` + hex.EncodeToString(sum[:]) + `","download_url":"` + baseURL + `/v3/transfer/download/signfile?token=abc"}`))
+		case "GET /v3/transfer-detail/electronic-receipts?accept_type=BATCH_TRANSFER&out_batch_no=plfk2020042013&out_detail_no=x23zy545Bd5436":
+			w.Write([]byte(`{"accept_type":"BATCH_TRANSFER","out_batch_no":"plfk2020042013","out_detail_no":"x23zy545Bd5436","signature_status":"ACCEPTED"}`))
+		case "GET /v3/transfer/download/signfile?token=abc":
+			w.Write(pdf)
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.RequestURI())
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	baseURL = client.baseURL
+
+	receipt, err := client.ApplyTransferBatchReceipt(context.Background(), "plfk2020042013")
+	if err != nil || receipt.SignatureStatus != "ACCEPTED" {
+		t.Fatalf("ApplyTransferBatchReceipt: %+v, %v", receipt, err)
+	}
+	if _, err := client.DownloadTransferReceipt(context.Background(), receipt); err == nil {
+		t.Error("Expected error downloading unfinished receipt")
+	}
+
+	receipt, err = client.QueryTransferBatchReceipt(context.Background(), "plfk2020042013")
+	if err != nil {
+		t.Fatalf("QueryTransferBatchReceipt failed: %v", err)
+	}
+	data, err := client.DownloadTransferReceipt(context.Background(), receipt)
+	if err != nil {
+		t.Fatalf("DownloadTransferReceipt failed: %v", err)
+	}
+	if string(data) != string(pdf) {
+		t.Errorf("Unexpected receipt content: %q", data)
+	}
+
+	receipt, err = client.QueryTransferDetailReceipt(context.Background(), "plfk2020042013", "x23zy545Bd5436")
+	if err != nil || receipt.OutDetailNo != "x23zy545Bd5436" {
+		t.Errorf("QueryTransferDetailReceipt: %+v, %v", receipt, err)
+	}
+}
@@ -0,0 +1,271 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signature types of the v2 API.
+const (
+	SignTypeMD5        = "MD5"
+	SignTypeHMACSHA256 = "HMAC-SHA256"
+)
+
+// ErrV2SignatureMismatch is returned when a v2 response carries a sign that
+// does not match its fields.
+var ErrV2SignatureMismatch = errors.New("v2 response signature mismatch")
+
+type V2Config struct {
+	Appid string
+	MchID string
+	// APIKey is the 32 character v2 API key, not the APIv3 key.
+	APIKey string
+	// SignType defaults to SignTypeMD5.
+	SignType string
+	// PrivateKey and Certificate are the merchant credentials presented
+	// for mutual TLS, as returned by LoadPKCS12. They are required for
+	// /secapi endpoints such as Reverse.
+	PrivateKey  *rsa.PrivateKey
+	Certificate *x509.Certificate
+	// HTTPClient defaults to a client with a 30 second timeout. When
+	// credentials are set its transport is cloned to present them.
+	HTTPClient *http.Client
+	// BaseURL defaults to https://api.mch.weixin.qq.com.
+	BaseURL string
+}
+
+// V2Client calls the legacy XML API, which is still the only way to use
+// barcode payment (micropay).
+type V2Client struct {
+	appid      string
+	mchID      string
+	apiKey     string
+	signType   string
+	hasCert    bool
+	httpClient *http.Client
+	baseURL    string
+}
+
+func NewV2Client(config *V2Config) (*V2Client, error) {
+	if config.Appid == "" || config.MchID == "" {
+		return nil, errors.New("appid and mchid are required")
+	}
+	if len(config.APIKey) != 32 {
+		return nil, errors.New("v2 api key must be 32 bytes")
+	}
+	signType := config.SignType
+	if signType == "" {
+		signType = SignTypeMD5
+	}
+	if signType != SignTypeMD5 && signType != SignTypeHMACSHA256 {
+		return nil, fmt.Errorf("unsupported sign type: %s", signType)
+	}
+
+	c := &V2Client{
+		appid:      config.Appid,
+		mchID:      config.MchID,
+		apiKey:     config.APIKey,
+		signType:   signType,
+		httpClient: config.HTTPClient,
+		baseURL:    config.BaseURL,
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.baseURL == "" {
+		c.baseURL = defaultBaseURL
+	}
+	if config.PrivateKey != nil || config.Certificate != nil {
+		if config.PrivateKey == nil || config.Certificate == nil {
+			return nil, errors.New("mutual TLS needs both private key and certificate")
+		}
+		httpClient, err := withClientCertificate(c.httpClient, tls.Certificate{
+			Certificate: [][]byte{config.Certificate.Raw},
+			PrivateKey:  config.PrivateKey,
+			Leaf:        config.Certificate,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.httpClient = httpClient
+		c.hasCert = true
+	}
+	return c, nil
+}
+
+// withClientCertificate returns a copy of base whose transport presents
+// cert during the TLS handshake.
+func withClientCertificate(base *http.Client, cert tls.Certificate) (*http.Client, error) {
+	transport := http.DefaultTransport
+	if base.Transport != nil {
+		transport = base.Transport
+	}
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("mutual TLS needs an *http.Transport")
+	}
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.Certificates = append(t.TLSClientConfig.Certificates, cert)
+
+	client := *base
+	client.Transport = t
+	return &client, nil
+}
+
+// post signs params, sends them as XML and returns the verified response
+// fields. Failed return_code or result_code become an *APIError.
+func (c *V2Client) post(ctx context.Context, path string, params map[string]string) (map[string]string, error) {
+	params["appid"] = c.appid
+	params["mch_id"] = c.mchID
+	params["nonce_str"] = generateNonce()
+	if c.signType != SignTypeMD5 {
+		params["sign_type"] = c.signType
+	}
+	sign, err := SignV2(params, c.apiKey, c.signType)
+	if err != nil {
+		return nil, err
+	}
+	params["sign"] = sign
+
+	req, err := http.NewRequest("POST", c.baseURL+path, bytes.NewReader(EncodeV2XML(params)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	result, err := DecodeV2XML(body)
+	if err != nil {
+		return nil, err
+	}
+	if result["return_code"] != "SUCCESS" {
+		return nil, &APIError{StatusCode: resp.StatusCode, Code: result["return_code"], Message: result["return_msg"], Body: string(body)}
+	}
+	if !VerifyV2(result, c.apiKey, c.signType) {
+		return nil, ErrV2SignatureMismatch
+	}
+	if result["result_code"] != "SUCCESS" {
+		return nil, &APIError{StatusCode: resp.StatusCode, Code: result["err_code"], Message: result["err_code_des"], Body: string(body)}
+	}
+	return result, nil
+}
+
+// SignV2 computes the v2 sign: non-empty fields other than sign sorted by
+// key, joined as k=v&..., followed by &key=apiKey, then MD5 or HMAC-SHA256
+// in upper-case hex.
+func SignV2(params map[string]string, apiKey, signType string) (string, error) {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if k == "sign" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	for _, k := range keys {
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(params[k])
+		buf.WriteByte('&')
+	}
+	buf.WriteString("key=")
+	buf.WriteString(apiKey)
+
+	var h hash.Hash
+	switch signType {
+	case "", SignTypeMD5:
+		h = md5.New()
+	case SignTypeHMACSHA256:
+		h = hmac.New(sha256.New, []byte(apiKey))
+	default:
+		return "", fmt.Errorf("unsupported sign type: %s", signType)
+	}
+	h.Write([]byte(buf.String()))
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// VerifyV2 reports whether params carry a valid sign. The sign_type field,
+// when present, overrides signType.
+func VerifyV2(params map[string]string, apiKey, signType string) bool {
+	if t := params["sign_type"]; t != "" {
+		signType = t
+	}
+	expected, err := SignV2(params, apiKey, signType)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(strings.ToUpper(params["sign"])))
+}
+
+// EncodeV2XML renders params as the flat <xml> document of the v2 API.
+func EncodeV2XML(params map[string]string) []byte {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString("<xml>")
+	for _, k := range keys {
+		buf.WriteString("<" + k + ">")
+		xml.EscapeText(&buf, []byte(params[k]))
+		buf.WriteString("</" + k + ">")
+	}
+	buf.WriteString("</xml>")
+	return buf.Bytes()
+}
+
+// DecodeV2XML reads the fields of a flat v2 <xml> document, including
+// values wrapped in CDATA.
+func DecodeV2XML(data []byte) (map[string]string, error) {
+	var doc struct {
+		Fields []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(doc.Fields))
+	for _, f := range doc.Fields {
+		params[f.XMLName.Local] = f.Value
+	}
+	return params, nil
+}
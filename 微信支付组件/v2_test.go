@@ -0,0 +1,210 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testV2APIKey = "192006250b4c09247ec02edce69f6a2d"
+
+// newTestV2Client starts a TLS server that checks the sign of each request
+// and answers with the fields h returns, signed unless h set sign itself.
+func newTestV2Client(t *testing.T, config V2Config, h func(r *http.Request, fields map[string]string) map[string]string) *V2Client {
+	t.Helper()
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fields, err := DecodeV2XML(body)
+		if err != nil {
+			t.Errorf("Invalid request XML: %v", err)
+		}
+		if !VerifyV2(fields, testV2APIKey, SignTypeMD5) {
+			t.Errorf("Invalid request sign: %s", body)
+		}
+
+		resp := h(r, fields)
+		if resp["return_code"] == "" {
+			resp["return_code"] = "SUCCESS"
+		}
+		if resp["return_code"] == "SUCCESS" && resp["sign"] == "" {
+			resp["sign"], _ = SignV2(resp, testV2APIKey, fields["sign_type"])
+		}
+		w.Write(EncodeV2XML(resp))
+	}))
+	t.Cleanup(ts.Close)
+
+	config.Appid = "wxd930ea5d5a258f4f"
+	config.MchID = "10000100"
+	config.APIKey = testV2APIKey
+	config.HTTPClient = ts.Client()
+	config.BaseURL = ts.URL
+	client, err := NewV2Client(&config)
+	if err != nil {
+		t.Fatalf("NewV2Client failed: %v", err)
+	}
+	return client
+}
+
+func TestSignV2(t *testing.T) {
+	// The example from the WeChat Pay v2 signature documentation.
+	params := map[string]string{
+		"appid":       "wxd930ea5d5a258f4f",
+		"mch_id":      "10000100",
+		"device_info": "1000",
+		"body":        "test",
+		"nonce_str":   "ibuaiVcKdpRxkhJA",
+		"attach":      "",
+	}
+
+	tests := []struct {
+		signType string
+		expected string
+	}{
+		{SignTypeMD5, "9A0A8659F005D6984697E2CA0A9CF3B7"},
+		{SignTypeHMACSHA256, "6A9AE1657590FD6257D693A078E1C3E4BB6BA4DC30B23E0EE2496E54170DACD6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.signType, func(t *testing.T) {
+			sign, err := SignV2(params, testV2APIKey, tt.signType)
+			if err != nil {
+				t.Fatalf("SignV2 failed: %v", err)
+			}
+			if sign != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, sign)
+			}
+
+			signed := map[string]string{"sign": sign}
+			for k, v := range params {
+				signed[k] = v
+			}
+			if !VerifyV2(signed, testV2APIKey, tt.signType) {
+				t.Error("VerifyV2 rejected a valid sign")
+			}
+			signed["body"] = "tampered"
+			if VerifyV2(signed, testV2APIKey, tt.signType) {
+				t.Error("VerifyV2 accepted a tampered field")
+			}
+		})
+	}
+
+	if _, err := SignV2(params, testV2APIKey, "RSA"); err == nil {
+		t.Error("Expected error for unsupported sign type")
+	}
+}
+
+func TestV2XML(t *testing.T) {
+	params := map[string]string{"body": "<测试> & co", "total_fee": "1"}
+	encoded := EncodeV2XML(params)
+	if string(encoded) != "<xml><body>&lt;测试&gt; &amp; co</body><total_fee>1</total_fee></xml>" {
+		t.Errorf("Unexpected XML: %s", encoded)
+	}
+
+	decoded, err := DecodeV2XML([]byte("<xml><return_code><![CDATA[SUCCESS]]></return_code>\n<total_fee>1</total_fee><body><![CDATA[<测试> & co]]></body></xml>"))
+	if err != nil {
+		t.Fatalf("DecodeV2XML failed: %v", err)
+	}
+	if decoded["return_code"] != "SUCCESS" || decoded["total_fee"] != "1" || decoded["body"] != params["body"] {
+		t.Errorf("Unexpected fields: %v", decoded)
+	}
+
+	if _, err := DecodeV2XML([]byte("<xml><a>")); err == nil {
+		t.Error("Expected error for malformed XML")
+	}
+}
+
+func TestNewV2Client(t *testing.T) {
+	key, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, key, 1, time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		config V2Config
+	}{
+		{"missing mchid", V2Config{Appid: "wx1", APIKey: testV2APIKey}},
+		{"short api key", V2Config{Appid: "wx1", MchID: "1", APIKey: "short"}},
+		{"bad sign type", V2Config{Appid: "wx1", MchID: "1", APIKey: testV2APIKey, SignType: "RSA"}},
+		{"key without certificate", V2Config{Appid: "wx1", MchID: "1", APIKey: testV2APIKey, PrivateKey: key}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewV2Client(&tt.config); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+
+	client, err := NewV2Client(&V2Config{Appid: "wx1", MchID: "1", APIKey: testV2APIKey, PrivateKey: key, Certificate: cert})
+	if err != nil {
+		t.Fatalf("NewV2Client failed: %v", err)
+	}
+	transport := client.httpClient.Transport.(*http.Transport)
+	if len(transport.TLSClientConfig.Certificates) != 1 || client.signType != SignTypeMD5 || client.baseURL != defaultBaseURL {
+		t.Errorf("Unexpected client: %+v", client)
+	}
+}
+
+func TestV2Client_Errors(t *testing.T) {
+	var reply map[string]string
+	client := newTestV2Client(t, V2Config{}, func(r *http.Request, fields map[string]string) map[string]string {
+		return reply
+	})
+
+	reply = map[string]string{"return_code": "FAIL", "return_msg": "签名错误"}
+	_, err := client.QueryOrder(context.Background(), "1217752501201407033233368018")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "FAIL" || apiErr.Message != "签名错误" {
+		t.Errorf("Expected return_code error, got %v", err)
+	}
+
+	reply = map[string]string{"result_code": "FAIL", "err_code": "ORDERNOTEXIST", "err_code_des": "此交易订单号不存在"}
+	if _, err := client.QueryOrder(context.Background(), "1217752501201407033233368018"); !errors.Is(err, ErrOrderNotExist) {
+		t.Errorf("Expected ErrOrderNotExist, got %v", err)
+	}
+
+	reply = map[string]string{"result_code": "SUCCESS", "trade_state": "SUCCESS", "sign": strings.Repeat("0", 32)}
+	if _, err := client.QueryOrder(context.Background(), "1217752501201407033233368018"); !errors.Is(err, ErrV2SignatureMismatch) {
+		t.Error("Expected error for response signed with another key")
+	}
+}
+
+func TestV2Client_MutualTLS(t *testing.T) {
+	key, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, key, 0x3775B6A4, time.Now().Add(time.Hour))
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) != 1 || CertificateSerial(r.TLS.PeerCertificates[0]) != "3775B6A4" {
+			t.Errorf("Expected merchant client certificate, got %d", len(r.TLS.PeerCertificates))
+		}
+		resp := map[string]string{"return_code": "SUCCESS", "result_code": "SUCCESS", "recall": "N"}
+		resp["sign"], _ = SignV2(resp, testV2APIKey, SignTypeMD5)
+		w.Write(EncodeV2XML(resp))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	config := V2Config{Appid: "wx1", MchID: "10000100", APIKey: testV2APIKey, HTTPClient: ts.Client(), BaseURL: ts.URL}
+	client, err := NewV2Client(&config)
+	if err != nil {
+		t.Fatalf("NewV2Client failed: %v", err)
+	}
+	if _, err := client.Reverse(context.Background(), "1217752501201407033233368018"); err == nil {
+		t.Error("Expected error reversing without certificate")
+	}
+
+	config.PrivateKey, config.Certificate = key, cert
+	client, err = NewV2Client(&config)
+	if err != nil {
+		t.Fatalf("NewV2Client failed: %v", err)
+	}
+	recall, err := client.Reverse(context.Background(), "1217752501201407033233368018")
+	if err != nil || recall {
+		t.Errorf("Reverse: recall=%v err=%v", recall, err)
+	}
+}
@@ -0,0 +1,62 @@
+package wechatpay
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Verifier checks a Wechatpay-Signature against the platform certificate
+// identified by the Wechatpay-Serial header.
+type Verifier interface {
+	Verify(serial string, message []byte, signature string) error
+}
+
+type certificateVerifier struct {
+	keys map[string]*rsa.PublicKey
+}
+
+func NewCertificateVerifier(certs ...*x509.Certificate) (Verifier, error) {
+	v := &certificateVerifier{keys: make(map[string]*rsa.PublicKey, len(certs))}
+	for _, cert := range certs {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("platform certificate %s is not RSA", CertificateSerial(cert))
+		}
+		v.keys[CertificateSerial(cert)] = pub
+	}
+	return v, nil
+}
+
+func (v *certificateVerifier) Verify(serial string, message []byte, signature string) error {
+	pub, ok := v.keys[strings.ToUpper(serial)]
+	if !ok {
+		return fmt.Errorf("unknown platform certificate serial: %s", serial)
+	}
+	return verifySignature(pub, message, signature)
+}
+
+func verifySignature(pub *rsa.PublicKey, message []byte, signature string) error {
+	sigBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256(message)
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sigBytes)
+}
+
+func buildVerifyMessage(timestamp, nonce string, body []byte) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body))
+}
+
+// CertificateSerial returns the serial number in the upper-case hex form
+// WeChat Pay uses for serial_no and Wechatpay-Serial, for merchant and
+// platform certificates alike. Every byte is written as two digits, so a
+// serial such as 0A1B keeps its leading zero.
+func CertificateSerial(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", cert.SerialNumber.Bytes())
+}
@@ -0,0 +1,87 @@
+package wechatpay
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func generateTestCertificate(t *testing.T, key *rsa.PrivateKey, serial int64, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert
+}
+
+func signTestMessage(t *testing.T, key *rsa.PrivateKey, message []byte) string {
+	t.Helper()
+	hashed := sha256.Sum256(message)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("sign message: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestCertificateVerifier(t *testing.T) {
+	key, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, key, 0x5157F09EFDC096DE, time.Now().Add(time.Hour))
+
+	verifier, err := NewCertificateVerifier(cert)
+	if err != nil {
+		t.Fatalf("NewCertificateVerifier failed: %v", err)
+	}
+
+	message := buildVerifyMessage("1650000000", "nonce", []byte(`{"code":"SUCCESS"}`))
+	signature := signTestMessage(t, key, message)
+
+	if err := verifier.Verify("5157f09efdc096de", message, signature); err != nil {
+		t.Errorf("Valid signature verification failed: %v", err)
+	}
+	if err := verifier.Verify("UNKNOWN", message, signature); err == nil {
+		t.Error("Unknown serial should fail")
+	}
+	if err := verifier.Verify("5157F09EFDC096DE", []byte("tampered"), signature); err == nil {
+		t.Error("Tampered message should fail")
+	}
+}
+
+func TestCertificateSerial(t *testing.T) {
+	key, _ := generateTestKeyPair()
+	cert := generateTestCertificate(t, key, 0x0A1B2C3D4E5F6071, time.Now().Add(time.Hour))
+	if got := CertificateSerial(cert); got != "0A1B2C3D4E5F6071" {
+		t.Errorf("Expected leading zero to be kept, got %s", got)
+	}
+
+	verifier, _ := NewCertificateVerifier(cert)
+	message := buildVerifyMessage("1650000000", "nonce", []byte("{}"))
+	if err := verifier.Verify("0A1B2C3D4E5F6071", message, signTestMessage(t, key, message)); err != nil {
+		t.Errorf("Verify failed for a leading-zero serial: %v", err)
+	}
+}
+
+func TestBuildVerifyMessage(t *testing.T) {
+	got := string(buildVerifyMessage("1650000000", "abc", []byte("{}")))
+	if got != "1650000000\nabc\n{}\n" {
+		t.Errorf("Unexpected verify message: %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package wechatpay
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultWaitInitialInterval = 2 * time.Second
+	defaultWaitMaxInterval     = 30 * time.Second
+)
+
+type WaitOptions struct {
+	// Deadline is when an unpaid order is closed with CloseOrder. Zero
+	// waits until ctx is done without closing the order.
+	Deadline time.Time
+	// InitialInterval is the first polling interval, doubled after every
+	// query up to MaxInterval. Defaults to 2s and 30s.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// OnStateChange and Events, when set, receive every observed change of
+	// trade_state, including the first one.
+	OnStateChange func(StateChange)
+	Events        chan<- StateChange
+}
+
+type StateChange struct {
+	OutTradeNo  string
+	From        string
+	To          string
+	Transaction *Transaction
+}
+
+// WaitForPayment polls QueryOrder until the order reaches a final trade
+// state and returns it. It covers lost payment notifications: once the
+// deadline passes the order is closed, so the caller always ends up with
+// either a paid or a closed order. Retryable query errors are retried until
+// ctx is done; any other error, such as ORDERNOTEXIST, is returned at once.
+func (c *Client) WaitForPayment(ctx context.Context, outTradeNo string, opts *WaitOptions) (*Transaction, error) {
+	if opts == nil {
+		opts = &WaitOptions{}
+	}
+	interval := opts.InitialInterval
+	if interval <= 0 {
+		interval = defaultWaitInitialInterval
+	}
+	maxInterval := opts.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = defaultWaitMaxInterval
+	}
+
+	state := ""
+	var lastErr error
+	for {
+		tx, err := c.QueryOrder(ctx, outTradeNo)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !IsRetryable(err) {
+				return nil, err
+			}
+			lastErr = err
+		} else {
+			if err := notifyStateChange(ctx, opts, outTradeNo, &state, tx); err != nil {
+				return nil, err
+			}
+			if tx.Final() {
+				return tx, nil
+			}
+		}
+
+		wait := interval
+		if !opts.Deadline.IsZero() {
+			remaining := time.Until(opts.Deadline)
+			if remaining <= 0 {
+				return c.closeExpiredOrder(ctx, outTradeNo, &state, opts)
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w (last query error: %v)", ctx.Err(), lastErr)
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// closeExpiredOrder closes an order past its deadline and queries it once
+// more: the payer may have paid just before the close, in which case the
+// order is SUCCESS and the close error is irrelevant.
+func (c *Client) closeExpiredOrder(ctx context.Context, outTradeNo string, state *string, opts *WaitOptions) (*Transaction, error) {
+	closeErr := c.CloseOrder(ctx, outTradeNo)
+
+	tx, err := c.QueryOrder(ctx, outTradeNo)
+	if err != nil {
+		if closeErr != nil {
+			return nil, closeErr
+		}
+		return nil, err
+	}
+	if err := notifyStateChange(ctx, opts, outTradeNo, state, tx); err != nil {
+		return nil, err
+	}
+	if !tx.Final() {
+		if closeErr != nil {
+			return tx, closeErr
+		}
+		return tx, fmt.Errorf("order %s is still %s after close", outTradeNo, tx.TradeState)
+	}
+	return tx, nil
+}
+
+func notifyStateChange(ctx context.Context, opts *WaitOptions, outTradeNo string, state *string, tx *Transaction) error {
+	if tx.TradeState == *state {
+		return nil
+	}
+	change := StateChange{OutTradeNo: outTradeNo, From: *state, To: tx.TradeState, Transaction: tx}
+	*state = tx.TradeState
+
+	if opts.OnStateChange != nil {
+		opts.OnStateChange(change)
+	}
+	if opts.Events != nil {
+		select {
+		case opts.Events <- change:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
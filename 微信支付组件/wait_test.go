@@ -0,0 +1,137 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestOrderServer serves QueryOrder with the given states in turn, the
+// last one repeating, and CloseOrder by switching the order to CLOSED.
+func newTestOrderServer(t *testing.T, states ...string) (*Client, func() (queries, closes int)) {
+	var mu sync.Mutex
+	queries, closes := 0, 0
+	closed := false
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if strings.HasSuffix(r.URL.Path, "/close") {
+			closes++
+			closed = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		state := states[len(states)-1]
+		if queries < len(states) {
+			state = states[queries]
+		}
+		if closed {
+			state = TradeStateClosed
+		}
+		queries++
+		fmt.Fprintf(w, `{"out_trade_no":"order123","trade_state":%q}`, state)
+	})
+	return client, func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return queries, closes
+	}
+}
+
+func TestWaitForPayment_Success(t *testing.T) {
+	client, counts := newTestOrderServer(t, "NOTPAY", "USERPAYING", "SUCCESS")
+
+	events := make(chan StateChange, 10)
+	var callbacks []StateChange
+	tx, err := client.WaitForPayment(context.Background(), "order123", &WaitOptions{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     2 * time.Millisecond,
+		Events:          events,
+		OnStateChange:   func(change StateChange) { callbacks = append(callbacks, change) },
+	})
+	if err != nil {
+		t.Fatalf("WaitForPayment failed: %v", err)
+	}
+	if tx.TradeState != TradeStateSuccess {
+		t.Errorf("Expected SUCCESS, got %s", tx.TradeState)
+	}
+	if queries, closes := counts(); queries != 3 || closes != 0 {
+		t.Errorf("Expected 3 queries and no close, got %d and %d", queries, closes)
+	}
+
+	close(events)
+	var got []string
+	for change := range events {
+		got = append(got, change.From+">"+change.To)
+	}
+	if strings.Join(got, ",") != ">NOTPAY,NOTPAY>USERPAYING,USERPAYING>SUCCESS" {
+		t.Errorf("Unexpected events: %v", got)
+	}
+	if len(callbacks) != 3 {
+		t.Errorf("Expected 3 callbacks, got %d", len(callbacks))
+	}
+}
+
+func TestWaitForPayment_CloseAtDeadline(t *testing.T) {
+	client, counts := newTestOrderServer(t, "NOTPAY")
+
+	var last StateChange
+	tx, err := client.WaitForPayment(context.Background(), "order123", &WaitOptions{
+		Deadline:        time.Now().Add(20 * time.Millisecond),
+		InitialInterval: 5 * time.Millisecond,
+		OnStateChange:   func(change StateChange) { last = change },
+	})
+	if err != nil {
+		t.Fatalf("WaitForPayment failed: %v", err)
+	}
+	if tx.TradeState != TradeStateClosed || last.From != "NOTPAY" || last.To != "CLOSED" {
+		t.Errorf("Expected CLOSED after deadline, got %s (last event %+v)", tx.TradeState, last)
+	}
+	if _, closes := counts(); closes != 1 {
+		t.Errorf("Expected one close, got %d", closes)
+	}
+}
+
+func TestWaitForPayment_ContextDone(t *testing.T) {
+	client, _ := newTestOrderServer(t, "NOTPAY")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.WaitForPayment(ctx, "order123", &WaitOptions{InitialInterval: 5 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestWaitForPayment_NonRetryableError(t *testing.T) {
+	var queries, closes int
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/close") {
+			closes++
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		queries++
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"ORDERNOTEXIST","message":"订单不存在"}`))
+	})
+
+	_, err := client.WaitForPayment(context.Background(), "order123", &WaitOptions{
+		Deadline:        time.Now().Add(20 * time.Millisecond),
+		InitialInterval: time.Millisecond,
+	})
+	if !errors.Is(err, ErrOrderNotExist) {
+		t.Errorf("Expected ErrOrderNotExist, got %v", err)
+	}
+	if queries != 1 || closes != 0 {
+		t.Errorf("Expected one query and no close, got %d and %d", queries, closes)
+	}
+}
@@ -2,6 +2,8 @@ package wechatpay
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -9,7 +11,7 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -22,15 +24,63 @@ type Client struct {
 	mchID      string
 	serialNo   string
 	privateKey *rsa.PrivateKey
+	verifier   Verifier
+	httpClient *http.Client
+	baseURL    string
 }
 
-// NewClient 创建新客户端
+// NewClient 创建新客户端，privateKeyPEM 为 apiclient_key.pem 内容，支持 PKCS#1 与 PKCS#8
 func NewClient(mchID, serialNo string, privateKeyPEM []byte) (*Client, error) {
+	rsaKey, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		mchID:      mchID,
+		serialNo:   serialNo,
+		privateKey: rsaKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		baseURL:    apiHost,
+	}, nil
+}
+
+// NewClientWithCertificate 创建新客户端，证书序列号从 apiclient_cert.pem 中读取
+func NewClientWithCertificate(mchID string, privateKeyPEM, certPEM []byte) (*Client, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to parse certificate PEM block")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(mchID, certificateSerial(cert), privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || !pub.Equal(&client.privateKey.PublicKey) {
+		return nil, fmt.Errorf("private key does not match certificate")
+	}
+	return client, nil
+}
+
+// certificateSerial 返回微信支付使用的大写十六进制证书序列号，每个字节两位，保留开头的 0
+func certificateSerial(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", cert.SerialNumber.Bytes())
+}
+
+func parsePrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(privateKeyPEM)
 	if block == nil {
 		return nil, fmt.Errorf("failed to parse PEM block")
 	}
 
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
 	privKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
 		return nil, err
@@ -40,20 +90,30 @@ func NewClient(mchID, serialNo string, privateKeyPEM []byte) (*Client, error) {
 	if !ok {
 		return nil, fmt.Errorf("private key is not RSA")
 	}
+	return rsaKey, nil
+}
 
-	return &Client{
-		mchID:      mchID,
-		serialNo:   serialNo,
-		privateKey: rsaKey,
-	}, nil
+// SetBaseURL 修改接口地址，例如指向微信支付组件的 Sandbox 以离线运行退款流程
+func (c *Client) SetBaseURL(baseURL string) {
+	c.baseURL = strings.TrimSuffix(baseURL, "/")
 }
 
-// doRequest 发送HTTP请求
-func (c *Client) doRequest(method, urlStr string, body []byte) ([]byte, error) {
+// SetVerifier 设置平台证书验签器，所有应答都会校验签名；未设置时客户端拒绝发送请求
+func (c *Client) SetVerifier(verifier Verifier) {
+	c.verifier = verifier
+}
+
+// doRequest 发送HTTP请求，非200应答解析为 *APIError
+func (c *Client) doRequest(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	if c.verifier == nil {
+		return nil, ErrNoVerifier
+	}
+
 	timestamp := time.Now().Unix()
 	nonce := generateNonce(16)
 	bodyStr := string(body)
 
+	urlStr := c.baseURL + path
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, err
@@ -68,9 +128,9 @@ func (c *Client) doRequest(method, urlStr string, body []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	authHeader := c.buildAuthorization("WECHATPAY2-SHA256-RSA2048", signature, nonce, timestamp)
+	authHeader := c.buildAuthorization(authType, signature, nonce, timestamp)
 
-	req, err := http.NewRequest(method, urlStr, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -82,18 +142,26 @@ func (c *Client) doRequest(method, urlStr string, body []byte) ([]byte, error) {
 	req.Header.Set("Wechatpay-Nonce", nonce)
 	req.Header.Set("Wechatpay-Serial", c.serialNo)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %s", resp.Status)
+		return nil, parseAPIError(resp, respBody)
+	}
+
+	if err := verifyResponse(resp.Header, respBody, c.verifier); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
 	}
 
-	return ioutil.ReadAll(resp.Body)
+	return respBody, nil
 }
 
 // signRequest 对请求进行签名
@@ -1,26 +1,31 @@
-package wechatpay_test
+package wechatpay
 
 import (
-	"bytes"
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
-	
-	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
-	
-	"path/to/your/package/wechatpay"
 )
 
 func generateTestPrivateKey(t *testing.T) *rsa.PrivateKey {
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	require.NoError(t, err)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
 	return privateKey
 }
 
@@ -32,114 +37,230 @@ func encodePrivateKeyToPEM(key *rsa.PrivateKey) []byte {
 	})
 }
 
+// newTestClient 创建指向模拟服务器的客户端，应答带签名头并由 stubVerifier 验签
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Wechatpay-Signature", "c2lnbmF0dXJl")
+		w.Header().Set("Wechatpay-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+		w.Header().Set("Wechatpay-Nonce", "nonce")
+		w.Header().Set("Wechatpay-Serial", "5157F09EFDC096DE")
+		handler(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	client, err := NewClient("mch123", "serial001", encodePrivateKeyToPEM(generateTestPrivateKey(t)))
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	client.baseURL = ts.URL
+	client.SetVerifier(&stubVerifier{})
+	return client
+}
+
 func TestNewClient(t *testing.T) {
 	t.Run("创建有效客户端", func(t *testing.T) {
 		privateKey := generateTestPrivateKey(t)
 		pem := encodePrivateKeyToPEM(privateKey)
-		
-		client, err := wechatpay.NewClient("mch123", "serial001", pem)
-		require.NoError(t, err)
-		assert.NotNil(t, client)
-		assert.Equal(t, "mch123", client.MchID)
-		assert.Equal(t, "serial001", client.SerialNo)
+
+		client, err := NewClient("mch123", "serial001", pem)
+		if err != nil {
+			t.Fatalf("创建客户端失败: %v", err)
+		}
+		if client.mchID != "mch123" || client.serialNo != "serial001" || client.baseURL != apiHost {
+			t.Errorf("客户端字段不匹配: %+v", client)
+		}
 	})
-	
+
 	t.Run("无效PEM格式", func(t *testing.T) {
-		_, err := wechatpay.NewClient("mch123", "serial001", []byte("INVALID_PEM"))
-		require.Error(t, err)
-		assert.Contains(t, err.Error(), "failed to parse PEM block")
+		_, err := NewClient("mch123", "serial001", []byte("INVALID_PEM"))
+		if err == nil || !strings.Contains(err.Error(), "failed to parse PEM block") {
+			t.Errorf("期望PEM解析错误，实际: %v", err)
+		}
 	})
-	
+
 	t.Run("非RSA密钥", func(t *testing.T) {
 		invalidPem := pem.EncodeToMemory(&pem.Block{
 			Type:  "CERTIFICATE",
 			Bytes: []byte("non-rsa-key"),
 		})
-		_, err := wechatpay.NewClient("mch123", "serial001", invalidPem)
-		require.Error(t, err)
-		assert.Contains(t, err.Error(), "private key is not RSA")
+		if _, err := NewClient("mch123", "serial001", invalidPem); err == nil {
+			t.Error("期望返回错误")
+		}
+	})
+
+	t.Run("PKCS1私钥", func(t *testing.T) {
+		privateKey := generateTestPrivateKey(t)
+		pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+		client, err := NewClient("mch123", "serial001", pkcs1)
+		if err != nil {
+			t.Fatalf("创建客户端失败: %v", err)
+		}
+		if !client.privateKey.Equal(privateKey) {
+			t.Error("私钥不匹配")
+		}
 	})
 }
 
-func TestClient_DoRequest(t *testing.T) {
-	// 准备测试数据
+func TestSetBaseURL(t *testing.T) {
+	var path string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"refund_id":"REF1"}`))
+	})
+	client.SetBaseURL(client.baseURL + "/")
+	if _, err := client.Refund(context.Background(), RefundRequest{OutTradeNo: "O1", OutRefundNo: "R1", Amount: 1, TotalAmount: 1}); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if path != refundPath {
+		t.Errorf("请求路径不匹配: %s", path)
+	}
+}
+
+func TestNewClientWithCertificate(t *testing.T) {
 	privateKey := generateTestPrivateKey(t)
-	pem := encodePrivateKeyToPEM(privateKey)
-	client, _ := wechatpay.NewClient("mch123", "serial001", pem)
-	
-	// 创建模拟HTTP服务器
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	template := &x509.Certificate{
+		SerialNumber: new(big.Int).SetBytes([]byte{0x07, 0x75, 0xb6, 0xa4, 0x5a, 0xcd}),
+		Subject:      pkix.Name{CommonName: "mch123"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	client, err := NewClientWithCertificate("mch123", encodePrivateKeyToPEM(privateKey), certPEM)
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	if client.serialNo != "0775B6A45ACD" {
+		t.Errorf("证书序列号不匹配: %s", client.serialNo)
+	}
+
+	other := encodePrivateKeyToPEM(generateTestPrivateKey(t))
+	if _, err := NewClientWithCertificate("mch123", other, certPEM); err == nil {
+		t.Error("私钥与证书不匹配时应返回错误")
+	}
+}
+
+func TestClient_DoRequest(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		// 验证请求头
-		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
-		assert.Equal(t, "application/json", r.Header.Get("Accept"))
-		assert.NotEmpty(t, r.Header.Get("Authorization"))
-		assert.NotEmpty(t, r.Header.Get("Wechatpay-Timestamp"))
-		assert.NotEmpty(t, r.Header.Get("Wechatpay-Nonce"))
-		assert.Equal(t, "serial001", r.Header.Get("Wechatpay-Serial"))
-		
-		// 返回成功响应
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Accept") != "application/json" {
+			t.Errorf("请求头不匹配: %v", r.Header)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), authType+" ") {
+			t.Errorf("Authorization 格式错误: %s", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("Wechatpay-Timestamp") == "" || r.Header.Get("Wechatpay-Nonce") == "" {
+			t.Error("缺少时间戳或随机串")
+		}
+		if r.Header.Get("Wechatpay-Serial") != "serial001" {
+			t.Errorf("证书序列号不匹配: %s", r.Header.Get("Wechatpay-Serial"))
+		}
+
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status": "ok"}`))
-	}))
-	defer ts.Close()
-	
-	// 执行请求
-	response, err := client.DoRequest("POST", ts.URL, []byte(`{"amount":100}`))
-	require.NoError(t, err)
-	assert.JSONEq(t, `{"status": "ok"}`, string(response))
+	})
+
+	response, err := client.doRequest(context.Background(), "POST", "/v3/pay", []byte(`{"amount":100}`))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	if string(response) != `{"status": "ok"}` {
+		t.Errorf("应答不匹配: %s", response)
+	}
+}
+
+func TestDoRequest_Verifier(t *testing.T) {
+	var calls int
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"status": "ok"}`))
+	})
+
+	t.Run("验签失败", func(t *testing.T) {
+		client.SetVerifier(&stubVerifier{err: errors.New("bad signature")})
+		if _, err := client.doRequest(context.Background(), "GET", "/v3/pay", nil); !errors.Is(err, ErrInvalidResponse) {
+			t.Errorf("期望 ErrInvalidResponse，实际: %v", err)
+		}
+	})
+
+	t.Run("未设置验签器", func(t *testing.T) {
+		client.SetVerifier(nil)
+		calls = 0
+		if _, err := client.doRequest(context.Background(), "GET", "/v3/pay", nil); !errors.Is(err, ErrNoVerifier) {
+			t.Errorf("期望 ErrNoVerifier，实际: %v", err)
+		}
+		if calls != 0 {
+			t.Error("未设置验签器时不应发出请求")
+		}
+	})
 }
 
 func TestDoRequest_HTTPError(t *testing.T) {
-	// 准备测试数据
-	privateKey := generateTestPrivateKey(t)
-	pem := encodePrivateKeyToPEM(privateKey)
-	client, _ := wechatpay.NewClient("mch123", "serial001", pem)
-	
-	// 创建返回500错误的模拟服务器
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Request-ID", "08F78BB5AF0610D302A5BB7506A5FF2D")
 		w.WriteHeader(http.StatusInternalServerError)
-	}))
-	defer ts.Close()
-	
-	// 执行请求
-	_, err := client.DoRequest("POST", ts.URL, []byte(`{}`))
-	require.Error(t, err)
-	assert.Contains(t, err.Error(), "HTTP error: 500 Internal Server Error")
+		w.Write([]byte(`{"code":"SYSTEM_ERROR","message":"系统错误"}`))
+	})
+
+	_, err := client.doRequest(context.Background(), "POST", "/v3/pay", []byte(`{}`))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("期望 *APIError，实际: %v", err)
+	}
+	if apiErr.StatusCode != 500 || apiErr.Code != "SYSTEM_ERROR" || apiErr.RequestID != "08F78BB5AF0610D302A5BB7506A5FF2D" {
+		t.Errorf("错误字段不匹配: %+v", apiErr)
+	}
+	if !IsRetryable(err) {
+		t.Error("系统错误应可重试")
+	}
 }
 
 func TestSignRequest(t *testing.T) {
 	privateKey := generateTestPrivateKey(t)
-	pem := encodePrivateKeyToPEM(privateKey)
-	client, _ := wechatpay.NewClient("mch123", "serial001", pem)
-	
-	timestamp := time.Now().Unix()
-	nonce := "random_nonce"
-	
-	signature, err := client.SignRequest("POST", "/v3/pay", "request_body", timestamp, nonce)
-	require.NoError(t, err)
-	assert.NotEmpty(t, signature)
-	
-	// 验证签名格式为base64
-	_, err = base64.StdEncoding.DecodeString(signature)
-	assert.NoError(t, err)
+	client, _ := NewClient("mch123", "serial001", encodePrivateKeyToPEM(privateKey))
+
+	signature, err := client.signRequest("POST", "/v3/pay", "request_body", 1234567890, "random_nonce")
+	if err != nil {
+		t.Fatalf("签名失败: %v", err)
+	}
+
+	// 验证签名可被公钥验证
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatalf("签名不是base64: %v", err)
+	}
+	hashed := sha256.Sum256([]byte(fmt.Sprintf("POST\n/v3/pay\n1234567890\nrandom_nonce\nrequest_body\n")))
+	if err := rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, hashed[:], sig); err != nil {
+		t.Errorf("验签失败: %v", err)
+	}
 }
 
 func TestBuildAuthorization(t *testing.T) {
-	privateKey := generateTestPrivateKey(t)
-	pem := encodePrivateKeyToPEM(privateKey)
-	client, _ := wechatpay.NewClient("mch123", "serial001", pem)
-	
-	authHeader := client.BuildAuthorization("WECHATPAY2-SHA256-RSA2048", "signature_data", "nonce123", 1234567890)
+	client, _ := NewClient("mch123", "serial001", encodePrivateKeyToPEM(generateTestPrivateKey(t)))
+
+	authHeader := client.buildAuthorization("WECHATPAY2-SHA256-RSA2048", "signature_data", "nonce123", 1234567890)
 	expected := `WECHATPAY2-SHA256-RSA2048 mchid="mch123",nonce_str="nonce123",signature="signature_data",timestamp="1234567890",serial_no="serial001"`
-	assert.Equal(t, expected, authHeader)
+	if authHeader != expected {
+		t.Errorf("期望: %s\n实际: %s", expected, authHeader)
+	}
 }
 
 func TestGenerateNonce(t *testing.T) {
-	nonce := wechatpay.GenerateNonce(16)
-	assert.Len(t, nonce, 16)
-	
+	nonce := generateNonce(16)
+	if len(nonce) != 16 {
+		t.Fatalf("期望长度16，实际 %d", len(nonce))
+	}
+
 	// 验证字符集
 	for _, c := range nonce {
-		assert.True(t, (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'))
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			t.Errorf("非法字符: %c", c)
+		}
 	}
 }
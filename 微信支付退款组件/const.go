@@ -1,16 +1,30 @@
-package wechatpayrefund
+package wechatpay
 
 import "errors"
 
 const (
-    apiHost    = "https://api.mch.weixin.qq.com"
-    refundPath = "/v3/refund/domestic/refunds"
-    queryPath  = "/v3/refund/domestic/refunds/"
-    authType   = "WECHATPAY2-SHA256-RSA2048"
+	apiHost    = "https://api.mch.weixin.qq.com"
+	refundPath = "/v3/refund/domestic/refunds"
+	queryPath  = "/v3/refund/domestic/refunds/"
+	authType   = "WECHATPAY2-SHA256-RSA2048"
+)
+
+// 退款状态
+const (
+	RefundStatusProcessing = "PROCESSING"
+	RefundStatusSuccess    = "SUCCESS"
+	RefundStatusClosed     = "CLOSED"
+	RefundStatusAbnormal   = "ABNORMAL"
 )
 
 var (
-    ErrInvalidRequest  = errors.New("invalid request")
-    ErrRequestFailed   = errors.New("request failed")
-    ErrInvalidResponse = errors.New("invalid response")
+	ErrInvalidRequest  = errors.New("invalid request")
+	ErrRequestFailed   = errors.New("request failed")
+	ErrInvalidResponse = errors.New("invalid response")
+	// ErrInvalidNotification 回调验签、时间戳或解密失败
+	ErrInvalidNotification = errors.New("invalid notification")
+	// ErrNoVerifier 未调用 SetVerifier，无法校验应答签名
+	ErrNoVerifier = errors.New("platform certificate verifier is required, call SetVerifier")
+	// ErrOverRefund 退款合计将超过订单金额
+	ErrOverRefund = errors.New("refund exceeds order amount")
 )
@@ -1,4 +1,4 @@
-package wechatpayrefund
+package wechatpay
 
 import (
 	"testing"
@@ -50,9 +50,9 @@ func TestErrorTypes(t *testing.T) {
 		t.Error("error variables should not be nil")
 	}
 
-	if ErrInvalidRequest == ErrRequestFailed || 
-	   ErrInvalidRequest == ErrInvalidResponse || 
-	   ErrRequestFailed == ErrInvalidResponse {
+	if ErrInvalidRequest == ErrRequestFailed ||
+		ErrInvalidRequest == ErrInvalidResponse ||
+		ErrRequestFailed == ErrInvalidResponse {
 		t.Error("error variables should be distinct")
 	}
 }
@@ -0,0 +1,120 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// 错误码哨兵，可通过 errors.Is 匹配相同错误码的 *APIError
+var (
+	ErrOrderPaid        = &APIError{Code: "ORDERPAID"}
+	ErrOrderNotExist    = &APIError{Code: "ORDERNOTEXIST"}
+	ErrSystemError      = &APIError{Code: "SYSTEMERROR"}
+	ErrFrequencyLimited = &APIError{Code: "FREQUENCY_LIMITED"}
+	ErrNotEnough        = &APIError{Code: "NOT_ENOUGH"}
+	ErrResourceNotExist = &APIError{Code: "RESOURCE_NOT_EXISTS"}
+	ErrParamError       = &APIError{Code: "PARAM_ERROR"}
+	ErrSignError        = &APIError{Code: "SIGN_ERROR"}
+)
+
+// retryableCodes 可原样重试的错误码
+var retryableCodes = map[string]bool{
+	"SYSTEMERROR":       true,
+	"SYSTEM_ERROR":      true,
+	"FREQUENCY_LIMITED": true,
+	"BANKERROR":         true,
+	"RATELIMIT_EXCEED":  true,
+}
+
+// APIError 微信支付错误应答，保留HTTP状态码和 Request-ID 便于排查
+type APIError struct {
+	StatusCode int             `json:"-"`
+	RequestID  string          `json:"-"`
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+	// Body 应答不是标准错误JSON时的原始内容
+	Body string `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("wechat pay error: status=%d request_id=%s body=%s", e.StatusCode, e.RequestID, e.Body)
+	}
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("wechat pay error: %s", e.Code)
+	}
+	return fmt.Sprintf("wechat pay error: status=%d code=%s message=%s request_id=%s", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// Is 按错误码匹配哨兵
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Retryable 判断相同请求重试是否可能成功
+func (e *APIError) Retryable() bool {
+	if retryableCodes[e.Code] {
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsAPIError 判断是否为微信支付错误应答
+func IsAPIError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr)
+}
+
+// IsRetryable 判断错误是否可重试：可重试的错误码和网络错误返回 true，
+// 参数错误、业务拒绝（如余额不足）和已取消或超时的 context 返回 false
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if contextDone(err) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// contextDone 判断错误是否由调用方的 context 取消或到期引起。http.Client.Timeout
+// 超时同样匹配 context.DeadlineExceeded，但属于可重试的网络超时，不算在内
+func contextDone(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err == context.DeadlineExceeded {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAPIError 解析非200应答
+func parseAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("Request-ID"),
+	}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = ""
+		apiErr.Body = string(body)
+	}
+	return apiErr
+}
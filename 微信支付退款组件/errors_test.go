@@ -0,0 +1,61 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseAPIError(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Request-Id": []string{"08F78BB5AF0610D302A5BB7506A5FF2D"}},
+	}
+	apiErr := parseAPIError(resp, []byte(`{"code":"FREQUENCY_LIMITED","message":"频率限制","detail":{"field":"out_refund_no"}}`))
+
+	if apiErr.Code != "FREQUENCY_LIMITED" || apiErr.RequestID != "08F78BB5AF0610D302A5BB7506A5FF2D" || string(apiErr.Detail) != `{"field":"out_refund_no"}` {
+		t.Errorf("错误字段不匹配: %+v", apiErr)
+	}
+
+	wrapped := fmt.Errorf("退款失败: %w", apiErr)
+	if !errors.Is(wrapped, ErrFrequencyLimited) || errors.Is(wrapped, ErrNotEnough) {
+		t.Error("哨兵匹配失败")
+	}
+	if !IsAPIError(wrapped) || !IsRetryable(wrapped) {
+		t.Error("频率限制应为可重试的 APIError")
+	}
+
+	raw := parseAPIError(&http.Response{StatusCode: 502, Header: http.Header{}}, []byte("bad gateway"))
+	if raw.Code != "" || raw.Body != "bad gateway" || !raw.Retryable() {
+		t.Errorf("非JSON应答解析不正确: %+v", raw)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"system error", &APIError{StatusCode: 500, Code: "SYSTEM_ERROR"}, true},
+		{"order paid", &APIError{StatusCode: 400, Code: "ORDERPAID"}, false},
+		{"not enough", &APIError{StatusCode: 403, Code: "NOT_ENOUGH"}, false},
+		{"param error", &APIError{StatusCode: 400, Code: "PARAM_ERROR"}, false},
+		{"plain error", errors.New("boom"), false},
+		{"network", &url.Error{Op: "Post", URL: apiHost, Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"canceled", &url.Error{Op: "Post", URL: apiHost, Err: context.Canceled}, false},
+		{"deadline exceeded", &url.Error{Op: "Post", URL: apiHost, Err: context.DeadlineExceeded}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 台账独有的退款状态，其余状态与 RefundStatus 常量一致
+const (
+	// LedgerStatusPending 已登记但结果未知：请求未发出、超时、网络错误或应答无法解析、验签失败，需重试或对账
+	LedgerStatusPending = "PENDING"
+	// LedgerStatusFailed 微信支付明确拒绝或请求未发出，未产生退款，金额不再占用
+	LedgerStatusFailed = "FAILED"
+)
+
+// defaultReconcileGrace 对账时微信支付查不到的 PENDING 退款，登记后超过该时长才标记为 FAILED，
+// 避免与仍在进行中的申请退款请求竞争
+const defaultReconcileGrace = 5 * time.Minute
+
+// LedgerRecord 一笔订单的退款台账
+type LedgerRecord struct {
+	OutTradeNo  string         `json:"out_trade_no"`
+	TotalAmount int64          `json:"total_amount"`
+	Entries     []*LedgerEntry `json:"entries"`
+}
+
+// LedgerEntry 台账中的一次退款
+type LedgerEntry struct {
+	// RequestID 调用方的幂等键，例如工作流执行ID；同一 RequestID 重试时复用 OutRefundNo
+	RequestID   string    `json:"request_id"`
+	OutRefundNo string    `json:"out_refund_no"`
+	SubMchID    string    `json:"sub_mchid,omitempty"`
+	Amount      int64     `json:"amount"`
+	Status      string    `json:"status"`
+	RefundID    string    `json:"refund_id,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Refunded 已退款或可能退款的金额合计，FAILED 与 CLOSED 的退款不占用金额
+func (r *LedgerRecord) Refunded() int64 {
+	var sum int64
+	for _, entry := range r.Entries {
+		if entry.Status != LedgerStatusFailed && entry.Status != RefundStatusClosed {
+			sum += entry.Amount
+		}
+	}
+	return sum
+}
+
+// Remaining 订单剩余可退金额
+func (r *LedgerRecord) Remaining() int64 {
+	return r.TotalAmount - r.Refunded()
+}
+
+func (r *LedgerRecord) entry(match func(*LedgerEntry) bool) *LedgerEntry {
+	for _, entry := range r.Entries {
+		if match(entry) {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (r *LedgerRecord) clone() *LedgerRecord {
+	c := &LedgerRecord{OutTradeNo: r.OutTradeNo, TotalAmount: r.TotalAmount, Entries: make([]*LedgerEntry, len(r.Entries))}
+	for i, entry := range r.Entries {
+		e := *entry
+		c.Entries[i] = &e
+	}
+	return c
+}
+
+// final 退款状态不会再变化
+func (e *LedgerEntry) final() bool {
+	return e.Status == RefundStatusSuccess || e.Status == RefundStatusClosed || e.Status == LedgerStatusFailed
+}
+
+// RefundLedger 退款台账：每次退款前按 out_trade_no 登记并占用金额，拒绝超额退款；
+// 相同 RequestID 的重试复用原 out_refund_no，微信支付据此返回同一笔退款而不会重复退款
+type RefundLedger struct {
+	client *Client
+	store  LedgerStore
+	grace  time.Duration
+	now    func() time.Time
+}
+
+func NewRefundLedger(client *Client, store LedgerStore) *RefundLedger {
+	return &RefundLedger{client: client, store: store, grace: defaultReconcileGrace, now: time.Now}
+}
+
+// Refund 登记并申请退款。requestID 必填；req.OutRefundNo 为空时自动生成，
+// 同一 requestID 再次调用时忽略 req.OutRefundNo，沿用首次登记的退款单号
+func (l *RefundLedger) Refund(ctx context.Context, requestID string, req RefundRequest) (*RefundResponse, error) {
+	if requestID == "" {
+		return nil, fmt.Errorf("%w: request id is required", ErrInvalidRequest)
+	}
+	if req.OutTradeNo == "" {
+		return nil, fmt.Errorf("%w: out_trade_no is required for the refund ledger", ErrInvalidRequest)
+	}
+	if req.Amount <= 0 || req.TotalAmount <= 0 {
+		return nil, fmt.Errorf("%w: amount and total amount must be positive", ErrInvalidRequest)
+	}
+
+	var entry LedgerEntry
+	err := l.store.Update(req.OutTradeNo, func(record *LedgerRecord) error {
+		if record.TotalAmount == 0 {
+			record.TotalAmount = req.TotalAmount
+		} else if record.TotalAmount != req.TotalAmount {
+			return fmt.Errorf("%w: total amount %d differs from recorded %d", ErrInvalidRequest, req.TotalAmount, record.TotalAmount)
+		}
+
+		now := l.now()
+		existing := record.entry(func(e *LedgerEntry) bool { return e.RequestID == requestID })
+		if existing != nil {
+			if existing.Amount != req.Amount {
+				return fmt.Errorf("%w: request %s was recorded with amount %d", ErrInvalidRequest, requestID, existing.Amount)
+			}
+			switch existing.Status {
+			case LedgerStatusFailed:
+				// 被拒绝的退款重新占用金额后以原单号重试
+				if existing.Amount > record.Remaining() {
+					return fmt.Errorf("%w: refund %d, remaining %d", ErrOverRefund, existing.Amount, record.Remaining())
+				}
+				existing.Status = LedgerStatusPending
+				existing.Error = ""
+				existing.UpdatedAt = now
+			case LedgerStatusPending:
+				// 重新计算对账宽限期，避免对账与本次请求竞争
+				existing.UpdatedAt = now
+			}
+			entry = *existing
+			return nil
+		}
+
+		outRefundNo := req.OutRefundNo
+		if outRefundNo == "" {
+			outRefundNo = "RF" + now.Format("20060102150405") + generateNonce(12)
+		} else if record.entry(func(e *LedgerEntry) bool { return e.OutRefundNo == outRefundNo }) != nil {
+			return fmt.Errorf("%w: out_refund_no %s belongs to another request", ErrInvalidRequest, outRefundNo)
+		}
+		if req.Amount > record.Remaining() {
+			return fmt.Errorf("%w: refund %d, remaining %d", ErrOverRefund, req.Amount, record.Remaining())
+		}
+		entry = LedgerEntry{
+			RequestID:   requestID,
+			OutRefundNo: outRefundNo,
+			SubMchID:    req.SubMchID,
+			Amount:      req.Amount,
+			Status:      LedgerStatusPending,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		record.Entries = append(record.Entries, &entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req.OutRefundNo = entry.OutRefundNo
+	resp, refundErr := l.client.Refund(ctx, req)
+
+	var overErr error
+	err = l.store.Update(req.OutTradeNo, func(record *LedgerRecord) error {
+		e := record.entry(func(e *LedgerEntry) bool { return e.OutRefundNo == entry.OutRefundNo })
+		if e == nil {
+			return fmt.Errorf("ledger entry %s disappeared", entry.OutRefundNo)
+		}
+		if refundErr != nil {
+			// 只有确定未产生退款时才释放金额，其余错误保持 PENDING 等待重试或对账；
+			// 已受理的退款不因重试被拒而释放金额
+			if e.Status == LedgerStatusPending && refundRejected(refundErr) {
+				e.Status = LedgerStatusFailed
+			}
+			e.Error = refundErr.Error()
+			e.UpdatedAt = l.now()
+			return nil
+		}
+		overErr = l.applyStatus(record, e, resp.Status, resp.RefundID)
+		return nil
+	})
+	if refundErr != nil {
+		return nil, refundErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if overErr != nil {
+		return resp, overErr
+	}
+	return resp, nil
+}
+
+// applyStatus 把微信支付返回的退款状态写入台账。终态不会被覆盖，例如先到达的 SUCCESS 回调
+// 不会被随后的 PROCESSING 应答改回；唯一的例外是 FAILED 的退款被微信支付确认受理，
+// 此时重新占用金额，超过订单金额时记录在 Error 中并返回 ErrOverRefund
+func (l *RefundLedger) applyStatus(record *LedgerRecord, e *LedgerEntry, status, refundID string) error {
+	if refundID != "" {
+		e.RefundID = refundID
+	}
+	if status == e.Status {
+		return nil
+	}
+	if e.final() && !(e.Status == LedgerStatusFailed && status != RefundStatusClosed) {
+		return nil
+	}
+
+	var overErr error
+	if e.Status == LedgerStatusFailed && e.Amount > record.Remaining() {
+		overErr = fmt.Errorf("%w: refund %s of %d was accepted after being released, remaining %d", ErrOverRefund, e.OutRefundNo, e.Amount, record.Remaining())
+		e.Error = overErr.Error()
+	}
+	e.Status = status
+	e.UpdatedAt = l.now()
+	return overErr
+}
+
+// refundRejected 判断退款请求是否确定未产生退款：请求未发出，或微信支付以不可重试的错误码拒绝。
+// 应答无法解析、验签失败、超时等情况下微信支付可能已受理退款，返回 false
+func refundRejected(err error) bool {
+	if errors.Is(err, ErrInvalidRequest) || errors.Is(err, ErrNoVerifier) {
+		return true
+	}
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code != "" && !apiErr.Retryable()
+}
+
+// Record 返回订单的台账记录，不存在时返回 nil
+func (l *RefundLedger) Record(outTradeNo string) (*LedgerRecord, error) {
+	return l.store.Get(outTradeNo)
+}
+
+// Reconcile 查询所有未终结的退款并更新状态。微信支付上不存在且登记超过 5 分钟的 PENDING 退款
+// 标记为 FAILED 并释放金额。单笔查询失败不影响其余退款，返回遇到的第一个错误
+func (l *RefundLedger) Reconcile(ctx context.Context) (updated []LedgerEntry, err error) {
+	records, err := l.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	for _, record := range records {
+		for _, entry := range record.Entries {
+			if entry.final() {
+				continue
+			}
+			resp, queryErr := l.client.QueryRefund(ctx, QueryRequest{SubMchID: entry.SubMchID, OutRefundNo: entry.OutRefundNo})
+			status, refundID := "", ""
+			switch {
+			case queryErr == nil:
+				status, refundID = resp.Status, resp.RefundID
+			case errors.Is(queryErr, ErrResourceNotExist) && entry.Status == LedgerStatusPending:
+				if l.now().Sub(entry.UpdatedAt) < l.grace {
+					// 申请退款可能仍在进行中，微信支付尚未登记
+					continue
+				}
+				status = LedgerStatusFailed
+			default:
+				if firstErr == nil {
+					firstErr = fmt.Errorf("query refund %s: %w", entry.OutRefundNo, queryErr)
+				}
+				continue
+			}
+			if status == entry.Status {
+				continue
+			}
+
+			e, updateErr := l.setStatus(record.OutTradeNo, entry.OutRefundNo, status, refundID)
+			if updateErr != nil && firstErr == nil {
+				firstErr = updateErr
+			}
+			if e != nil {
+				updated = append(updated, *e)
+			}
+		}
+	}
+	return updated, firstErr
+}
+
+// HandleNotification 用退款结果回调更新台账，可直接作为 NewRefundNotifyHandler 的处理函数。
+// 已释放金额的 FAILED 退款被确认受理且超过订单金额时返回 ErrOverRefund
+func (l *RefundLedger) HandleNotification(ctx context.Context, event *RefundEvent) error {
+	refund := event.Refund
+	if refund.OutTradeNo == "" || refund.OutRefundNo == "" {
+		return fmt.Errorf("%w: notification without out_trade_no or out_refund_no", ErrInvalidNotification)
+	}
+	record, err := l.store.Get(refund.OutTradeNo)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.entry(func(e *LedgerEntry) bool { return e.OutRefundNo == refund.OutRefundNo }) == nil {
+		// 未经台账发起的退款，忽略
+		return nil
+	}
+	_, err = l.setStatus(refund.OutTradeNo, refund.OutRefundNo, refund.RefundStatus, refund.RefundID)
+	return err
+}
+
+// setStatus 按 applyStatus 的规则更新退款状态，状态未变化时返回 nil
+func (l *RefundLedger) setStatus(outTradeNo, outRefundNo, status, refundID string) (*LedgerEntry, error) {
+	var updated *LedgerEntry
+	var overErr error
+	err := l.store.Update(outTradeNo, func(record *LedgerRecord) error {
+		e := record.entry(func(e *LedgerEntry) bool { return e.OutRefundNo == outRefundNo })
+		if e == nil {
+			return fmt.Errorf("ledger entry %s not found", outRefundNo)
+		}
+		previous := e.Status
+		overErr = l.applyStatus(record, e, status, refundID)
+		if e.Status != previous {
+			copied := *e
+			updated = &copied
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, overErr
+}
@@ -0,0 +1,157 @@
+package wechatpay
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// LedgerStore 退款台账存储，按 out_trade_no 保存记录
+type LedgerStore interface {
+	// Get 返回订单的台账记录副本，不存在时返回 nil
+	Get(outTradeNo string) (*LedgerRecord, error)
+	// Update 原子地修改订单的台账记录，不存在时 fn 收到只含 OutTradeNo 的空记录；fn 返回错误时不保存
+	Update(outTradeNo string, fn func(record *LedgerRecord) error) error
+	// List 按 out_trade_no 排序返回所有记录副本
+	List() ([]*LedgerRecord, error)
+}
+
+// MemoryLedgerStore 内存台账，进程退出后丢失，适合测试或单次运行的工作流
+type MemoryLedgerStore struct {
+	mu      sync.Mutex
+	records map[string]*LedgerRecord
+}
+
+func NewMemoryLedgerStore() *MemoryLedgerStore {
+	return &MemoryLedgerStore{records: map[string]*LedgerRecord{}}
+}
+
+func (s *MemoryLedgerStore) Get(outTradeNo string) (*LedgerRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[outTradeNo]; ok {
+		return record.clone(), nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryLedgerStore) Update(outTradeNo string, fn func(record *LedgerRecord) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateRecords(s.records, outTradeNo, fn)
+}
+
+func (s *MemoryLedgerStore) List() ([]*LedgerRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listRecords(s.records), nil
+}
+
+// FileLedgerStore 以单个 JSON 文件保存台账，每次修改先写临时文件再重命名，
+// 进程崩溃不会留下半写的文件。同一文件只能由一个进程使用
+type FileLedgerStore struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]*LedgerRecord
+}
+
+// NewFileLedgerStore 打开台账文件，文件不存在时在首次修改时创建
+func NewFileLedgerStore(path string) (*FileLedgerStore, error) {
+	s := &FileLedgerStore{path: path, records: map[string]*LedgerRecord{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileLedgerStore) Get(outTradeNo string) (*LedgerRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[outTradeNo]; ok {
+		return record.clone(), nil
+	}
+	return nil, nil
+}
+
+func (s *FileLedgerStore) Update(outTradeNo string, fn func(record *LedgerRecord) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.records[outTradeNo]
+	if err := updateRecords(s.records, outTradeNo, fn); err != nil {
+		return err
+	}
+	if err := s.save(); err != nil {
+		// 写入失败时回滚内存，保持与文件一致
+		if previous == nil {
+			delete(s.records, outTradeNo)
+		} else {
+			s.records[outTradeNo] = previous
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileLedgerStore) List() ([]*LedgerRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listRecords(s.records), nil
+}
+
+func (s *FileLedgerStore) save() error {
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// updateRecords 在副本上执行 fn，成功后替换原记录
+func updateRecords(records map[string]*LedgerRecord, outTradeNo string, fn func(record *LedgerRecord) error) error {
+	record := &LedgerRecord{OutTradeNo: outTradeNo}
+	if existing, ok := records[outTradeNo]; ok {
+		record = existing.clone()
+	}
+	if err := fn(record); err != nil {
+		return err
+	}
+	records[outTradeNo] = record
+	return nil
+}
+
+func listRecords(records map[string]*LedgerRecord) []*LedgerRecord {
+	result := make([]*LedgerRecord, 0, len(records))
+	for _, record := range records {
+		result = append(result, record.clone())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].OutTradeNo < result[j].OutTradeNo })
+	return result
+}
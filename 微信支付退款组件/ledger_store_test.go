@@ -0,0 +1,91 @@
+package wechatpay
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testLedgerStore(t *testing.T, store LedgerStore) {
+	t.Helper()
+
+	if record, err := store.Get("ORDER_1"); err != nil || record != nil {
+		t.Fatalf("期望记录不存在: %+v, %v", record, err)
+	}
+
+	err := store.Update("ORDER_1", func(record *LedgerRecord) error {
+		if record.OutTradeNo != "ORDER_1" || len(record.Entries) != 0 {
+			t.Errorf("新记录不匹配: %+v", record)
+		}
+		record.TotalAmount = 1000
+		record.Entries = append(record.Entries, &LedgerEntry{RequestID: "wf-1", OutRefundNo: "REF_1", Amount: 600, Status: LedgerStatusPending})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+
+	// fn 返回错误时不保存
+	rejected := errors.New("rejected")
+	err = store.Update("ORDER_1", func(record *LedgerRecord) error {
+		record.Entries[0].Status = RefundStatusSuccess
+		return rejected
+	})
+	if !errors.Is(err, rejected) {
+		t.Errorf("期望返回 fn 的错误，实际 %v", err)
+	}
+
+	record, err := store.Get("ORDER_1")
+	if err != nil || record == nil {
+		t.Fatalf("读取失败: %+v, %v", record, err)
+	}
+	if record.TotalAmount != 1000 || len(record.Entries) != 1 || record.Entries[0].Status != LedgerStatusPending {
+		t.Errorf("记录不匹配: %+v", record.Entries[0])
+	}
+
+	// 返回的是副本，修改不影响存储
+	record.Entries[0].Amount = 1
+	if again, _ := store.Get("ORDER_1"); again.Entries[0].Amount != 600 {
+		t.Error("修改副本不应影响存储")
+	}
+
+	store.Update("ORDER_0", func(record *LedgerRecord) error { record.TotalAmount = 1; return nil })
+	records, err := store.List()
+	if err != nil || len(records) != 2 || records[0].OutTradeNo != "ORDER_0" {
+		t.Errorf("列表不匹配: %+v, %v", records, err)
+	}
+}
+
+func TestMemoryLedgerStore(t *testing.T) {
+	testLedgerStore(t, NewMemoryLedgerStore())
+}
+
+func TestFileLedgerStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "refunds.json")
+	store, err := NewFileLedgerStore(path)
+	if err != nil {
+		t.Fatalf("打开台账失败: %v", err)
+	}
+	testLedgerStore(t, store)
+
+	// 重新打开后数据仍在
+	reopened, err := NewFileLedgerStore(path)
+	if err != nil {
+		t.Fatalf("重新打开台账失败: %v", err)
+	}
+	record, _ := reopened.Get("ORDER_1")
+	if record == nil || record.Entries[0].OutRefundNo != "REF_1" || record.Remaining() != 400 {
+		t.Errorf("持久化记录不匹配: %+v", record)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("不应残留临时文件: %v", entries)
+	}
+
+	os.WriteFile(path, []byte("{broken"), 0o600)
+	if _, err := NewFileLedgerStore(path); err == nil {
+		t.Error("文件损坏时期望返回错误")
+	}
+}
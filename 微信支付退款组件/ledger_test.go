@@ -0,0 +1,359 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRefundServer 模拟微信支付退款接口：相同 out_refund_no 返回同一笔退款
+type testRefundServer struct {
+	mu      sync.Mutex
+	refunds map[string]string
+	calls   int
+	// fail 非空时申请退款返回该应答
+	fail func(w http.ResponseWriter) bool
+}
+
+func newTestLedger(t *testing.T, server *testRefundServer) *RefundLedger {
+	t.Helper()
+	server.refunds = map[string]string{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		if r.Method == "GET" {
+			outRefundNo := strings.TrimPrefix(r.URL.Path, queryPath)
+			status, ok := server.refunds[outRefundNo]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"code":"RESOURCE_NOT_EXISTS","message":"退款单不存在"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"refund_id": "R-" + outRefundNo, "out_refund_no": outRefundNo, "status": status})
+			return
+		}
+
+		server.calls++
+		if server.fail != nil && server.fail(w) {
+			return
+		}
+		var body struct {
+			OutRefundNo string `json:"out_refund_no"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := server.refunds[body.OutRefundNo]; !ok {
+			server.refunds[body.OutRefundNo] = RefundStatusProcessing
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"refund_id":     "R-" + body.OutRefundNo,
+			"out_refund_no": body.OutRefundNo,
+			"status":        server.refunds[body.OutRefundNo],
+		})
+	})
+	return NewRefundLedger(client, NewMemoryLedgerStore())
+}
+
+func TestRefundLedger_PreventsOverRefund(t *testing.T) {
+	server := &testRefundServer{}
+	ledger := newTestLedger(t, server)
+
+	first, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", Amount: 600, TotalAmount: 1000})
+	if err != nil {
+		t.Fatalf("首次退款失败: %v", err)
+	}
+	if first.Status != RefundStatusProcessing || !strings.HasPrefix(first.OutRefundNo, "RF") {
+		t.Errorf("退款应答不匹配: %+v", first)
+	}
+
+	if _, err := ledger.Refund(context.Background(), "wf-2", RefundRequest{OutTradeNo: "ORDER_1", Amount: 500, TotalAmount: 1000}); !errors.Is(err, ErrOverRefund) {
+		t.Errorf("期望 ErrOverRefund，实际 %v", err)
+	}
+	if server.calls != 1 {
+		t.Errorf("超额退款不应发出请求，实际请求 %d 次", server.calls)
+	}
+
+	if _, err := ledger.Refund(context.Background(), "wf-3", RefundRequest{OutTradeNo: "ORDER_1", Amount: 400, TotalAmount: 1000}); err != nil {
+		t.Fatalf("剩余金额内退款失败: %v", err)
+	}
+	record, _ := ledger.Record("ORDER_1")
+	if record.Refunded() != 1000 || record.Remaining() != 0 || len(record.Entries) != 2 {
+		t.Errorf("台账不匹配: %+v", record)
+	}
+
+	if _, err := ledger.Refund(context.Background(), "wf-4", RefundRequest{OutTradeNo: "ORDER_1", Amount: 1, TotalAmount: 2000}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("订单金额不一致时期望 ErrInvalidRequest，实际 %v", err)
+	}
+}
+
+func TestRefundLedger_IdempotentRetry(t *testing.T) {
+	server := &testRefundServer{}
+	ledger := newTestLedger(t, server)
+
+	// 首次请求超时，结果未知
+	server.fail = func(w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code":"SYSTEM_ERROR","message":"系统繁忙"}`))
+		return true
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_A", Amount: 600, TotalAmount: 1000}); !IsRetryable(err) {
+		t.Fatalf("期望可重试错误，实际 %v", err)
+	}
+	record, _ := ledger.Record("ORDER_1")
+	if record.Entries[0].Status != LedgerStatusPending || record.Remaining() != 400 {
+		t.Errorf("结果未知的退款应保持 PENDING 并占用金额: %+v", record.Entries[0])
+	}
+
+	// 工作流重试时生成了新的退款单号，台账沿用首次的 REF_A
+	server.fail = nil
+	resp, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_B", Amount: 600, TotalAmount: 1000})
+	if err != nil {
+		t.Fatalf("重试失败: %v", err)
+	}
+	if resp.OutRefundNo != "REF_A" {
+		t.Errorf("重试应复用 REF_A，实际 %s", resp.OutRefundNo)
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", Amount: 600, TotalAmount: 1000}); err != nil {
+		t.Fatalf("再次重试失败: %v", err)
+	}
+	if len(server.refunds) != 1 {
+		t.Errorf("重试不应产生新退款: %v", server.refunds)
+	}
+	record, _ = ledger.Record("ORDER_1")
+	if len(record.Entries) != 1 || record.Entries[0].Status != RefundStatusProcessing || record.Entries[0].RefundID != "R-REF_A" {
+		t.Errorf("台账不匹配: %+v", record.Entries[0])
+	}
+
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", Amount: 500, TotalAmount: 1000}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("同一请求金额不同时期望 ErrInvalidRequest，实际 %v", err)
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-2", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_A", Amount: 100, TotalAmount: 1000}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("退款单号被其他请求占用时期望 ErrInvalidRequest，实际 %v", err)
+	}
+}
+
+func TestRefundLedger_RejectedReleasesAmount(t *testing.T) {
+	server := &testRefundServer{}
+	ledger := newTestLedger(t, server)
+
+	server.fail = func(w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":"NOT_ENOUGH","message":"基本账户余额不足，请充值后重新发起"}`))
+		return true
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", Amount: 1000, TotalAmount: 1000}); !errors.Is(err, ErrNotEnough) {
+		t.Fatalf("期望 ErrNotEnough，实际 %v", err)
+	}
+	record, _ := ledger.Record("ORDER_1")
+	if record.Entries[0].Status != LedgerStatusFailed || record.Remaining() != 1000 || record.Entries[0].Error == "" {
+		t.Errorf("被拒绝的退款应释放金额: %+v", record.Entries[0])
+	}
+
+	server.fail = nil
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", Amount: 1000, TotalAmount: 1000}); err != nil {
+		t.Fatalf("充值后重试失败: %v", err)
+	}
+	record, _ = ledger.Record("ORDER_1")
+	if len(record.Entries) != 1 || record.Entries[0].Status != RefundStatusProcessing || record.Remaining() != 0 {
+		t.Errorf("台账不匹配: %+v", record.Entries[0])
+	}
+}
+
+func TestRefundLedger_UnknownResultKeepsAmount(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ledger *RefundLedger, server *testRefundServer)
+	}{
+		{"应答无法解析", func(ledger *RefundLedger, server *testRefundServer) {
+			server.fail = func(w http.ResponseWriter) bool {
+				w.Write([]byte("not json"))
+				return true
+			}
+		}},
+		{"应答验签失败", func(ledger *RefundLedger, server *testRefundServer) {
+			ledger.client.SetVerifier(&stubVerifier{err: errors.New("bad signature")})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &testRefundServer{}
+			ledger := newTestLedger(t, server)
+			tt.setup(ledger, server)
+
+			if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", Amount: 1000, TotalAmount: 1000}); err == nil {
+				t.Fatal("期望退款失败")
+			}
+			record, _ := ledger.Record("ORDER_1")
+			if record.Entries[0].Status != LedgerStatusPending || record.Remaining() != 0 || record.Entries[0].Error == "" {
+				t.Errorf("结果未知的退款应保持 PENDING 并占用金额: %+v", record.Entries[0])
+			}
+
+			if _, err := ledger.Refund(context.Background(), "wf-2", RefundRequest{OutTradeNo: "ORDER_1", Amount: 1000, TotalAmount: 1000}); !errors.Is(err, ErrOverRefund) {
+				t.Errorf("期望 ErrOverRefund，实际 %v", err)
+			}
+			if server.calls != 1 {
+				t.Errorf("不应再次申请退款，实际请求 %d 次", server.calls)
+			}
+		})
+	}
+}
+
+func TestRefundLedger_Reconcile(t *testing.T) {
+	server := &testRefundServer{}
+	ledger := newTestLedger(t, server)
+
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_OK", Amount: 300, TotalAmount: 1000}); err != nil {
+		t.Fatalf("退款失败: %v", err)
+	}
+	// 请求未到达微信支付
+	server.fail = func(w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-2", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_LOST", Amount: 700, TotalAmount: 1000}); err == nil {
+		t.Fatal("期望退款失败")
+	}
+	server.refunds["REF_OK"] = RefundStatusSuccess
+
+	updated, err := ledger.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("对账失败: %v", err)
+	}
+	if len(updated) != 1 || updated[0].OutRefundNo != "REF_OK" {
+		t.Fatalf("期望只更新 REF_OK，实际 %+v", updated)
+	}
+	record, _ := ledger.Record("ORDER_1")
+	if record.Entries[0].Status != RefundStatusSuccess || record.Entries[1].Status != LedgerStatusPending || record.Remaining() != 0 {
+		t.Errorf("宽限期内查不到的退款应保持 PENDING: %+v %+v", record.Entries[0], record.Entries[1])
+	}
+
+	// 超过宽限期仍查不到，说明请求未到达微信支付
+	ledger.now = func() time.Time { return time.Now().Add(defaultReconcileGrace) }
+	updated, err = ledger.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("对账失败: %v", err)
+	}
+	if len(updated) != 1 || updated[0].OutRefundNo != "REF_LOST" {
+		t.Fatalf("期望只更新 REF_LOST，实际 %+v", updated)
+	}
+	record, _ = ledger.Record("ORDER_1")
+	if record.Entries[1].Status != LedgerStatusFailed || record.Remaining() != 700 {
+		t.Errorf("对账结果不匹配: %+v", record.Entries[1])
+	}
+
+	if updated, err := ledger.Reconcile(context.Background()); err != nil || len(updated) != 0 {
+		t.Errorf("终态退款不应再次查询: %+v, %v", updated, err)
+	}
+}
+
+func TestRefundLedger_HandleNotification(t *testing.T) {
+	server := &testRefundServer{}
+	ledger := newTestLedger(t, server)
+
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", Amount: 1000, TotalAmount: 1000}); err != nil {
+		t.Fatalf("退款失败: %v", err)
+	}
+
+	event := &RefundEvent{EventType: "REFUND.CLOSED", Refund: RefundNotification{
+		OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", RefundID: "R-REF_1", RefundStatus: RefundStatusClosed,
+	}}
+	if err := ledger.HandleNotification(context.Background(), event); err != nil {
+		t.Fatalf("处理回调失败: %v", err)
+	}
+	record, _ := ledger.Record("ORDER_1")
+	if record.Entries[0].Status != RefundStatusClosed || record.Remaining() != 1000 {
+		t.Errorf("关闭的退款应释放金额: %+v", record.Entries[0])
+	}
+
+	other := &RefundEvent{Refund: RefundNotification{OutTradeNo: "ORDER_2", OutRefundNo: "REF_X", RefundStatus: RefundStatusSuccess}}
+	if err := ledger.HandleNotification(context.Background(), other); err != nil {
+		t.Errorf("未登记的退款应忽略: %v", err)
+	}
+}
+
+func TestRefundLedger_NotificationBeforeResponse(t *testing.T) {
+	server := &testRefundServer{}
+	ledger := newTestLedger(t, server)
+
+	// 退款成功回调先于申请退款应答到达
+	server.fail = func(w http.ResponseWriter) bool {
+		event := &RefundEvent{Refund: RefundNotification{
+			OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", RefundID: "R-REF_1", RefundStatus: RefundStatusSuccess,
+		}}
+		if err := ledger.HandleNotification(context.Background(), event); err != nil {
+			t.Errorf("处理回调失败: %v", err)
+		}
+		w.Write([]byte(`{"refund_id":"R-REF_1","out_refund_no":"REF_1","status":"PROCESSING"}`))
+		return true
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", Amount: 1000, TotalAmount: 1000}); err != nil {
+		t.Fatalf("退款失败: %v", err)
+	}
+	record, _ := ledger.Record("ORDER_1")
+	if record.Entries[0].Status != RefundStatusSuccess {
+		t.Errorf("终态不应被 PROCESSING 应答覆盖: %+v", record.Entries[0])
+	}
+
+	event := &RefundEvent{Refund: RefundNotification{OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", RefundStatus: RefundStatusClosed}}
+	if err := ledger.HandleNotification(context.Background(), event); err != nil {
+		t.Fatalf("处理回调失败: %v", err)
+	}
+	record, _ = ledger.Record("ORDER_1")
+	if record.Entries[0].Status != RefundStatusSuccess || record.Remaining() != 0 {
+		t.Errorf("成功的退款不应被改为 CLOSED: %+v", record.Entries[0])
+	}
+}
+
+func TestRefundLedger_FailedRefundAcceptedLater(t *testing.T) {
+	server := &testRefundServer{}
+	ledger := newTestLedger(t, server)
+
+	server.fail = func(w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":"NOT_ENOUGH","message":"基本账户余额不足，请充值后重新发起"}`))
+		return true
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-1", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", Amount: 600, TotalAmount: 1000}); !errors.Is(err, ErrNotEnough) {
+		t.Fatalf("期望 ErrNotEnough，实际 %v", err)
+	}
+
+	// 微信支付后来确认 REF_1 已受理，金额重新占用
+	accepted := &RefundEvent{Refund: RefundNotification{OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", RefundStatus: RefundStatusSuccess}}
+	if err := ledger.HandleNotification(context.Background(), accepted); err != nil {
+		t.Fatalf("处理回调失败: %v", err)
+	}
+	record, _ := ledger.Record("ORDER_1")
+	if record.Entries[0].Status != RefundStatusSuccess || record.Remaining() != 400 {
+		t.Errorf("受理的退款应重新占用金额: %+v", record.Entries[0])
+	}
+
+	// 释放期间已批准另一笔退款，重新占用后超过订单金额
+	server.fail = func(w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":"NOT_ENOUGH","message":"基本账户余额不足，请充值后重新发起"}`))
+		return true
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-2", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_2", Amount: 400, TotalAmount: 1000}); !errors.Is(err, ErrNotEnough) {
+		t.Fatalf("期望 ErrNotEnough，实际 %v", err)
+	}
+	server.fail = nil
+	if _, err := ledger.Refund(context.Background(), "wf-3", RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_3", Amount: 400, TotalAmount: 1000}); err != nil {
+		t.Fatalf("退款失败: %v", err)
+	}
+	late := &RefundEvent{Refund: RefundNotification{OutTradeNo: "ORDER_1", OutRefundNo: "REF_2", RefundStatus: RefundStatusProcessing}}
+	if err := ledger.HandleNotification(context.Background(), late); !errors.Is(err, ErrOverRefund) {
+		t.Errorf("期望 ErrOverRefund，实际 %v", err)
+	}
+	record, _ = ledger.Record("ORDER_1")
+	if record.Entries[1].Status != RefundStatusProcessing || record.Entries[1].Error == "" || record.Remaining() != -400 {
+		t.Errorf("超额退款应记录在台账中: %+v", record.Entries[1])
+	}
+	if _, err := ledger.Refund(context.Background(), "wf-4", RefundRequest{OutTradeNo: "ORDER_1", Amount: 1, TotalAmount: 1000}); !errors.Is(err, ErrOverRefund) {
+		t.Errorf("期望 ErrOverRefund，实际 %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	notifyAlgorithm = "AEAD_AES_256_GCM"
+	// notifyMaxSkew 回调时间戳与本地时间的最大偏差，超出视为重放
+	notifyMaxSkew = 5 * time.Minute
+	// maxNotifyBodySize 回调请求体上限
+	maxNotifyBodySize = 1 << 20
+)
+
+// RefundNotification 退款结果回调解密后的资源
+type RefundNotification struct {
+	Mchid               string       `json:"mchid"`
+	SpMchid             string       `json:"sp_mchid,omitempty"`
+	SubMchid            string       `json:"sub_mchid,omitempty"`
+	OutTradeNo          string       `json:"out_trade_no"`
+	TransactionID       string       `json:"transaction_id"`
+	OutRefundNo         string       `json:"out_refund_no"`
+	RefundID            string       `json:"refund_id"`
+	RefundStatus        string       `json:"refund_status"`
+	SuccessTime         string       `json:"success_time"`
+	UserReceivedAccount string       `json:"user_received_account"`
+	Amount              RefundAmount `json:"amount"`
+}
+
+// RefundEvent 一次退款结果回调，EventType 为 REFUND.SUCCESS、REFUND.CLOSED 或 REFUND.ABNORMAL
+type RefundEvent struct {
+	ID         string
+	CreateTime string
+	EventType  string
+	Summary    string
+	Refund     RefundNotification
+}
+
+// RefundHandlerFunc 处理退款回调，返回错误时微信支付会稍后重新通知
+type RefundHandlerFunc func(ctx context.Context, event *RefundEvent) error
+
+// RefundNotifyHandler 接收退款结果回调：校验平台签名与时间戳，用 APIv3 密钥解密资源后交给处理函数
+type RefundNotifyHandler struct {
+	verifier Verifier
+	apiV3Key []byte
+	handle   RefundHandlerFunc
+	now      func() time.Time
+}
+
+// NewRefundNotifyHandler 创建退款回调处理器，挂载在 RefundRequest.NotifyURL 对应的路径上
+func NewRefundNotifyHandler(verifier Verifier, apiV3Key string, fn RefundHandlerFunc) (*RefundNotifyHandler, error) {
+	if verifier == nil {
+		return nil, fmt.Errorf("verifier is required")
+	}
+	if len(apiV3Key) != 32 {
+		return nil, fmt.Errorf("apiv3 key must be 32 bytes")
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("handler func is required")
+	}
+	return &RefundNotifyHandler{
+		verifier: verifier,
+		apiV3Key: []byte(apiV3Key),
+		handle:   fn,
+		now:      time.Now,
+	}, nil
+}
+
+// Parse 校验并解密一次退款回调，错误均包装 ErrInvalidNotification
+func (h *RefundNotifyHandler) Parse(r *http.Request) (*RefundEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxNotifyBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get("Wechatpay-Timestamp"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp", ErrInvalidNotification)
+	}
+	if skew := h.now().Sub(time.Unix(ts, 0)); skew > notifyMaxSkew || skew < -notifyMaxSkew {
+		return nil, fmt.Errorf("%w: timestamp expired", ErrInvalidNotification)
+	}
+	if err := verifyResponse(r.Header, body, h.verifier); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+
+	var notify struct {
+		ID         string `json:"id"`
+		CreateTime string `json:"create_time"`
+		EventType  string `json:"event_type"`
+		Summary    string `json:"summary"`
+		Resource   struct {
+			Algorithm      string `json:"algorithm"`
+			Ciphertext     string `json:"ciphertext"`
+			AssociatedData string `json:"associated_data"`
+			Nonce          string `json:"nonce"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &notify); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	if notify.Resource.Algorithm != notifyAlgorithm {
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidNotification, notify.Resource.Algorithm)
+	}
+
+	plaintext, err := decryptResource(h.apiV3Key, notify.Resource.AssociatedData, notify.Resource.Nonce, notify.Resource.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	event := &RefundEvent{
+		ID:         notify.ID,
+		CreateTime: notify.CreateTime,
+		EventType:  notify.EventType,
+		Summary:    notify.Summary,
+	}
+	if err := json.Unmarshal(plaintext, &event.Refund); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	return event, nil
+}
+
+// ServeHTTP 验签或解密失败应答 400，处理函数出错应答 500，两者微信支付都会重新通知
+func (h *RefundNotifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event, err := h.Parse(r)
+	if err != nil {
+		writeNotifyResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.handle(r.Context(), event); err != nil {
+		writeNotifyResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeNotifyResponse(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": "FAIL", "message": message})
+}
+
+// decryptResource 使用 APIv3 密钥解密 AEAD_AES_256_GCM 资源
+func decryptResource(key []byte, associatedData, nonce, ciphertext string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length: %d", len(nonce))
+	}
+	return gcm.Open(nil, []byte(nonce), data, []byte(associatedData))
+}
@@ -0,0 +1,177 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAPIv3Key = "0123456789abcdef0123456789abcdef"
+
+// newTestRefundNotify 构造一条用 testAPIv3Key 加密资源的退款回调请求
+func newTestRefundNotify(t *testing.T, eventType string, ts time.Time, resource interface{}) *http.Request {
+	t.Helper()
+	plaintext, _ := json.Marshal(resource)
+	block, _ := aes.NewCipher([]byte(testAPIv3Key))
+	gcm, _ := cipher.NewGCM(block)
+	ciphertext := gcm.Seal(nil, []byte("fdasflkja484"), plaintext, []byte("refund"))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":            "EV-2018022511223320873",
+		"create_time":   "2018-06-08T10:34:56+08:00",
+		"resource_type": "encrypt-resource",
+		"event_type":    eventType,
+		"summary":       "退款成功",
+		"resource": map[string]string{
+			"original_type":   "refund",
+			"algorithm":       "AEAD_AES_256_GCM",
+			"ciphertext":      base64.StdEncoding.EncodeToString(ciphertext),
+			"associated_data": "refund",
+			"nonce":           "fdasflkja484",
+		},
+	})
+	req := httptest.NewRequest("POST", "/refund/notify", strings.NewReader(string(body)))
+	req.Header.Set("Wechatpay-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+	req.Header.Set("Wechatpay-Nonce", "5K8264ILTKCH16CQ2502SI8ZNMTM67VS")
+	req.Header.Set("Wechatpay-Serial", "5157F09EFDC096DE")
+	req.Header.Set("Wechatpay-Signature", "c2lnbmF0dXJl")
+	return req
+}
+
+var testRefundResource = map[string]interface{}{
+	"mchid":                 "1900000100",
+	"transaction_id":        "1008450740201411110005820873",
+	"out_trade_no":          "20150806125346",
+	"refund_id":             "50200207182018070300011301001",
+	"out_refund_no":         "7752501201407033233368018",
+	"refund_status":         "SUCCESS",
+	"success_time":          "2018-06-08T10:34:56+08:00",
+	"user_received_account": "招商银行信用卡0403",
+	"amount":                map[string]interface{}{"total": 999, "refund": 999, "payer_total": 999, "payer_refund": 999},
+}
+
+func TestRefundNotifyHandler_Success(t *testing.T) {
+	verifier := &stubVerifier{}
+	var got *RefundEvent
+	handler, err := NewRefundNotifyHandler(verifier, testAPIv3Key, func(ctx context.Context, event *RefundEvent) error {
+		got = event
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("创建回调处理器失败: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newTestRefundNotify(t, "REFUND.SUCCESS", time.Now(), testRefundResource))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("期望 200，实际 %d: %s", rec.Code, rec.Body.String())
+	}
+	if verifier.serial != "5157F09EFDC096DE" || !strings.HasSuffix(verifier.message, "}\n") {
+		t.Errorf("未对回调报文验签: %q %q", verifier.serial, verifier.message)
+	}
+	if got == nil || got.EventType != "REFUND.SUCCESS" {
+		t.Fatalf("回调事件不匹配: %+v", got)
+	}
+	refund := got.Refund
+	if refund.RefundStatus != RefundStatusSuccess || refund.OutRefundNo != "7752501201407033233368018" ||
+		refund.Amount.PayerRefund != 999 || refund.UserReceivedAccount != "招商银行信用卡0403" {
+		t.Errorf("退款资源不匹配: %+v", refund)
+	}
+}
+
+func TestRefundNotifyHandler_Rejects(t *testing.T) {
+	handled := false
+	verifier := &stubVerifier{}
+	handler, _ := NewRefundNotifyHandler(verifier, testAPIv3Key, func(ctx context.Context, event *RefundEvent) error {
+		handled = true
+		return nil
+	})
+
+	t.Run("签名错误", func(t *testing.T) {
+		verifier.err = errors.New("bad signature")
+		defer func() { verifier.err = nil }()
+		_, err := handler.Parse(newTestRefundNotify(t, "REFUND.SUCCESS", time.Now(), testRefundResource))
+		if !errors.Is(err, ErrInvalidNotification) {
+			t.Errorf("期望 ErrInvalidNotification，实际 %v", err)
+		}
+	})
+
+	t.Run("时间戳过期", func(t *testing.T) {
+		_, err := handler.Parse(newTestRefundNotify(t, "REFUND.SUCCESS", time.Now().Add(-10*time.Minute), testRefundResource))
+		if !errors.Is(err, ErrInvalidNotification) {
+			t.Errorf("期望 ErrInvalidNotification，实际 %v", err)
+		}
+	})
+
+	t.Run("密钥错误", func(t *testing.T) {
+		other, _ := NewRefundNotifyHandler(verifier, strings.Repeat("x", 32), func(ctx context.Context, event *RefundEvent) error { return nil })
+		rec := httptest.NewRecorder()
+		other.ServeHTTP(rec, newTestRefundNotify(t, "REFUND.SUCCESS", time.Now(), testRefundResource))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("期望 400，实际 %d", rec.Code)
+		}
+		body, _ := io.ReadAll(rec.Body)
+		if !strings.Contains(string(body), `"code":"FAIL"`) {
+			t.Errorf("应答不匹配: %s", body)
+		}
+	})
+
+	t.Run("随机串长度错误", func(t *testing.T) {
+		req := newTestRefundNotify(t, "REFUND.SUCCESS", time.Now(), testRefundResource)
+		body, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(strings.NewReader(strings.Replace(string(body), `"nonce":"fdasflkja484"`, `"nonce":"short"`, 1)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("期望 400，实际 %d", rec.Code)
+		}
+	})
+
+	if handled {
+		t.Error("无效回调不应交给处理函数")
+	}
+}
+
+func TestRefundNotifyHandler_HandlerError(t *testing.T) {
+	handler, _ := NewRefundNotifyHandler(&stubVerifier{}, testAPIv3Key, func(ctx context.Context, event *RefundEvent) error {
+		if event.Refund.RefundStatus != RefundStatusAbnormal {
+			t.Errorf("退款状态不匹配: %s", event.Refund.RefundStatus)
+		}
+		return errors.New("database unavailable")
+	})
+
+	resource := map[string]interface{}{}
+	for k, v := range testRefundResource {
+		resource[k] = v
+	}
+	resource["refund_status"] = "ABNORMAL"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newTestRefundNotify(t, "REFUND.ABNORMAL", time.Now(), resource))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("处理失败时期望 500 以便重试，实际 %d", rec.Code)
+	}
+}
+
+func TestNewRefundNotifyHandler(t *testing.T) {
+	fn := func(ctx context.Context, event *RefundEvent) error { return nil }
+	if _, err := NewRefundNotifyHandler(nil, testAPIv3Key, fn); err == nil {
+		t.Error("缺少验签器时期望返回错误")
+	}
+	if _, err := NewRefundNotifyHandler(&stubVerifier{}, "short", fn); err == nil {
+		t.Error("APIv3 密钥长度错误时期望返回错误")
+	}
+	if _, err := NewRefundNotifyHandler(&stubVerifier{}, testAPIv3Key, nil); err == nil {
+		t.Error("缺少处理函数时期望返回错误")
+	}
+}
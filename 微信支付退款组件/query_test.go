@@ -1,46 +1,56 @@
 package wechatpay
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"context"
+	"errors"
 	"net/http"
-	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
-	"time"
 )
 
 func TestQueryRefund_Success(t *testing.T) {
 	// 创建模拟服务器
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{
 			"refund_id": "REF123456789",
 			"out_refund_no": "ORDER_123",
+			"transaction_id": "1217752501201407033233368018",
+			"out_trade_no": "1217752501201407033233368018",
+			"channel": "ORIGINAL",
 			"status": "success",
-			"amount": 1000,
+			"funds_account": "UNSETTLED",
+			"amount": {
+				"total": 2000,
+				"refund": 1000,
+				"from": [{"account": "AVAILABLE", "amount": 1000}],
+				"payer_total": 1800,
+				"payer_refund": 900,
+				"settlement_refund": 1000,
+				"settlement_total": 2000,
+				"discount_refund": 100,
+				"currency": "CNY"
+			},
+			"promotion_detail": [{
+				"promotion_id": "109519",
+				"scope": "SINGLE",
+				"type": "DISCOUNT",
+				"amount": 200,
+				"refund_amount": 100,
+				"goods_detail": [{"merchant_goods_id": "1217752501201407033233368018", "unit_price": 100, "refund_amount": 100, "refund_quantity": 1}]
+			}],
 			"success_time": "2023-04-01T12:34:56+08:00",
 			"user_received_account": "招商银行信用卡0403"
 		}`))
-	}))
-	defer ts.Close()
-
-	// 临时替换全局URL
-	originalURL := baseURL
-	baseURL = ts.URL + "/v3/refund/domestic/refunds"
-	defer func() { baseURL = originalURL }()
-
-	// 生成测试私钥
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		t.Fatalf("生成私钥失败: %v", err)
-	}
+	})
 
 	// 构建请求
 	req := QueryRequest{OutRefundNo: "ORDER_123"}
 
 	// 调用测试函数
-	resp, err := QueryRefund("MCH123", "SERIAL001", privateKey, req)
+	resp, err := client.QueryRefund(context.Background(), req)
 	if err != nil {
 		t.Fatalf("查询失败: %v", err)
 	}
@@ -48,77 +58,65 @@ func TestQueryRefund_Success(t *testing.T) {
 	// 验证结果
 	expectedTime := "2023-04-01T12:34:56+08:00"
 	if resp.RefundID != "REF123456789" || resp.OutRefundNo != "ORDER_123" ||
-		resp.Status != "SUCCESS" || resp.Amount != 1000 || resp.SuccessTime != expectedTime ||
-		resp.UserReceived != "招商银行信用卡0403" {
+		resp.Status != "SUCCESS" || resp.Amount.Refund != 1000 || resp.SuccessTime != expectedTime ||
+		resp.UserReceivedAccount != "招商银行信用卡0403" {
 		t.Errorf("响应不匹配\n期望: REF123456789, ORDER_123, SUCCESS, 1000, %s, 招商银行信用卡0403\n实际: %s, %s, %s, %d, %s, %s",
-			expectedTime, resp.RefundID, resp.OutRefundNo, resp.Status, resp.Amount, resp.SuccessTime, resp.UserReceived)
+			expectedTime, resp.RefundID, resp.OutRefundNo, resp.Status, resp.Amount.Refund, resp.SuccessTime, resp.UserReceivedAccount)
+	}
+	amount := resp.Amount
+	if amount.Total != 2000 || amount.PayerTotal != 1800 || amount.PayerRefund != 900 || amount.SettlementRefund != 1000 ||
+		amount.DiscountRefund != 100 || amount.Currency != "CNY" || len(amount.From) != 1 || amount.From[0].Account != "AVAILABLE" {
+		t.Errorf("金额明细不匹配: %+v", amount)
+	}
+	if resp.TransactionID != "1217752501201407033233368018" || resp.FundsAccount != "UNSETTLED" || resp.Channel != "ORIGINAL" {
+		t.Errorf("退款单字段不匹配: %+v", resp)
+	}
+	if len(resp.PromotionDetail) != 1 || resp.PromotionDetail[0].RefundAmount != 100 || resp.PromotionDetail[0].GoodsDetail[0].RefundQuantity != 1 {
+		t.Errorf("优惠退款明细不匹配: %+v", resp.PromotionDetail)
 	}
 }
 
 func TestQueryRefund_HTTPError(t *testing.T) {
 	// 创建返回500错误的模拟服务器
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
-	defer ts.Close()
-
-	// 临时替换全局URL
-	originalURL := baseURL
-	baseURL = ts.URL + "/v3/refund/domestic/refunds"
-	defer func() { baseURL = originalURL }()
-
-	// 生成测试私钥
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		t.Fatalf("生成私钥失败: %v", err)
-	}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"RESOURCE_NOT_EXISTS","message":"退款单不存在"}`))
+	})
 
 	// 调用测试函数
-	_, err = QueryRefund("MCH123", "SERIAL001", privateKey, QueryRequest{OutRefundNo: "ORDER_123"})
-	if err == nil || err.Error() != "HTTP error: 500 Internal Server Error" {
-		t.Errorf("期望HTTP错误，实际错误: %v", err)
+	_, err := client.QueryRefund(context.Background(), QueryRequest{OutRefundNo: "ORDER_123"})
+	if !errors.Is(err, ErrResourceNotExist) {
+		t.Errorf("期望退款单不存在错误，实际错误: %v", err)
 	}
 }
 
 func TestQueryRefund_InvalidJSON(t *testing.T) {
 	// 创建返回无效JSON的模拟服务器
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`invalid json`))
-	}))
-	defer ts.Close()
-
-	// 临时替换全局URL
-	originalURL := baseURL
-	baseURL = ts.URL + "/v3/refund/domestic/refunds"
-	defer func() { baseURL = originalURL }()
-
-	// 生成测试私钥
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		t.Fatalf("生成私钥失败: %v", err)
-	}
+	})
 
 	// 调用测试函数
-	_, err = QueryRefund("MCH123", "SERIAL001", privateKey, QueryRequest{OutRefundNo: "ORDER_123"})
+	_, err := client.QueryRefund(context.Background(), QueryRequest{OutRefundNo: "ORDER_123"})
 	if err == nil || !strings.Contains(err.Error(), "invalid character") {
 		t.Errorf("期望JSON解析错误，实际错误: %v", err)
 	}
 }
 
-func TestBuildQueryURL(t *testing.T) {
+func TestBuildQueryPath(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
 	}{
-		{"REF123", baseURL + "/REF123"},
-		{"ref/und", baseURL + "/ref%2Fund"},
-		{"订单@123", baseURL + "/%E8%AE%A2%E5%8D%95%40123"},
+		{"REF123", queryPath + "REF123"},
+		{"ref/und", queryPath + "ref%2Fund"},
+		{"订单@123", queryPath + "%E8%AE%A2%E5%8D%95@123"},
 	}
 
 	for _, test := range tests {
-		result := buildQueryURL(test.input)
+		result := buildQueryPath(test.input)
 		if result != test.expected {
 			t.Errorf("输入: %s\n期望: %s\n实际: %s", test.input, test.expected, result)
 		}
@@ -134,14 +132,14 @@ func TestParseQueryResponse(t *testing.T) {
 	}{
 		{
 			name:  "ValidResponse",
-			input: `{"refund_id":"R123","out_refund_no":"O123","status":"success","amount":100,"success_time":"2023-01-01T00:00:00Z","user_received_account":"account123"}`,
+			input: `{"refund_id":"R123","out_refund_no":"O123","status":"success","amount":{"total":200,"refund":100,"payer_total":200,"payer_refund":100,"currency":"CNY"},"success_time":"2023-01-01T00:00:00Z","user_received_account":"account123"}`,
 			expected: &QueryResponse{
-				RefundID:     "R123",
-				OutRefundNo:  "O123",
-				Status:       "SUCCESS",
-				Amount:       100,
-				SuccessTime:  "2023-01-01T00:00:00Z",
-				UserReceived: "account123",
+				RefundID:            "R123",
+				OutRefundNo:         "O123",
+				Status:              "SUCCESS",
+				Amount:              RefundAmount{Total: 200, Refund: 100, PayerTotal: 200, PayerRefund: 100, Currency: "CNY"},
+				SuccessTime:         "2023-01-01T00:00:00Z",
+				UserReceivedAccount: "account123",
 			},
 			hasError: false,
 		},
@@ -162,26 +160,40 @@ func TestParseQueryResponse(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			resp, err := parseQueryResponse([]byte(tc.input))
-			
+
 			if tc.hasError {
 				if err == nil {
 					t.Error("期望错误，但未返回错误")
 				}
 				return
 			}
-			
+
 			if err != nil {
 				t.Fatalf("意外错误: %v", err)
 			}
-			
+
 			if resp.RefundID != tc.expected.RefundID ||
 				resp.OutRefundNo != tc.expected.OutRefundNo ||
 				resp.Status != tc.expected.Status ||
-				resp.Amount != tc.expected.Amount ||
+				!reflect.DeepEqual(resp.Amount, tc.expected.Amount) ||
 				resp.SuccessTime != tc.expected.SuccessTime ||
-				resp.UserReceived != tc.expected.UserReceived {
+				resp.UserReceivedAccount != tc.expected.UserReceivedAccount {
 				t.Errorf("结果不匹配\n期望: %+v\n实际: %+v", tc.expected, resp)
 			}
 		})
 	}
 }
+
+func TestQueryRefund_SubMerchant(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != queryPath+"REF_SUB?sub_mchid=1900000109" {
+			t.Errorf("请求路径不匹配: %s", r.URL.RequestURI())
+		}
+		w.Write([]byte(`{"refund_id":"REF1","out_refund_no":"REF_SUB","status":"SUCCESS"}`))
+	})
+
+	resp, err := client.QueryRefund(context.Background(), QueryRequest{SubMchID: "1900000109", OutRefundNo: "REF_SUB"})
+	if err != nil || resp.Status != "SUCCESS" {
+		t.Fatalf("查询失败: %+v, %v", resp, err)
+	}
+}
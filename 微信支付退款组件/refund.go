@@ -1,48 +1,101 @@
-package wxpay
+package wechatpay
 
 import (
-	"bytes"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"math/rand"
-	"net/http"
-	"strconv"
-	"time"
+	"strings"
 )
 
-const refundURL = "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds"
-
+// RefundRequest 申请退款请求，金额单位为分
 type RefundRequest struct {
-	OutTradeNo  string
-	OutRefundNo string
-	Amount      int64
-	TotalAmount int64
-	Reason      string
+	// SubMchID 服务商模式下的子商户号，请求仍使用服务商证书签名
+	SubMchID string
+	// TransactionID 与 OutTradeNo 二选一，同时填写时以 TransactionID 为准
+	TransactionID string
+	OutTradeNo    string
+	OutRefundNo   string
+	Amount        int64
+	TotalAmount   int64
+	Reason        string
+	// NotifyURL 退款结果回调地址，由 RefundNotifyHandler 接收；为空时使用商户平台配置的地址
+	NotifyURL string
+	// FundsAccount 退款资金来源，仅支持 AVAILABLE（可用余额）；为空时使用未结算资金
+	FundsAccount string
+	// From 指定出资账户及金额，合计须等于 Amount
+	From []RefundFrom
+	// GoodsDetail 单品优惠订单需要按商品退款时填写
+	GoodsDetail []RefundGoodsDetail
+}
+
+// RefundFrom 退款出资账户，Account 为 AVAILABLE 或 UNAVAILABLE
+type RefundFrom struct {
+	Account string `json:"account"`
+	Amount  int64  `json:"amount"`
 }
 
+// RefundGoodsDetail 退款商品
+type RefundGoodsDetail struct {
+	MerchantGoodsID  string `json:"merchant_goods_id"`
+	WechatpayGoodsID string `json:"wechatpay_goods_id,omitempty"`
+	GoodsName        string `json:"goods_name,omitempty"`
+	UnitPrice        int64  `json:"unit_price"`
+	RefundAmount     int64  `json:"refund_amount"`
+	RefundQuantity   int64  `json:"refund_quantity"`
+}
+
+// RefundResponse 退款单，申请退款与查询退款的应答共用
 type RefundResponse struct {
-	RefundID    string
-	OutRefundNo string
-	Status      string
-	CreateTime  string
+	RefundID            string `json:"refund_id"`
+	OutRefundNo         string `json:"out_refund_no"`
+	TransactionID       string `json:"transaction_id"`
+	OutTradeNo          string `json:"out_trade_no"`
+	Channel             string `json:"channel"`
+	UserReceivedAccount string `json:"user_received_account"`
+	SuccessTime         string `json:"success_time"`
+	CreateTime          string `json:"create_time"`
+	// Status 为 PROCESSING、SUCCESS、CLOSED 或 ABNORMAL
+	Status          string            `json:"status"`
+	FundsAccount    string            `json:"funds_account"`
+	Amount          RefundAmount      `json:"amount"`
+	PromotionDetail []RefundPromotion `json:"promotion_detail,omitempty"`
 }
 
-func Refund(mchID, serialNo string, privateKey *rsa.PrivateKey, req RefundRequest) (*RefundResponse, error) {
-	body, err := buildRefundBody(req)
-	if err != nil {
-		return nil, err
-	}
+// RefundAmount 退款金额明细，单位为分，退款应答与退款回调共用
+type RefundAmount struct {
+	// Total 原订单金额
+	Total  int64        `json:"total"`
+	Refund int64        `json:"refund"`
+	From   []RefundFrom `json:"from,omitempty"`
+	// PayerTotal、PayerRefund 用户实际支付与实际退回的金额，不含代金券
+	PayerTotal       int64 `json:"payer_total"`
+	PayerRefund      int64 `json:"payer_refund"`
+	SettlementRefund int64 `json:"settlement_refund"`
+	SettlementTotal  int64 `json:"settlement_total"`
+	// DiscountRefund 退回的优惠金额
+	DiscountRefund int64  `json:"discount_refund"`
+	Currency       string `json:"currency"`
+	RefundFee      int64  `json:"refund_fee,omitempty"`
+}
+
+// RefundPromotion 退款涉及的优惠
+type RefundPromotion struct {
+	PromotionID  string              `json:"promotion_id"`
+	Scope        string              `json:"scope"`
+	Type         string              `json:"type"`
+	Amount       int64               `json:"amount"`
+	RefundAmount int64               `json:"refund_amount"`
+	GoodsDetail  []RefundGoodsDetail `json:"goods_detail,omitempty"`
+}
 
-	auth, err := signRequest("POST", refundURL, string(body), mchID, serialNo, privateKey)
+// Refund 申请退款
+func (c *Client) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	body, err := buildRefundBody(req)
 	if err != nil {
 		return nil, err
 	}
 
-	respBody, err := doRequest("POST", refundURL, body, auth)
+	respBody, err := c.doRequest(ctx, "POST", refundPath, body)
 	if err != nil {
 		return nil, err
 	}
@@ -51,84 +104,87 @@ func Refund(mchID, serialNo string, privateKey *rsa.PrivateKey, req RefundReques
 }
 
 func buildRefundBody(req RefundRequest) ([]byte, error) {
+	if err := validateRefundRequest(req); err != nil {
+		return nil, err
+	}
+
+	amount := map[string]interface{}{
+		"refund":   req.Amount,
+		"total":    req.TotalAmount,
+		"currency": "CNY",
+	}
+	if len(req.From) > 0 {
+		amount["from"] = req.From
+	}
 	data := map[string]interface{}{
-		"out_trade_no":  req.OutTradeNo,
 		"out_refund_no": req.OutRefundNo,
-		"amount": map[string]interface{}{
-			"refund":   req.Amount,
-			"total":    req.TotalAmount,
-			"currency": "CNY",
-		},
+		"amount":        amount,
+	}
+	if req.TransactionID != "" {
+		data["transaction_id"] = req.TransactionID
+	} else {
+		data["out_trade_no"] = req.OutTradeNo
+	}
+	if req.SubMchID != "" {
+		data["sub_mchid"] = req.SubMchID
 	}
 	if req.Reason != "" {
 		data["reason"] = req.Reason
 	}
+	if req.NotifyURL != "" {
+		data["notify_url"] = req.NotifyURL
+	}
+	if req.FundsAccount != "" {
+		data["funds_account"] = req.FundsAccount
+	}
+	if len(req.GoodsDetail) > 0 {
+		data["goods_detail"] = req.GoodsDetail
+	}
 	return json.Marshal(data)
 }
 
-func signRequest(method, url, body, mchID, serialNo string, privateKey *rsa.PrivateKey) (string, error) {
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	nonce := generateNonce(32)
-
-	signStr := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n", method, url, timestamp, nonce, body)
-	hashed := sha256.Sum256([]byte(signStr))
-	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, hashed[:])
-	if err != nil {
-		return "", err
+// validateRefundRequest 校验请求，错误均包装 ErrInvalidRequest
+func validateRefundRequest(req RefundRequest) error {
+	if req.TransactionID == "" && req.OutTradeNo == "" {
+		return fmt.Errorf("%w: transaction_id or out_trade_no is required", ErrInvalidRequest)
 	}
-
-	return fmt.Sprintf("WECHATPAY2-SHA256-RSA2048 mchid=\"%s\",nonce_str=\"%s\",timestamp=\"%s\",serial_no=\"%s\",signature=\"%s\"",
-		mchID, nonce, timestamp, serialNo, base64.StdEncoding.EncodeToString(signature)), nil
-}
-
-func doRequest(method, url string, body []byte, authHeader string) ([]byte, error) {
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+	if req.OutRefundNo == "" {
+		return fmt.Errorf("%w: out_refund_no is required", ErrInvalidRequest)
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", authHeader)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if req.Amount <= 0 || req.TotalAmount <= 0 {
+		return fmt.Errorf("%w: amount and total amount must be positive", ErrInvalidRequest)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %s", resp.Status)
+	if req.Amount > req.TotalAmount {
+		return fmt.Errorf("%w: refund %d exceeds total amount %d", ErrInvalidRequest, req.Amount, req.TotalAmount)
 	}
-
-	return io.ReadAll(resp.Body)
+	if req.FundsAccount != "" && req.FundsAccount != "AVAILABLE" {
+		return fmt.Errorf("%w: unsupported funds_account %s", ErrInvalidRequest, req.FundsAccount)
+	}
+	if len(req.From) > 0 {
+		var sum int64
+		for _, from := range req.From {
+			if from.Account == "" || from.Amount <= 0 {
+				return fmt.Errorf("%w: amount.from needs an account and a positive amount", ErrInvalidRequest)
+			}
+			sum += from.Amount
+		}
+		if sum != req.Amount {
+			return fmt.Errorf("%w: amount.from totals %d, refund is %d", ErrInvalidRequest, sum, req.Amount)
+		}
+	}
+	for i, goods := range req.GoodsDetail {
+		if goods.MerchantGoodsID == "" || goods.RefundQuantity <= 0 || goods.RefundAmount <= 0 {
+			return fmt.Errorf("%w: goods_detail[%d] needs merchant_goods_id, refund_quantity and refund_amount", ErrInvalidRequest, i)
+		}
+	}
+	return nil
 }
 
 func parseRefundResponse(resp []byte) (*RefundResponse, error) {
-	var result struct {
-		RefundID    string `json:"refund_id"`
-		OutRefundNo string `json:"out_refund_no"`
-		Status      string `json:"status"`
-		CreateTime  string `json:"create_time"`
-	}
-
+	var result RefundResponse
 	if err := json.Unmarshal(resp, &result); err != nil {
 		return nil, err
 	}
-
-	return &RefundResponse{
-		RefundID:    result.RefundID,
-		OutRefundNo: result.OutRefundNo,
-		Status:      result.Status,
-		CreateTime:  result.CreateTime,
-	}, nil
-}
-
-func generateNonce(length int) string {
-	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = chars[rand.Intn(len(chars))]
-	}
-	return string(b)
+	result.Status = strings.ToUpper(result.Status)
+	return &result, nil
 }
@@ -1,34 +1,30 @@
-package wxpay_test
+package wechatpay
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
-	"wxpay"
-
-	"github.com/jarcoal/httpmock"
 )
 
 func TestRefund_Success(t *testing.T) {
-	httpmock.Activate()
-	defer httpmock.DeactivateAndReset()
-
-	// 模拟私钥
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	
-	// 模拟微信支付API响应
-	mockResponse := `{
-		"refund_id": "REF123456789",
-		"out_refund_no": "REFUND_2023",
-		"status": "PROCESSING",
-		"create_time": "2023-01-01T10:00:00Z"
-	}`
-	httpmock.RegisterResponder("POST", "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds",
-		httpmock.NewStringResponder(200, mockResponse))
-
-	req := wxpay.RefundRequest{
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != refundPath {
+			t.Errorf("请求不匹配: %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{
+			"refund_id": "REF123456789",
+			"out_refund_no": "REFUND_2023",
+			"status": "PROCESSING",
+			"create_time": "2023-01-01T10:00:00Z"
+		}`))
+	})
+
+	req := RefundRequest{
 		OutTradeNo:  "ORDER_123",
 		OutRefundNo: "REFUND_2023",
 		Amount:      1000,
@@ -36,7 +32,7 @@ func TestRefund_Success(t *testing.T) {
 		Reason:      "Test refund",
 	}
 
-	resp, err := wxpay.Refund("MCH123", "SERIAL001", privateKey, req)
+	resp, err := client.Refund(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Refund failed: %v", err)
 	}
@@ -50,59 +46,50 @@ func TestRefund_Success(t *testing.T) {
 }
 
 func TestRefund_HTTPError(t *testing.T) {
-	httpmock.Activate()
-	defer httpmock.DeactivateAndReset()
-
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	
-	// 模拟服务器错误
-	httpmock.RegisterResponder("POST", "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds",
-		httpmock.NewStringResponder(500, "Internal Server Error"))
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":"NOT_ENOUGH","message":"基本账户余额不足，请充值后重新发起"}`))
+	})
 
-	req := wxpay.RefundRequest{
+	req := RefundRequest{
 		OutTradeNo:  "ORDER_123",
 		OutRefundNo: "REFUND_2023",
 		Amount:      1000,
 		TotalAmount: 2000,
 	}
 
-	_, err := wxpay.Refund("MCH123", "SERIAL001", privateKey, req)
+	_, err := client.Refund(context.Background(), req)
 	if err == nil {
 		t.Fatal("Expected HTTP error, got nil")
 	}
-	
-	expectedErr := "HTTP error: 500 Internal Server Error"
-	if err.Error() != expectedErr {
-		t.Errorf("Expected error '%s', got '%s'", expectedErr, err.Error())
+	if !errors.Is(err, ErrNotEnough) {
+		t.Errorf("Expected ErrNotEnough, got '%v'", err)
+	}
+	if IsRetryable(err) {
+		t.Error("NOT_ENOUGH should not be retryable")
 	}
 }
 
 func TestRefund_InvalidResponse(t *testing.T) {
-	httpmock.Activate()
-	defer httpmock.DeactivateAndReset()
-
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	
-	// 模拟无效JSON响应
-	httpmock.RegisterResponder("POST", "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds",
-		httpmock.NewStringResponder(200, "{invalid json}"))
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{invalid json}"))
+	})
 
-	req := wxpay.RefundRequest{
+	req := RefundRequest{
 		OutTradeNo:  "ORDER_123",
 		OutRefundNo: "REFUND_2023",
 		Amount:      1000,
 		TotalAmount: 2000,
 	}
 
-	_, err := wxpay.Refund("MCH123", "SERIAL001", privateKey, req)
-	if err == nil {
+	if _, err := client.Refund(context.Background(), req); err == nil {
 		t.Fatal("Expected JSON parse error, got nil")
 	}
 }
 
 func TestRefund_RequestBuild(t *testing.T) {
 	// 测试请求体构建逻辑
-	req := wxpay.RefundRequest{
+	req := RefundRequest{
 		OutTradeNo:  "ORDER_1001",
 		OutRefundNo: "REF_1001",
 		Amount:      500,
@@ -110,9 +97,9 @@ func TestRefund_RequestBuild(t *testing.T) {
 		Reason:      "Customer request",
 	}
 
-	body, err := wxpay.BuildRefundBody(req)
+	body, err := buildRefundBody(req)
 	if err != nil {
-		t.Fatalf("BuildRefundBody failed: %v", err)
+		t.Fatalf("buildRefundBody failed: %v", err)
 	}
 
 	expected := `{"amount":{"currency":"CNY","refund":500,"total":1500},` +
@@ -123,61 +110,186 @@ func TestRefund_RequestBuild(t *testing.T) {
 }
 
 func TestRefund_Signature(t *testing.T) {
-	httpmock.Activate()
-	defer httpmock.DeactivateAndReset()
-
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	
 	// 捕获请求头进行验证
-	var authHeader string
-	httpmock.RegisterResponder("POST", "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds",
-		func(req *http.Request) (*http.Response, error) {
-			authHeader = req.Header.Get("Authorization")
-			return httpmock.NewStringResponse(200, `{"refund_id":"TEST123"}`), nil
-		})
+	var authHeader, body string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.Write([]byte(`{"refund_id":"TEST123"}`))
+	})
 
-	req := wxpay.RefundRequest{
+	req := RefundRequest{
 		OutTradeNo:  "SIGN_TEST",
 		OutRefundNo: "REF_SIGN",
 		Amount:      100,
 		TotalAmount: 100,
 	}
 
-	_, err := wxpay.Refund("MCH_SIGN", "SERIAL_ABC", privateKey, req)
-	if err != nil {
+	if _, err := client.Refund(context.Background(), req); err != nil {
 		t.Fatalf("Refund failed: %v", err)
 	}
 
-	if authHeader == "" {
-		t.Fatal("Authorization header missing")
-	}
-	if len(authHeader) < 100 || authHeader[:12] != "WECHATPAY2-S" {
+	if !strings.HasPrefix(authHeader, `WECHATPAY2-SHA256-RSA2048 mchid="mch123"`) {
 		t.Errorf("Invalid Authorization header format: %s", authHeader)
 	}
+	if !strings.Contains(body, `"out_refund_no":"REF_SIGN"`) {
+		t.Errorf("Unexpected body: %s", body)
+	}
 }
 
 func TestRefund_Timeout(t *testing.T) {
-	httpmock.Activate()
-	defer httpmock.DeactivateAndReset()
-
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	
-	// 模拟超时响应
-	httpmock.RegisterResponder("POST", "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds",
-		func(req *http.Request) (*http.Response, error) {
-			time.Sleep(35 * time.Second) // 超过30秒超时设置
-			return httpmock.NewStringResponse(200, ""), nil
-		})
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	})
+	client.httpClient.Timeout = 50 * time.Millisecond
 
-	req := wxpay.RefundRequest{
+	req := RefundRequest{
 		OutTradeNo:  "ORDER_123",
 		OutRefundNo: "REFUND_2023",
 		Amount:      1000,
 		TotalAmount: 2000,
 	}
 
-	_, err := wxpay.Refund("MCH123", "SERIAL001", privateKey, req)
+	_, err := client.Refund(context.Background(), req)
 	if err == nil {
 		t.Fatal("Expected timeout error, got nil")
 	}
+	if !IsRetryable(err) {
+		t.Errorf("Timeout should be retryable: %v", err)
+	}
+}
+
+func TestRefund_ContextCanceled(t *testing.T) {
+	called := false
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.Refund(ctx, RefundRequest{OutTradeNo: "ORDER_123", OutRefundNo: "REFUND_2023", Amount: 1000, TotalAmount: 2000})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if IsRetryable(err) {
+		t.Error("Canceled context should not be retryable")
+	}
+	if called {
+		t.Error("Request should not be sent")
+	}
+}
+
+func TestRefund_SubMerchant(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"sub_mchid":"1900000109"`) {
+			t.Errorf("缺少子商户号: %s", body)
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), `mchid="mch123"`) {
+			t.Errorf("应使用服务商商户号签名: %s", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"refund_id":"REF1","out_refund_no":"REF_SUB","status":"PROCESSING"}`))
+	})
+
+	resp, err := client.Refund(context.Background(), RefundRequest{
+		SubMchID:    "1900000109",
+		OutTradeNo:  "ORDER_SUB",
+		OutRefundNo: "REF_SUB",
+		Amount:      100,
+		TotalAmount: 100,
+	})
+	if err != nil || resp.RefundID != "REF1" {
+		t.Fatalf("Refund failed: %+v, %v", resp, err)
+	}
+}
+
+func TestRefund_NotifyURL(t *testing.T) {
+	body, err := buildRefundBody(RefundRequest{
+		OutTradeNo:  "ORDER_1001",
+		OutRefundNo: "REF_1001",
+		Amount:      500,
+		TotalAmount: 1500,
+		NotifyURL:   "https://weixin.qq.com/refund/notify",
+	})
+	if err != nil {
+		t.Fatalf("buildRefundBody failed: %v", err)
+	}
+	if !strings.Contains(string(body), `"notify_url":"https://weixin.qq.com/refund/notify"`) {
+		t.Errorf("缺少回调地址: %s", body)
+	}
+}
+
+func TestRefund_FullRequest(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			TransactionID string `json:"transaction_id"`
+			OutTradeNo    string `json:"out_trade_no"`
+			FundsAccount  string `json:"funds_account"`
+			Amount        struct {
+				Refund int64        `json:"refund"`
+				From   []RefundFrom `json:"from"`
+			} `json:"amount"`
+			GoodsDetail []RefundGoodsDetail `json:"goods_detail"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.TransactionID != "1217752501201407033233368018" || body.OutTradeNo != "" || body.FundsAccount != "AVAILABLE" {
+			t.Errorf("请求体不匹配: %+v", body)
+		}
+		if len(body.Amount.From) != 2 || body.Amount.From[1].Account != "UNAVAILABLE" || body.Amount.From[1].Amount != 200 {
+			t.Errorf("出资账户不匹配: %+v", body.Amount.From)
+		}
+		if len(body.GoodsDetail) != 1 || body.GoodsDetail[0].RefundQuantity != 1 || body.GoodsDetail[0].GoodsName != "iPhone6s 16G" {
+			t.Errorf("退款商品不匹配: %+v", body.GoodsDetail)
+		}
+		w.Write([]byte(`{"refund_id":"50000000382019052709732678859","out_refund_no":"1217752501201407033233368018","transaction_id":"1217752501201407033233368018","status":"PROCESSING","funds_account":"AVAILABLE","amount":{"total":1000,"refund":500,"from":[{"account":"AVAILABLE","amount":300},{"account":"UNAVAILABLE","amount":200}],"payer_total":900,"payer_refund":450,"settlement_refund":500,"settlement_total":1000,"discount_refund":50,"currency":"CNY"}}`))
+	})
+
+	resp, err := client.Refund(context.Background(), RefundRequest{
+		TransactionID: "1217752501201407033233368018",
+		OutRefundNo:   "1217752501201407033233368018",
+		Amount:        500,
+		TotalAmount:   1000,
+		FundsAccount:  "AVAILABLE",
+		From:          []RefundFrom{{Account: "AVAILABLE", Amount: 300}, {Account: "UNAVAILABLE", Amount: 200}},
+		GoodsDetail: []RefundGoodsDetail{{
+			MerchantGoodsID: "1217752501201407033233368018", GoodsName: "iPhone6s 16G",
+			UnitPrice: 500, RefundAmount: 500, RefundQuantity: 1,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if resp.Amount.PayerRefund != 450 || resp.Amount.DiscountRefund != 50 || len(resp.Amount.From) != 2 || resp.FundsAccount != "AVAILABLE" {
+		t.Errorf("应答不匹配: %+v", resp)
+	}
+}
+
+func TestRefund_Validation(t *testing.T) {
+	valid := RefundRequest{OutTradeNo: "ORDER_1", OutRefundNo: "REF_1", Amount: 500, TotalAmount: 1000}
+	tests := []struct {
+		name   string
+		modify func(*RefundRequest)
+	}{
+		{"缺少订单号", func(r *RefundRequest) { r.OutTradeNo = "" }},
+		{"缺少退款单号", func(r *RefundRequest) { r.OutRefundNo = "" }},
+		{"退款金额为零", func(r *RefundRequest) { r.Amount = 0 }},
+		{"订单金额为零", func(r *RefundRequest) { r.TotalAmount = 0 }},
+		{"退款金额超过订单金额", func(r *RefundRequest) { r.Amount = 1500 }},
+		{"不支持的资金账户", func(r *RefundRequest) { r.FundsAccount = "UNSETTLED" }},
+		{"出资合计不等于退款金额", func(r *RefundRequest) { r.From = []RefundFrom{{Account: "AVAILABLE", Amount: 400}} }},
+		{"退款商品缺少数量", func(r *RefundRequest) {
+			r.GoodsDetail = []RefundGoodsDetail{{MerchantGoodsID: "G1", RefundAmount: 500}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+			if _, err := buildRefundBody(req); !errors.Is(err, ErrInvalidRequest) {
+				t.Errorf("期望 ErrInvalidRequest，实际 %v", err)
+			}
+		})
+	}
 }
@@ -0,0 +1,26 @@
+package wechatpay
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Verifier 校验微信支付平台签名，微信支付组件的 CertificateManager 即实现了该接口
+type Verifier interface {
+	Verify(serial string, message []byte, signature string) error
+}
+
+// verifyResponse 使用平台证书校验应答签名
+func verifyResponse(header http.Header, body []byte, verifier Verifier) error {
+	signature := header.Get("Wechatpay-Signature")
+	timestamp := header.Get("Wechatpay-Timestamp")
+	nonce := header.Get("Wechatpay-Nonce")
+	serial := header.Get("Wechatpay-Serial")
+
+	if signature == "" || timestamp == "" || nonce == "" || serial == "" {
+		return fmt.Errorf("missing wechatpay headers")
+	}
+
+	message := fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body)
+	return verifier.Verify(serial, []byte(message), signature)
+}
@@ -0,0 +1,45 @@
+package wechatpay
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+type stubVerifier struct {
+	serial  string
+	message string
+	err     error
+}
+
+func (v *stubVerifier) Verify(serial string, message []byte, signature string) error {
+	v.serial = serial
+	v.message = string(message)
+	return v.err
+}
+
+func TestVerifyResponse(t *testing.T) {
+	header := http.Header{}
+	header.Set("Wechatpay-Signature", "c2lnbmF0dXJl")
+	header.Set("Wechatpay-Timestamp", "1650000000")
+	header.Set("Wechatpay-Nonce", "nonce")
+	header.Set("Wechatpay-Serial", "5157F09EFDC096DE")
+
+	v := &stubVerifier{}
+	if err := verifyResponse(header, []byte(`{"refund_id":"1"}`), v); err != nil {
+		t.Fatalf("验签失败: %v", err)
+	}
+	if v.serial != "5157F09EFDC096DE" || v.message != "1650000000\nnonce\n{\"refund_id\":\"1\"}\n" {
+		t.Errorf("验签参数不匹配: %q %q", v.serial, v.message)
+	}
+
+	v.err = errors.New("bad signature")
+	if err := verifyResponse(header, []byte("{}"), v); err == nil {
+		t.Error("期望验签失败")
+	}
+
+	header.Del("Wechatpay-Serial")
+	if err := verifyResponse(header, []byte("{}"), &stubVerifier{}); err == nil {
+		t.Error("缺少签名头时期望返回错误")
+	}
+}